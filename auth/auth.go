@@ -0,0 +1,304 @@
+// Package auth drives authorization of the storage backend,
+// so user can log in without a terminal.
+package auth
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/manager"
+)
+
+// PollInterval is how often authorization state is checked.
+const PollInterval = time.Second
+
+// ErrUnexpectedState is returned when auth data is sent
+// while backend does not wait for it.
+var ErrUnexpectedState = errors.New("unexpected auth state")
+
+// State is the authorization state reported to the user.
+type State struct {
+	State string
+	// Error is the error of the last sent auth data.
+	Error string `json:",omitempty"`
+}
+
+// Flow waits for the backend to be authorized, sending configured
+// phone and password, and accepting the rest from the user.
+type Flow struct {
+	// Prompt is asked for auth data that is not configured, if it is set.
+	// Otherwise auth data is expected to be sent by the user through the flow.
+	Prompt func(state string) (string, error)
+
+	authorizer manager.Authorizer
+	phone      string
+	password   string
+
+	// mu guards fields below and serializes requests to the authorizer.
+	mu    sync.Mutex
+	state State
+	// autoSent holds states in which configured values were sent,
+	// so wrong value is not sent again.
+	autoSent map[string]bool
+	// changed wakes up the flow after auth data was sent by the user.
+	changed chan struct{}
+	ready   chan struct{}
+}
+
+// NewFlow returns a flow of authorization.
+//
+// Phone and password are taken from env variables, files or config.
+func NewFlow(authorizer manager.Authorizer, cnf config.Telegram) (*Flow, error) {
+	phone, err := credential("TELEGRAM_PHONE", cnf.PhoneFile, cnf.Phone)
+	if err != nil {
+		return nil, fmt.Errorf("read phone: %w", err)
+	}
+
+	password, err := credential("TELEGRAM_PASSWORD", cnf.PasswordFile, cnf.Password)
+	if err != nil {
+		return nil, fmt.Errorf("read password: %w", err)
+	}
+
+	return &Flow{
+		authorizer: authorizer,
+		phone:      phone,
+		password:   password,
+		state:      State{State: manager.AuthPending},
+		autoSent:   map[string]bool{},
+		changed:    make(chan struct{}, 1),
+		ready:      make(chan struct{}),
+	}, nil
+}
+
+// Run blocks until backend is authorized and started.
+func (f *Flow) Run(ctx context.Context) error {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	var prevState string
+	for {
+		f.mu.Lock()
+		err := f.refresh()
+		if err == nil {
+			err = f.sendConfigured()
+		}
+		state := f.state.State
+		f.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		if state == manager.AuthReady {
+			break
+		}
+
+		if f.Prompt != nil && f.sender(state) != nil {
+			if err := f.prompt(state); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if state != prevState && state != manager.AuthPending {
+			log.Printf("waiting for authorization: %s, open login page of web server\n", state)
+		}
+		prevState = state
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-f.changed:
+		case <-ticker.C:
+		}
+	}
+
+	log.Println("authorized, starting backend")
+	if err := f.authorizer.Start(ctx); err != nil {
+		return fmt.Errorf("start backend: %w", err)
+	}
+
+	close(f.ready)
+
+	return nil
+}
+
+// Ready is closed once backend is authorized and started.
+func (f *Flow) Ready() <-chan struct{} {
+	return f.ready
+}
+
+// State returns the last known authorization state.
+func (f *Flow) State() State {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.state
+}
+
+func (f *Flow) SendPhone(phone string) (State, error) {
+	return f.send(manager.AuthWaitPhone, phone)
+}
+
+func (f *Flow) SendCode(code string) (State, error) {
+	return f.send(manager.AuthWaitCode, code)
+}
+
+func (f *Flow) SendPassword(password string) (State, error) {
+	return f.send(manager.AuthWaitPassword, password)
+}
+
+// prompt asks for auth data requested in the state and sends it.
+func (f *Flow) prompt(state string) error {
+	value, err := f.Prompt(state)
+	if err != nil {
+		return fmt.Errorf("prompt auth data: %w", err)
+	}
+
+	if _, err := f.send(state, value); err != nil && !errors.Is(err, ErrUnexpectedState) {
+		log.Printf("send auth data: %s\n", err.Error())
+	}
+
+	return nil
+}
+
+func (f *Flow) send(want, value string) (State, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.state.State != want {
+		return f.state, fmt.Errorf("%w: %s", ErrUnexpectedState, f.state.State)
+	}
+
+	sendErr := f.sender(want)(value)
+	f.setError(sendErr)
+
+	if err := f.refresh(); err != nil {
+		return f.state, err
+	}
+
+	select {
+	case f.changed <- struct{}{}:
+	default:
+	}
+
+	return f.state, sendErr
+}
+
+// sendConfigured sends configured phone or password
+// if backend waits for it. Each value is sent only once.
+func (f *Flow) sendConfigured() error {
+	var value string
+	switch f.state.State {
+	case manager.AuthWaitPhone:
+		value = f.phone
+	case manager.AuthWaitPassword:
+		value = f.password
+	}
+
+	if value == "" || f.autoSent[f.state.State] {
+		return nil
+	}
+	f.autoSent[f.state.State] = true
+
+	if err := f.sender(f.state.State)(value); err != nil {
+		log.Printf("send configured auth data: %s\n", err.Error())
+		f.setError(err)
+	}
+
+	return f.refresh()
+}
+
+// sender returns function to send auth data requested in the state,
+// or nil if no data is requested.
+func (f *Flow) sender(state string) func(string) error {
+	switch state {
+	case manager.AuthWaitPhone:
+		return f.authorizer.SendPhone
+	case manager.AuthWaitCode:
+		return f.authorizer.SendCode
+	case manager.AuthWaitPassword:
+		return f.authorizer.SendPassword
+	default:
+		return nil
+	}
+}
+
+func (f *Flow) refresh() error {
+	state, err := f.authorizer.AuthState()
+	if err != nil {
+		return err
+	}
+
+	f.state.State = state
+
+	return nil
+}
+
+func (f *Flow) setError(err error) {
+	f.state.Error = ""
+	if err != nil {
+		f.state.Error = err.Error()
+	}
+}
+
+// Prompts of the terminal for auth data.
+const (
+	PhonePrompt    = "Enter phone number: "
+	CodePrompt     = "Enter code: "
+	PasswordPrompt = "Enter Password: "
+)
+
+// TerminalPrompt returns prompt that asks for auth data in the terminal.
+func TerminalPrompt(r io.Reader, w io.Writer) func(state string) (string, error) {
+	prompts := map[string]string{
+		manager.AuthWaitPhone:    PhonePrompt,
+		manager.AuthWaitCode:     CodePrompt,
+		manager.AuthWaitPassword: PasswordPrompt,
+	}
+
+	scanner := bufio.NewScanner(r)
+
+	return func(state string) (string, error) {
+		fmt.Fprint(w, prompts[state])
+
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+
+			return "", io.EOF
+		}
+
+		return strings.TrimSpace(scanner.Text()), nil
+	}
+}
+
+// credential returns value of the env variable, content of the file
+// or the value from config, whichever is set first.
+func credential(env, file, value string) (string, error) {
+	if envValue := os.Getenv(env); envValue != "" {
+		return envValue, nil
+	}
+
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	return value, nil
+}
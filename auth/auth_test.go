@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ffenix113/teleporter/auth/authtest"
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/manager"
+)
+
+func TestFlowRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		env      map[string]string
+		cnf      config.Telegram
+		// answers are returned by the prompt in order.
+		answers []string
+		prompts []string
+		sent    []string
+	}{
+		{
+			name:     "phone, code and password are prompted",
+			password: "secret",
+			answers:  []string{"+100", "12345", "secret"},
+			prompts:  []string{manager.AuthWaitPhone, manager.AuthWaitCode, manager.AuthWaitPassword},
+			sent:     []string{"WaitPhone +100", "WaitCode 12345", "WaitPassword secret"},
+		},
+		{
+			name:    "password is not asked if not required",
+			answers: []string{"+100", "12345"},
+			prompts: []string{manager.AuthWaitPhone, manager.AuthWaitCode},
+			sent:    []string{"WaitPhone +100", "WaitCode 12345"},
+		},
+		{
+			name:     "phone and password are taken from env",
+			password: "secret",
+			env:      map[string]string{"TELEGRAM_PHONE": "+100", "TELEGRAM_PASSWORD": "secret"},
+			cnf:      config.Telegram{Phone: "+999", Password: "wrong"},
+			answers:  []string{"12345"},
+			prompts:  []string{manager.AuthWaitCode},
+			sent:     []string{"WaitPhone +100", "WaitCode 12345", "WaitPassword secret"},
+		},
+		{
+			name:     "wrong code is prompted again",
+			password: "secret",
+			cnf:      config.Telegram{Phone: "+100", Password: "secret"},
+			answers:  []string{"00000", "12345"},
+			prompts:  []string{manager.AuthWaitCode, manager.AuthWaitCode},
+			sent:     []string{"WaitPhone +100", "WaitCode 00000", "WaitCode 12345", "WaitPassword secret"},
+		},
+		{
+			name:     "wrong configured password is sent once and then prompted",
+			password: "secret",
+			cnf:      config.Telegram{Phone: "+100", Password: "wrong"},
+			answers:  []string{"12345", "secret"},
+			prompts:  []string{manager.AuthWaitCode, manager.AuthWaitPassword},
+			sent:     []string{"WaitPhone +100", "WaitCode 12345", "WaitPassword wrong", "WaitPassword secret"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Credentials of the environment must not leak into the test.
+			t.Setenv("TELEGRAM_PHONE", tt.env["TELEGRAM_PHONE"])
+			t.Setenv("TELEGRAM_PASSWORD", tt.env["TELEGRAM_PASSWORD"])
+
+			authorizer := authtest.NewAuthorizer(tt.password)
+			flow, err := NewFlow(authorizer, tt.cnf)
+			if err != nil {
+				t.Fatalf("new flow: %v", err)
+			}
+
+			var prompts []string
+			flow.Prompt = func(state string) (string, error) {
+				prompts = append(prompts, state)
+				if len(prompts) > len(tt.answers) {
+					return "", errors.New("no more answers")
+				}
+
+				return tt.answers[len(prompts)-1], nil
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := flow.Run(ctx); err != nil {
+				t.Fatalf("run: %v", err)
+			}
+
+			if !reflect.DeepEqual(prompts, tt.prompts) {
+				t.Fatalf("prompts: want: %v, got: %v", tt.prompts, prompts)
+			}
+
+			if !reflect.DeepEqual(authorizer.Sent(), tt.sent) {
+				t.Fatalf("sent: want: %v, got: %v", tt.sent, authorizer.Sent())
+			}
+
+			if !authorizer.Started() {
+				t.Fatal("backend must be started after authorization")
+			}
+
+			select {
+			case <-flow.Ready():
+			default:
+				t.Fatal("flow must be ready after authorization")
+			}
+		})
+	}
+}
+
+func TestFlowRunFailsWhenPromptFails(t *testing.T) {
+	t.Setenv("TELEGRAM_PHONE", "")
+	t.Setenv("TELEGRAM_PASSWORD", "")
+
+	flow, err := NewFlow(authtest.NewAuthorizer(""), config.Telegram{})
+	if err != nil {
+		t.Fatalf("new flow: %v", err)
+	}
+
+	flow.Prompt = func(string) (string, error) {
+		return "", errors.New("closed input")
+	}
+
+	if err := flow.Run(context.Background()); err == nil {
+		t.Fatal("run must fail when auth data can not be prompted")
+	}
+}
+
+func TestFlowSendChecksState(t *testing.T) {
+	t.Setenv("TELEGRAM_PHONE", "")
+	t.Setenv("TELEGRAM_PASSWORD", "")
+
+	authorizer := authtest.NewAuthorizer("")
+	flow, err := NewFlow(authorizer, config.Telegram{})
+	if err != nil {
+		t.Fatalf("new flow: %v", err)
+	}
+
+	// State is not known before it is refreshed.
+	if _, err := flow.SendPhone("+100"); !errors.Is(err, ErrUnexpectedState) {
+		t.Fatalf("phone must not be sent in pending state: %v", err)
+	}
+
+	flow.mu.Lock()
+	err = flow.refresh()
+	flow.mu.Unlock()
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	if _, err := flow.SendCode("12345"); !errors.Is(err, ErrUnexpectedState) {
+		t.Fatalf("code must not be sent before phone: %v", err)
+	}
+
+	state, err := flow.SendPhone("+200")
+	if err == nil || state.State != manager.AuthWaitPhone || state.Error == "" {
+		t.Fatalf("wrong phone must be reported in state: %+v, %v", state, err)
+	}
+
+	state, err = flow.SendPhone("+100")
+	if err != nil || state.State != manager.AuthWaitCode || state.Error != "" {
+		t.Fatalf("phone must be accepted: %+v, %v", state, err)
+	}
+}
@@ -0,0 +1,93 @@
+// Package authtest provides a fake manager.Authorizer for tests.
+package authtest
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ffenix113/teleporter/manager"
+)
+
+// Authorizer accepts only expected auth data, asking for it in order.
+// Password is asked after the code only if it is set.
+type Authorizer struct {
+	Phone, Code, Password string
+
+	mu      sync.Mutex
+	state   string
+	sent    []string
+	started bool
+}
+
+// NewAuthorizer returns authorizer that waits for phone "+100" and code "12345".
+func NewAuthorizer(password string) *Authorizer {
+	return &Authorizer{Phone: "+100", Code: "12345", Password: password, state: manager.AuthWaitPhone}
+}
+
+func (a *Authorizer) AuthState() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.state, nil
+}
+
+func (a *Authorizer) SendPhone(phone string) error {
+	return a.send(manager.AuthWaitPhone, a.Phone, phone, manager.AuthWaitCode)
+}
+
+func (a *Authorizer) SendCode(code string) error {
+	next := manager.AuthWaitPassword
+	if a.Password == "" {
+		next = manager.AuthReady
+	}
+
+	return a.send(manager.AuthWaitCode, a.Code, code, next)
+}
+
+func (a *Authorizer) SendPassword(password string) error {
+	return a.send(manager.AuthWaitPassword, a.Password, password, manager.AuthReady)
+}
+
+func (a *Authorizer) send(state, want, value, next string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.sent = append(a.sent, state+" "+value)
+	if a.state != state {
+		return errors.New("unexpected auth data")
+	}
+
+	if value != want {
+		return errors.New("wrong " + state)
+	}
+
+	a.state = next
+
+	return nil
+}
+
+func (a *Authorizer) Start(context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.started = true
+
+	return nil
+}
+
+// Sent returns auth data that was sent, as "<state> <value>".
+func (a *Authorizer) Sent() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]string(nil), a.sent...)
+}
+
+// Started reports whether backend was started.
+func (a *Authorizer) Started() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.started
+}
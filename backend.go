@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ffenix113/teleporter/auth"
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/manager/botapi"
+)
+
+// Backends that can be set in telegram.backend.
+const (
+	backendTDLib  = "tdlib"
+	backendBotAPI = "botapi"
+)
+
+func newBackend(ctx context.Context, cnf config.Telegram) (manager.Manager, error) {
+	switch cnf.Backend {
+	case "", backendTDLib:
+		return newTDLibBackend(ctx, cnf)
+	case backendBotAPI:
+		backend, err := botapi.NewClient(ctx, cnf)
+		if err != nil {
+			return nil, err
+		}
+
+		return backend, nil
+	default:
+		return nil, fmt.Errorf("unknown backend: %q", cnf.Backend)
+	}
+}
+
+// newAuthBackend creates backend and the flow to authorize it,
+// which is nil if backend does not require authorization.
+func newAuthBackend(ctx context.Context, cnf config.Telegram) (manager.Manager, *auth.Flow, error) {
+	backend, err := newBackend(ctx, cnf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create backend: %w", err)
+	}
+
+	authorizer, ok := backend.(manager.Authorizer)
+	if !ok {
+		return backend, nil, nil
+	}
+
+	flow, err := auth.NewFlow(authorizer, cnf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return backend, flow, nil
+}
+
+// folderBackends returns backends for chats of the folders, where first
+// is the backend of the first folder. Other backends share its session
+// if it is supported.
+func folderBackends(ctx context.Context, first manager.Manager, cnf config.Config, folders []config.Folder) ([]manager.Manager, error) {
+	backends := []manager.Manager{first}
+	for _, folder := range folders[1:] {
+		var backend manager.Manager
+		var err error
+		if session, ok := first.(manager.Session); ok {
+			backend, err = session.ForChat(ctx, folder.ChatName, folder.ChatID)
+		} else {
+			backend, err = newBackend(ctx, cnf.ForFolder(folder).Telegram)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("create backend of folder %q: %w", folder.Name, err)
+		}
+
+		backends = append(backends, backend)
+	}
+
+	return backends, nil
+}
@@ -0,0 +1,15 @@
+//go:build !cgo
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/manager"
+)
+
+func newTDLibBackend(_ context.Context, _ config.Telegram) (manager.Manager, error) {
+	return nil, errors.New("tdlib backend is not available in build without cgo, use botapi backend")
+}
@@ -0,0 +1,16 @@
+//go:build cgo
+
+package main
+
+import (
+	"context"
+
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/manager/arman92"
+)
+
+// newTDLibBackend returns tdlib client, which has to be authorized before it is used.
+func newTDLibBackend(_ context.Context, cnf config.Telegram) (manager.Manager, error) {
+	return arman92.NewClient(cnf), nil
+}
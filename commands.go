@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ffenix113/teleporter/auth"
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/fsnotify"
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/manager/engine"
+	"github.com/ffenix113/teleporter/tasks"
+	"github.com/ffenix113/teleporter/web"
+)
+
+// errUsage is returned by commands called with wrong arguments.
+var errUsage = errors.New("wrong usage")
+
+type command struct {
+	Name  string
+	Usage string
+	Help  string
+	Run   func(ctx context.Context, cnf config.Config, args []string) error
+}
+
+// commands returns list of commands. It is a function,
+// as help command refers to the list itself.
+func commands() []command {
+	return []command{
+		{Name: "serve", Usage: "serve", Help: "start web server, synchronize files and watch for changes (default)", Run: runServe},
+		{Name: "sync", Usage: "sync [-once] [-folder name]", Help: "synchronize files and watch for changes without web server", Run: runSync},
+		{Name: "ls", Usage: "ls [-folder name] [path]", Help: "list remote directory", Run: runList},
+		{Name: "get", Usage: "get [-folder name] <path> [destination|-]", Help: "download remote file, into stdout if destination is -", Run: runGet},
+		{Name: "put", Usage: "put [-folder name] <local file> [path]", Help: "copy local file into files directory and upload it", Run: runPut},
+		{Name: "rm", Usage: "rm [-folder name] <path>", Help: "delete remote file or directory", Run: runRemove},
+		{Name: "status", Usage: "status [-folder name]", Help: "show remote header and unfinished tasks", Run: runStatus},
+		{Name: "verify", Usage: "verify [-remote] [-folder name]", Help: "compare local files with hashes in remote header", Run: runVerify},
+		{Name: "help", Usage: "help", Help: "show this help", Run: func(context.Context, config.Config, []string) error {
+			printUsage()
+			return nil
+		}},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands() {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+
+	return command{}, false
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: teleporter <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.Usage, cmd.Help)
+	}
+	w.Flush()
+}
+
+// syncFolder is a sync folder with its client.
+type syncFolder struct {
+	config.Folder
+	Client *engine.Client
+}
+
+// openFolders creates clients of the folders for one-shot commands,
+// of all folders if name is empty.
+//
+// Auth data that is not configured is asked in the terminal.
+func openFolders(ctx context.Context, cnf config.Config, name string, opts ...engine.Option) ([]syncFolder, error) {
+	folders, err := selectFolders(cnf, name)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, flow, err := newAuthBackend(ctx, cnf.ForFolder(folders[0]).Telegram)
+	if err != nil {
+		return nil, err
+	}
+
+	if flow != nil {
+		flow.Prompt = auth.TerminalPrompt(os.Stdin, os.Stderr)
+		if err := flow.Run(ctx); err != nil {
+			return nil, fmt.Errorf("authenticate: %w", err)
+		}
+	}
+
+	return newSyncFolders(ctx, cnf, folders, backend, opts...)
+}
+
+// openClient creates client of the folder with the name,
+// or of the first folder if name is empty.
+func openClient(ctx context.Context, cnf config.Config, name string, opts ...engine.Option) (*engine.Client, error) {
+	if name == "" {
+		folders, err := cnf.SyncFolders()
+		if err != nil {
+			return nil, err
+		}
+
+		name = folders[0].Name
+	}
+
+	folders, err := openFolders(ctx, cnf, name, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return folders[0].Client, nil
+}
+
+// selectFolders returns the folder with the name, or all folders if name is empty.
+func selectFolders(cnf config.Config, name string) ([]config.Folder, error) {
+	folders, err := cnf.SyncFolders()
+	if err != nil || name == "" {
+		return folders, err
+	}
+
+	for _, folder := range folders {
+		if folder.Name == name {
+			return []config.Folder{folder}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown folder %q", name)
+}
+
+// newSyncFolders creates clients of the folders, where backend
+// is the authorized backend of the first folder.
+func newSyncFolders(ctx context.Context, cnf config.Config, folders []config.Folder, backend manager.Manager, opts ...engine.Option) ([]syncFolder, error) {
+	backends, err := folderBackends(ctx, backend, cnf, folders)
+	if err != nil {
+		return nil, err
+	}
+
+	synced := make([]syncFolder, 0, len(folders))
+	for i, folder := range folders {
+		cl, err := engine.NewClient(ctx, cnf.ForFolder(folder), backends[i], opts...)
+		if err != nil {
+			return nil, fmt.Errorf("create client of folder %q: %w", folder.Name, err)
+		}
+
+		synced = append(synced, syncFolder{Folder: folder, Client: cl})
+	}
+
+	return synced, nil
+}
+
+// parseFlags parses flags of the command and checks number of positional arguments.
+func parseFlags(flags *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if flags.NArg() < minArgs || flags.NArg() > maxArgs {
+		return errUsage
+	}
+
+	return nil
+}
+
+func runServe(ctx context.Context, cnf config.Config, args []string) error {
+	if err := parseFlags(flag.NewFlagSet("serve", flag.ContinueOnError), args, 0, 0); err != nil {
+		return err
+	}
+
+	folders, err := cnf.SyncFolders()
+	if err != nil {
+		return err
+	}
+
+	log.Println("create telegram client")
+	backend, flow, err := newAuthBackend(context.Background(), cnf.ForFolder(folders[0]).Telegram)
+	if err != nil {
+		return err
+	}
+
+	// Backend that requires login is authorized through the web server,
+	// so it is started before the clients.
+	log.Println("starting web server")
+	clientRoutes := web.NewClientRoutes(cnf.App, flow)
+	go web.Listen(cnf.App.WebListen, web.NewRouter(cnf, cnf.App.TemplatePath, flow, clientRoutes))
+
+	if flow != nil {
+		log.Println("authenticating")
+		if err := flow.Run(ctx); err != nil {
+			return fmt.Errorf("authenticate: %w", err)
+		}
+	}
+
+	log.Println("create clients")
+	synced, err := newSyncFolders(context.Background(), cnf, folders, backend)
+	if err != nil {
+		return err
+	}
+
+	webFolders := make([]web.Folder, 0, len(synced))
+	for _, folder := range synced {
+		webFolders = append(webFolders, web.Folder{Name: folder.Name, Client: folder.Client})
+	}
+	clientRoutes.SetFolders(webFolders)
+
+	return watch(ctx, synced)
+}
+
+// watch synchronizes files of the folders and watches for changes till context is done.
+func watch(ctx context.Context, folders []syncFolder) error {
+	listeners := make([]io.Closer, 0, len(folders))
+	for _, folder := range folders {
+		log.Println("update files state on start:", folder.Name)
+		if err := folder.Client.SynchronizeFiles(); err != nil {
+			return fmt.Errorf("synchronize folder %q: %w", folder.Name, err)
+		}
+		log.Println("update files state on start done:", folder.Name)
+
+		log.Println("start file listener:", folder.Name)
+		listeners = append(listeners, fsnotify.NewListener(folder.FilesPath, folder.Client))
+	}
+
+	log.Println("waiting for exit")
+	<-ctx.Done()
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	log.Println("Shutdown", ctx.Err().Error())
+
+	return nil
+}
+
+func runSync(ctx context.Context, cnf config.Config, args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	once := flags.Bool("once", false, "")
+	folder := flags.String("folder", "", "")
+	if err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	folders, err := openFolders(ctx, cnf, *folder)
+	if err != nil {
+		return err
+	}
+
+	if !*once {
+		return watch(ctx, folders)
+	}
+
+	for _, folder := range folders {
+		if err := folder.Client.SynchronizeFiles(); err != nil {
+			return fmt.Errorf("synchronize folder %q: %w", folder.Name, err)
+		}
+	}
+
+	var failed int
+	for _, folder := range folders {
+		if err := folder.Client.TaskMonitor.Wait(ctx); err != nil {
+			return err
+		}
+
+		for _, info := range folder.Client.TaskMonitor.Find(tasks.Filter{Status: tasks.TaskStatusError.String()}, 0, -1) {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %s %s: %s\n", folder.Name, info.Type, info.Name, info.Details)
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d tasks failed", failed)
+	}
+
+	return nil
+}
+
+func runList(ctx context.Context, cnf config.Config, args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ContinueOnError)
+	folder := flags.String("folder", "", "")
+	if err := parseFlags(flags, args, 0, 1); err != nil {
+		return err
+	}
+
+	cl, err := openClient(ctx, cnf, *folder, engine.OneShot(), engine.PauseTasks())
+	if err != nil {
+		return err
+	}
+
+	dirPath := strings.Trim(flags.Arg(0), "/")
+	files, ok := cl.ListDir(dirPath)
+	if !ok {
+		if file, isFile := cl.FindFile(dirPath); isFile {
+			files = []*manager.File{&file}
+		} else {
+			return fmt.Errorf("%q: %w", dirPath, os.ErrNotExist)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, file := range files {
+		if file.IsDir {
+			fmt.Fprintf(w, "d\t\t\t%s/\n", file.Name)
+			continue
+		}
+
+		fmt.Fprintf(w, "-\t%d\t%s\t%s\n", file.Size, file.FileUpdatedAt.Format("2006-01-02 15:04:05"), file.Name)
+	}
+
+	return w.Flush()
+}
+
+func runGet(ctx context.Context, cnf config.Config, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	folder := flags.String("folder", "", "")
+	if err := parseFlags(flags, args, 1, 2); err != nil {
+		return err
+	}
+
+	cl, err := openClient(ctx, cnf, *folder, engine.OneShot(), engine.PauseTasks())
+	if err != nil {
+		return err
+	}
+
+	remotePath := strings.Trim(flags.Arg(0), "/")
+	if _, ok := cl.HeaderFile(remotePath); !ok {
+		return fmt.Errorf("%q: %w", remotePath, os.ErrNotExist)
+	}
+
+	destination := flags.Arg(1)
+	if destination == "-" {
+		return cl.StreamFile(ctx, os.Stdout, remotePath)
+	}
+
+	if destination == "" {
+		destination = path.Base(remotePath)
+	} else if stat, err := os.Stat(destination); err == nil && stat.IsDir() {
+		destination = filepath.Join(destination, path.Base(remotePath))
+	}
+
+	// File is downloaded next to destination, so partial file never replaces it.
+	f, err := os.CreateTemp(filepath.Dir(destination), ".teleporter_*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := cl.StreamFile(ctx, f, remotePath); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close file: %w", err)
+	}
+
+	return os.Rename(f.Name(), destination)
+}
+
+func runPut(ctx context.Context, cnf config.Config, args []string) error {
+	flags := flag.NewFlagSet("put", flag.ContinueOnError)
+	folder := flags.String("folder", "", "")
+	if err := parseFlags(flags, args, 1, 2); err != nil {
+		return err
+	}
+
+	localPath, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	cl, err := openClient(ctx, cnf, *folder, engine.OneShot())
+	if err != nil {
+		return err
+	}
+
+	remotePath := strings.Trim(flags.Arg(1), "/")
+	switch {
+	case remotePath == "" && strings.HasPrefix(localPath, cl.FilesPath):
+		remotePath = cl.RelativePath(localPath)
+	case remotePath == "":
+		remotePath = filepath.Base(localPath)
+	case strings.HasSuffix(flags.Arg(1), "/"):
+		remotePath = path.Join(remotePath, filepath.Base(localPath))
+	}
+
+	if cl.Ignore.Ignored(remotePath, false) {
+		return fmt.Errorf("path is ignored: %q", remotePath)
+	}
+
+	if absPath := cl.AbsPath(remotePath); absPath != localPath {
+		if err := copyFile(localPath, absPath); err != nil {
+			return err
+		}
+	}
+
+	return cl.PutFile(ctx, remotePath)
+}
+
+// copyFile copies the file, creating destination directory if needed.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("create dir: %w", err)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copy file: %w", err)
+	}
+
+	return out.Close()
+}
+
+func runRemove(ctx context.Context, cnf config.Config, args []string) error {
+	flags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	folder := flags.String("folder", "", "")
+	if err := parseFlags(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	remotePath := strings.Trim(flags.Arg(0), "/")
+	if remotePath == "" {
+		return errors.New("root directory can not be deleted")
+	}
+
+	cl, err := openClient(ctx, cnf, *folder, engine.OneShot())
+	if err != nil {
+		return err
+	}
+
+	if _, ok := cl.FindFile(remotePath); ok {
+		return cl.DeleteFile(ctx, remotePath)
+	}
+
+	if _, ok := cl.ListDir(remotePath); !ok {
+		return fmt.Errorf("%q: %w", remotePath, os.ErrNotExist)
+	}
+
+	results, err := cl.DeleteDir(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %s\n", result.Path, result.Error)
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d files were not deleted", failed)
+	}
+
+	return nil
+}
+
+func runStatus(ctx context.Context, cnf config.Config, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	folder := flags.String("folder", "", "")
+	if err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	cl, err := openClient(ctx, cnf, *folder, engine.OneShot(), engine.PauseTasks())
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	headerFiles := cl.HeaderFiles()
+	for relativePath := range headerFiles {
+		if file, ok := cl.FindFile(relativePath); ok {
+			size += file.Size
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Connection:\t%s\n", cl.ConnectionState())
+	fmt.Fprintf(w, "Encrypted:\t%t\n", cl.Cipher != nil)
+	fmt.Fprintf(w, "Files:\t%d\n", len(headerFiles))
+	fmt.Fprintf(w, "Size:\t%d\n", size)
+	fmt.Fprintf(w, "Trash:\t%d\n", len(cl.Trash()))
+
+	// Unfinished tasks are not replayed by one-shot client, so they are read
+	// from the journal. Tasks of the client are errors found while reading the header.
+	records := cl.TaskMonitor.Unfinished()
+	infos := cl.TaskMonitor.Find(tasks.Filter{}, 0, -1)
+	fmt.Fprintf(w, "Tasks:\t%d\n", len(records)+len(infos))
+	for _, record := range records {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", record.Type, record.Name, record.Status, record.Details)
+	}
+	for _, info := range infos {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", info.Type, info.Name, info.Status, info.Details)
+	}
+
+	return w.Flush()
+}
+
+func runVerify(ctx context.Context, cnf config.Config, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	remote := flags.Bool("remote", false, "")
+	folder := flags.String("folder", "", "")
+	if err := parseFlags(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	cl, err := openClient(ctx, cnf, *folder, engine.OneShot(), engine.PauseTasks())
+	if err != nil {
+		return err
+	}
+
+	results, err := cl.Verify(ctx, *remote)
+	if err != nil {
+		return err
+	}
+
+	var problems int
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, result := range results {
+		if result.Status == engine.VerifyOK {
+			continue
+		}
+
+		problems++
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.Status, result.Path, result.Details)
+	}
+	w.Flush()
+
+	if problems != 0 {
+		return fmt.Errorf("%d of %d files differ", problems, len(results))
+	}
+
+	return nil
+}
@@ -1,17 +1,117 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"time"
 
-	"github.com/Arman92/go-tdlib/v2/client"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	App      App
 	Telegram Telegram
+	// Folders are pairs of local folder and chat, which are synchronized
+	// independently through the same Telegram session. If not set,
+	// app.filespath is synchronized with telegram.chatname or chatid.
+	Folders []Folder
+}
+
+// DefaultFolder is the name of the folder set in app.filespath.
+const DefaultFolder = "default"
+
+// folderNameRegexp limits folder names, as they are used in URLs and file names.
+var folderNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Folder is a local folder, files of which are stored in the chat.
+type Folder struct {
+	// Name identifies the folder in web API and commands.
+	Name      string
+	FilesPath string
+	ChatName  string
+	ChatID    int64
+	// TempPath, JournalPath, StatePath and SelectionPath default
+	// to paths of this folder, so they are not shared with other folders.
+	TempPath      string
+	JournalPath   string
+	StatePath     string
+	SelectionPath string
+	SyncInclude   []string
+	SyncExclude   []string
+}
+
+// SyncFolders returns the folders to synchronize.
+func (c Config) SyncFolders() ([]Folder, error) {
+	if len(c.Folders) == 0 {
+		return []Folder{{
+			Name:        DefaultFolder,
+			FilesPath:   c.App.FilesPath,
+			ChatName:    c.Telegram.ChatName,
+			ChatID:      c.Telegram.ChatID,
+			TempPath:    c.App.TempPath,
+			JournalPath: c.App.JournalPath,
+			StatePath:   c.App.StatePath,
+
+			SelectionPath: c.App.SelectionPath,
+			SyncInclude:   c.App.SyncInclude,
+			SyncExclude:   c.App.SyncExclude,
+		}}, nil
+	}
+
+	names := map[string]bool{}
+	for _, folder := range c.Folders {
+		switch {
+		case folder.Name == "":
+			return nil, errors.New("folder name is not set")
+		case !folderNameRegexp.MatchString(folder.Name):
+			return nil, fmt.Errorf("folder name %q may contain only letters, digits, '.', '_' and '-'", folder.Name)
+		case names[folder.Name]:
+			return nil, fmt.Errorf("folder %q is set more than once", folder.Name)
+		case folder.FilesPath == "":
+			return nil, fmt.Errorf("files path of folder %q is not set", folder.Name)
+		case folder.ChatName == "" && folder.ChatID == 0:
+			return nil, fmt.Errorf("chat of folder %q is not set", folder.Name)
+		}
+
+		names[folder.Name] = true
+	}
+
+	return c.Folders, nil
+}
+
+// ForFolder returns config to synchronize the folder.
+func (c Config) ForFolder(folder Folder) Config {
+	c.App.FilesPath = folder.FilesPath
+	c.App.TempPath = folder.TempPath
+	c.App.JournalPath = folder.JournalPath
+	c.App.StatePath = folder.StatePath
+	c.App.SelectionPath = folder.SelectionPath
+	c.App.SyncInclude = folder.SyncInclude
+	c.App.SyncExclude = folder.SyncExclude
+	c.Telegram.ChatName = folder.ChatName
+	c.Telegram.ChatID = folder.ChatID
+
+	if len(c.Folders) != 0 {
+		dataDir := filepath.Dir(c.Telegram.Config.DatabaseDirectory)
+		if c.App.JournalPath == "" {
+			c.App.JournalPath = path.Join(dataDir, "tasks_"+folder.Name+".jsonl")
+		}
+
+		if c.App.StatePath == "" {
+			c.App.StatePath = path.Join(dataDir, "state_"+folder.Name+".jsonl")
+		}
+
+		if c.App.SelectionPath == "" {
+			c.App.SelectionPath = path.Join(dataDir, "selection_"+folder.Name+".json")
+		}
+	}
+
+	return c
 }
 
 // App holds Telegram config
@@ -22,13 +122,120 @@ type App struct {
 	WebListen    string
 	TemplatePath string
 	IPWhitelist  []string
+	// PartSizeMB is the max size of one uploaded document.
+	// Larger files will be split in parts of this size.
+	PartSizeMB int
+	// Workers is the number of tasks that can be executed concurrently.
+	Workers int
+	// TaskLimits limits number of concurrently executed tasks
+	// per task type, e.g. UploadFile: 2.
+	TaskLimits map[string]int
+	// RetryMaxAttempts is the max number of times a failed task will be run.
+	// Set to 1 to disable retries.
+	RetryMaxAttempts int
+	// RetryBaseDelay is the delay before the first retry,
+	// which is doubled for each next retry up to RetryMaxDelay.
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// JournalPath is the path of the file where tasks are persisted.
+	// Defaults to tasks.jsonl next to tdlib database directory.
+	JournalPath string
+	// JournalRetention specifies how long finished tasks are kept in the journal.
+	JournalRetention time.Duration
+	// KeepFinishedTasks is how many finished tasks are listed, older ones are forgotten.
+	KeepFinishedTasks int
+	// EncryptionPassphrase enables encryption of files, captions
+	// and the pinned header with key derived from this passphrase.
+	EncryptionPassphrase string
+	// EncryptionKeyFile enables encryption with key derived
+	// from the content of the file. Takes precedence over passphrase.
+	EncryptionKeyFile string
+	// IgnorePatterns are gitignore-style patterns of files that are not synchronized.
+	// They are applied together with patterns from .teleporterignore files.
+	IgnorePatterns []string
+	// StatePath is the path of the file with state of files at last synchronization.
+	// Defaults to state.jsonl next to tdlib database directory.
+	StatePath string
+	// ConflictPolicy specifies what to do when file was changed both locally and remotely:
+	// keep-both (default), prefer-local or prefer-remote.
+	ConflictPolicy string
+	// DeviceName is used in names of conflict copies. Defaults to hostname.
+	DeviceName string
+	// VersionsKeep enables versioning: changed files are uploaded as new messages
+	// and this number of previous versions is kept for each file.
+	VersionsKeep int
+	// VersionsRetention enables versioning, keeping previous versions for this long.
+	// If both are set, version is removed when any of the limits is reached.
+	VersionsRetention time.Duration
+	// TrashRetention is how long messages of deleted files are kept,
+	// so files can be restored. Default is 720h, negative value disables trash.
+	TrashRetention time.Duration
+	// SyncInclude and SyncExclude are gitignore-style patterns of remote files
+	// that are downloaded to this device. If SyncInclude is set, only matching
+	// files are downloaded. Other files stay cloud only.
+	SyncInclude []string
+	SyncExclude []string
+	// MaxDownloadSizeMB limits size of files served by the web server from disk.
+	// Files streamed from the chat are not limited.
+	// Default is 60, negative value disables the limit.
+	MaxDownloadSizeMB int
+	// CacheSizeMB is the max size of files downloaded on demand by the web server,
+	// which are kept in temp dir. Default is 1024, negative value disables cache.
+	CacheSizeMB int
+	// SelectionPath is the path of the file with directories switched
+	// between synced and cloud only. Defaults to selection.json
+	// next to tdlib database directory.
+	SelectionPath string
 }
 
 type Telegram struct {
+	// Backend is the client used to access Telegram: tdlib (default) or botapi.
+	Backend string
+	// ChatName is the name of the chat to search for with tdlib,
+	// or @username of the channel with botapi.
 	ChatName string
 	ChatID   int64
 	LogLevel int `default:"2"`
-	Config   client.Config
+	Config   TDLib
+	// BotToken is the token of the bot used by botapi backend.
+	BotToken string
+	// BotAPIURL is the URL of Bot API server. Local Bot API server
+	// allows to upload and download files larger than 50 and 20 MB.
+	BotAPIURL string
+	// PollInterval specifies how often botapi backend checks the header for changes.
+	PollInterval time.Duration
+	// Phone and Password are sent automatically when tdlib asks for them,
+	// otherwise they have to be entered on the login page.
+	// TELEGRAM_PHONE and TELEGRAM_PASSWORD env variables take precedence.
+	Phone    string
+	Password string
+	// PhoneFile and PasswordFile are files with phone and password,
+	// e.g. mounted secrets. They take precedence over values in config.
+	PhoneFile    string
+	PasswordFile string
+}
+
+// TDLib holds parameters of the tdlib client.
+//
+// It has the same fields as client.Config of go-tdlib,
+// so config does not depend on cgo.
+type TDLib struct {
+	APIID              string
+	APIHash            string
+	SystemLanguageCode string
+	DeviceModel        string
+	SystemVersion      string
+	ApplicationVersion string
+	// Optional fields
+	UseTestDataCenter      bool
+	DatabaseDirectory      string
+	FileDirectory          string
+	UseFileDatabase        bool
+	UseChatInfoDatabase    bool
+	UseMessageDatabase     bool
+	UseSecretChats         bool
+	EnableStorageOptimizer bool
+	IgnoreFileNames        bool
 }
 
 func Load() (c Config) {
@@ -44,7 +251,7 @@ func Load() (c Config) {
 		panic(err)
 	}
 
-	c.Telegram.Config = client.Config{
+	c.Telegram.Config = TDLib{
 		SystemLanguageCode:  "en",
 		DeviceModel:         "Server",
 		SystemVersion:       "1.0.0",
@@ -0,0 +1,115 @@
+package config
+
+import "testing"
+
+func TestForFolderDefaultsPaths(t *testing.T) {
+	telegram := Telegram{Config: TDLib{DatabaseDirectory: "/data/.tdlib/database"}}
+
+	tests := []struct {
+		name      string
+		cnf       Config
+		folder    Folder
+		journal   string
+		state     string
+		selection string
+	}{
+		{
+			name: "single folder keeps app paths",
+			cnf: Config{
+				App:      App{FilesPath: "/files", JournalPath: "/data/tasks.jsonl"},
+				Telegram: telegram,
+			},
+			folder:  Folder{Name: DefaultFolder, FilesPath: "/files", JournalPath: "/data/tasks.jsonl"},
+			journal: "/data/tasks.jsonl",
+		},
+		{
+			name: "folder paths default to its name",
+			cnf: Config{
+				Telegram: telegram,
+				Folders:  []Folder{{Name: "photos", FilesPath: "/photos", ChatID: 1}},
+			},
+			folder:    Folder{Name: "photos", FilesPath: "/photos", ChatID: 1},
+			journal:   "/data/.tdlib/tasks_photos.jsonl",
+			state:     "/data/.tdlib/state_photos.jsonl",
+			selection: "/data/.tdlib/selection_photos.json",
+		},
+		{
+			name: "folder paths that are set are kept",
+			cnf: Config{
+				Telegram: telegram,
+				Folders:  []Folder{{Name: "docs", FilesPath: "/docs", ChatID: 2}},
+			},
+			folder: Folder{
+				Name: "docs", FilesPath: "/docs", ChatID: 2,
+				JournalPath: "/docs-data/tasks.jsonl", StatePath: "/docs-data/state.jsonl", SelectionPath: "/docs-data/selection.json",
+			},
+			journal:   "/docs-data/tasks.jsonl",
+			state:     "/docs-data/state.jsonl",
+			selection: "/docs-data/selection.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := tt.cnf.ForFolder(tt.folder).App
+
+			if app.FilesPath != tt.folder.FilesPath {
+				t.Fatalf("files path: want: %q, got: %q", tt.folder.FilesPath, app.FilesPath)
+			}
+
+			if app.JournalPath != tt.journal {
+				t.Fatalf("journal path: want: %q, got: %q", tt.journal, app.JournalPath)
+			}
+
+			if app.StatePath != tt.state {
+				t.Fatalf("state path: want: %q, got: %q", tt.state, app.StatePath)
+			}
+
+			if app.SelectionPath != tt.selection {
+				t.Fatalf("selection path: want: %q, got: %q", tt.selection, app.SelectionPath)
+			}
+		})
+	}
+}
+
+func TestSyncFoldersDefaultsToAppFolder(t *testing.T) {
+	cnf := Config{
+		App:      App{FilesPath: "/files", StatePath: "/data/state.jsonl"},
+		Telegram: Telegram{ChatName: "files"},
+	}
+
+	folders, err := cnf.SyncFolders()
+	if err != nil {
+		t.Fatalf("sync folders: %v", err)
+	}
+
+	if len(folders) != 1 {
+		t.Fatalf("want: 1 folder, got: %v", folders)
+	}
+
+	folder := folders[0]
+	if folder.Name != DefaultFolder || folder.FilesPath != "/files" || folder.ChatName != "files" || folder.StatePath != "/data/state.jsonl" {
+		t.Fatalf("folder must be taken from app config, got: %+v", folder)
+	}
+}
+
+func TestSyncFoldersRejectsInvalidFolders(t *testing.T) {
+	tests := []struct {
+		name    string
+		folders []Folder
+	}{
+		{name: "no name", folders: []Folder{{FilesPath: "/a", ChatID: 1}}},
+		{name: "name used in URLs", folders: []Folder{{Name: "a/b", FilesPath: "/a", ChatID: 1}}},
+		{name: "duplicate name", folders: []Folder{{Name: "a", FilesPath: "/a", ChatID: 1}, {Name: "a", FilesPath: "/b", ChatID: 2}}},
+		{name: "no files path", folders: []Folder{{Name: "a", ChatID: 1}}},
+		{name: "no chat", folders: []Folder{{Name: "a", FilesPath: "/a"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := (Config{Folders: tt.folders}).SyncFolders(); err == nil {
+				t.Fatal("invalid folders must be rejected")
+			}
+		})
+	}
+}
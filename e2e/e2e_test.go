@@ -0,0 +1,1493 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/fsnotify"
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/manager/engine"
+	"github.com/ffenix113/teleporter/manager/fake"
+	"github.com/ffenix113/teleporter/tasks"
+	"github.com/ffenix113/teleporter/web"
+	"github.com/ffenix113/teleporter/web/handler"
+)
+
+// waitTimeout is long enough for debounce of the file listener.
+const waitTimeout = 15 * time.Second
+
+type testClient struct {
+	*engine.Client
+	dir string
+}
+
+func newServer(t *testing.T) *fake.Server {
+	t.Helper()
+
+	return fake.NewServer(t.TempDir())
+}
+
+// newClient starts a client with empty files directory, connected to the chat.
+func newClient(t *testing.T, chat *fake.Chat, configure ...func(app *config.App)) *testClient {
+	t.Helper()
+
+	return newClientWithOptions(t, chat, nil, configure...)
+}
+
+func newClientWithOptions(t *testing.T, chat *fake.Chat, opts []engine.Option, configure ...func(app *config.App)) *testClient {
+	t.Helper()
+
+	dataDir := t.TempDir()
+	filesDir := t.TempDir()
+
+	cnf := config.Config{App: config.App{
+		FilesPath:        filesDir,
+		TempPath:         filepath.Join(dataDir, "tmp"),
+		JournalPath:      filepath.Join(dataDir, "tasks.jsonl"),
+		StatePath:        filepath.Join(dataDir, "state.jsonl"),
+		SelectionPath:    filepath.Join(dataDir, "selection.json"),
+		DeviceName:       "test",
+		RetryMaxAttempts: 3,
+		RetryBaseDelay:   10 * time.Millisecond,
+		RetryMaxDelay:    50 * time.Millisecond,
+	}}
+	for _, fn := range configure {
+		fn(&cnf.App)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cl, err := engine.NewClient(ctx, cnf, chat.Connect(), opts...)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	return &testClient{Client: cl, dir: filesDir}
+}
+
+// startListener starts watching files directory of the client.
+func (c *testClient) startListener(t *testing.T) {
+	t.Helper()
+
+	listener := fsnotify.NewListener(c.dir, c.Client)
+	t.Cleanup(func() { listener.Close() })
+}
+
+func (c *testClient) writeFile(t *testing.T, relativePath, content string) {
+	t.Helper()
+
+	filePath := filepath.Join(c.dir, relativePath)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatalf("create dir: %v", err)
+	}
+
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+}
+
+func (c *testClient) readFile(relativePath string) (string, bool) {
+	content, err := os.ReadFile(filepath.Join(c.dir, relativePath))
+	if err != nil {
+		return "", false
+	}
+
+	return string(content), true
+}
+
+// uploaded returns content of the document with the latest version of the file.
+func (c *testClient) uploaded(chat *fake.Chat, relativePath string) (string, bool) {
+	msgID, ok := c.HeaderFile(relativePath)
+	if !ok {
+		return "", false
+	}
+
+	msg, ok := chat.Message(msgID)
+	if !ok {
+		return "", false
+	}
+
+	return string(msg.Document), true
+}
+
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func documents(chat *fake.Chat) int {
+	var count int
+	for _, msg := range chat.Messages() {
+		if msg.Document != nil {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestFirstStartCreatesHeader(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	newClient(t, chat)
+
+	if header := chat.Header(); !strings.Contains(header, `"Header": "Teleporter"`) {
+		t.Fatalf("unexpected header: %q", header)
+	}
+}
+
+func TestSynchronizeFilesUploadsLocalFiles(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	cl.writeFile(t, "a.txt", "first")
+	cl.writeFile(t, "dir/b.txt", "second")
+
+	if err := cl.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "files to be uploaded", func() bool {
+		a, okA := cl.uploaded(chat, "a.txt")
+		b, okB := cl.uploaded(chat, "dir/b.txt")
+
+		return okA && okB && a == "first" && b == "second"
+	})
+
+	if !strings.Contains(chat.Header(), "dir/b.txt") {
+		t.Fatalf("header does not contain uploaded file: %q", chat.Header())
+	}
+}
+
+func TestSynchronizeFilesDownloadsRemoteFiles(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	first := newClient(t, chat)
+
+	first.writeFile(t, "docs/readme.md", "hello")
+	if err := first.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "file to be uploaded", func() bool {
+		_, ok := first.uploaded(chat, "docs/readme.md")
+		return ok
+	})
+
+	second := newClient(t, chat)
+	if err := second.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "file to be downloaded", func() bool {
+		content, ok := second.readFile("docs/readme.md")
+		return ok && content == "hello"
+	})
+}
+
+func TestWatcherUploadsCreatedAndChangedFiles(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+	cl.startListener(t)
+
+	cl.writeFile(t, "notes.txt", "v1")
+	eventually(t, "created file to be uploaded", func() bool {
+		content, ok := cl.uploaded(chat, "notes.txt")
+		return ok && content == "v1"
+	})
+
+	cl.writeFile(t, "notes.txt", "v2")
+	eventually(t, "changed file to be uploaded", func() bool {
+		content, ok := cl.uploaded(chat, "notes.txt")
+		return ok && content == "v2"
+	})
+
+	if count := documents(chat); count != 1 {
+		t.Fatalf("changed file must replace the document: want: 1 document, got: %d", count)
+	}
+}
+
+func TestWatcherDeletesRemovedFile(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat, func(app *config.App) {
+		app.TrashRetention = -1
+	})
+	cl.startListener(t)
+
+	cl.writeFile(t, "tmp.txt", "data")
+	eventually(t, "file to be uploaded", func() bool {
+		_, ok := cl.uploaded(chat, "tmp.txt")
+		return ok
+	})
+
+	if err := os.Remove(filepath.Join(cl.dir, "tmp.txt")); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+
+	eventually(t, "file to be deleted", func() bool {
+		_, ok := cl.HeaderFile("tmp.txt")
+		return !ok && documents(chat) == 0
+	})
+}
+
+// renameWatched uploads the files, starts the listener and renames
+// oldPath into newPath. It returns message IDs of the files before rename.
+func renameWatched(t *testing.T, cl *testClient, files []string, oldPath, newPath string) map[string]int64 {
+	t.Helper()
+
+	for _, relativePath := range files {
+		cl.writeFile(t, relativePath, relativePath)
+	}
+	if err := cl.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "files to be uploaded", func() bool {
+		return len(cl.HeaderFiles()) == len(files)
+	})
+
+	msgIDs := cl.HeaderFiles()
+	cl.startListener(t)
+
+	if err := os.Rename(filepath.Join(cl.dir, oldPath), filepath.Join(cl.dir, newPath)); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+
+	return msgIDs
+}
+
+// assertNoReupload checks that moved files were not deleted and uploaded again.
+func assertNoReupload(t *testing.T, chat *fake.Chat, cl *testClient, newPath string, documentsCount int) {
+	t.Helper()
+
+	for _, filter := range []tasks.Filter{{Type: "UploadFile", Path: newPath}, {Type: "DeleteFile"}, {Type: "DeleteDir"}} {
+		if infos := cl.TaskMonitor.Find(filter, 0, -1); len(infos) != 0 {
+			t.Fatalf("moved files must not be deleted and uploaded, got: %v", infos)
+		}
+	}
+
+	if count := documents(chat); count != documentsCount {
+		t.Fatalf("moved files must not be uploaded again: want: %d documents, got: %d", documentsCount, count)
+	}
+}
+
+func TestWatcherMovesRenamedFile(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	msgIDs := renameWatched(t, cl, []string{"a.txt", "keep.txt"}, "a.txt", "renamed.txt")
+
+	eventually(t, "file to be moved", func() bool {
+		_, moved := cl.HeaderFile("renamed.txt")
+		_, old := cl.HeaderFile("a.txt")
+		return moved && !old
+	})
+
+	if infos := cl.TaskMonitor.Find(tasks.Filter{Type: "MoveFile", Path: "renamed.txt"}, 0, -1); len(infos) != 1 {
+		t.Fatalf("rename must be handled by MoveFile task, got: %v", infos)
+	}
+
+	if msgID, _ := cl.HeaderFile("renamed.txt"); msgID != msgIDs["a.txt"] {
+		t.Fatalf("moved file must keep its message: want: %d, got: %d", msgIDs["a.txt"], msgID)
+	}
+
+	assertNoReupload(t, chat, cl, "renamed.txt", 2)
+}
+
+func TestWatcherMovesRenamedDir(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	msgIDs := renameWatched(t, cl, []string{"dir/a.txt", "dir/sub/b.txt", "keep.txt"}, "dir", "moved")
+
+	eventually(t, "dir to be moved", func() bool {
+		files := cl.HeaderFiles()
+		_, a := files["moved/a.txt"]
+		_, b := files["moved/sub/b.txt"]
+		return a && b && len(files) == 3
+	})
+
+	if infos := cl.TaskMonitor.Find(tasks.Filter{Type: "MoveDir"}, 0, -1); len(infos) != 1 || infos[0].Name != "moved/" {
+		t.Fatalf("rename must be handled by MoveDir task, got: %v", infos)
+	}
+
+	if infos := cl.TaskMonitor.Find(tasks.Filter{Type: "MoveFile"}, 0, -1); len(infos) != 0 {
+		t.Fatalf("files of moved dir must not be moved one by one, got: %v", infos)
+	}
+
+	if msgID, _ := cl.HeaderFile("moved/sub/b.txt"); msgID != msgIDs["dir/sub/b.txt"] {
+		t.Fatalf("moved file must keep its message: want: %d, got: %d", msgIDs["dir/sub/b.txt"], msgID)
+	}
+
+	assertNoReupload(t, chat, cl, "moved/", 3)
+}
+
+func TestDeleteDirMovesFilesToTrash(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	cl.writeFile(t, "dir/a.txt", "a")
+	cl.writeFile(t, "dir/sub/b.txt", "b")
+	cl.writeFile(t, "keep.txt", "keep")
+	if err := cl.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "files to be uploaded", func() bool {
+		return len(cl.HeaderFiles()) == 3
+	})
+
+	results, err := cl.DeleteDir(context.Background(), "dir")
+	if err != nil {
+		t.Fatalf("delete dir: %v", err)
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			t.Fatalf("delete %s: %s", result.Path, result.Error)
+		}
+	}
+
+	if files := cl.HeaderFiles(); len(files) != 1 {
+		t.Fatalf("only one file must be left, got: %v", files)
+	}
+
+	if _, err := os.Stat(filepath.Join(cl.dir, "dir")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("local dir must be removed, got: %v", err)
+	}
+
+	if trash := cl.Trash(); len(trash) != 2 {
+		t.Fatalf("deleted files must be in trash, got: %v", trash)
+	}
+
+	if count := documents(chat); count != 3 {
+		t.Fatalf("documents of trashed files must be kept: want: 3, got: %d", count)
+	}
+}
+
+func TestDeleteDirDeletesFileWithManyParts(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat, func(app *config.App) {
+		app.TrashRetention = -time.Second
+	})
+	cl.PartSize = 16
+
+	// File has more parts than can be deleted by one request.
+	cl.writeFile(t, "dir/large.bin", strings.Repeat("x", 16*(fake.MaxDeleteMessages+20)))
+	if err := cl.PutFile(context.Background(), "dir/large.bin"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	results, err := cl.DeleteDir(context.Background(), "dir")
+	if err != nil {
+		t.Fatalf("delete dir: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("file must be deleted: %+v", results)
+	}
+
+	if count := documents(chat); count != 0 {
+		t.Fatalf("all parts must be deleted, got: %d documents", count)
+	}
+}
+
+func TestEmptyTrashIsRestoredFromJournal(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	cl.writeFile(t, "deleted.txt", "deleted")
+	if err := cl.PutFile(context.Background(), "deleted.txt"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if err := cl.DeleteFile(context.Background(), "deleted.txt"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	journalPath := filepath.Join(t.TempDir(), "tasks.jsonl")
+	setJournal := func(app *config.App) { app.JournalPath = journalPath }
+
+	// Paused client leaves emptying of the trash in the journal.
+	paused := newClientWithOptions(t, chat, []engine.Option{engine.PauseTasks()}, setJournal)
+	paused.AddTask(engine.NewPurgeTrash(paused.Client, true))
+
+	newClient(t, chat, setJournal)
+
+	eventually(t, "trash to be emptied", func() bool {
+		return documents(chat) == 0
+	})
+}
+
+func TestMoveOverExistingFileMovesItToTrash(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	cl.writeFile(t, "a.txt", "a")
+	cl.writeFile(t, "b.txt", "b")
+	cl.writeFile(t, "dir/c.txt", "c")
+	cl.writeFile(t, "other/c.txt", "other c")
+	if err := cl.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "files to be uploaded", func() bool {
+		return len(cl.HeaderFiles()) == 4
+	})
+
+	cl.AddTask(engine.NewMoveFile(cl.Client, cl.AbsPath("a.txt"), cl.AbsPath("b.txt")))
+	cl.AddTask(engine.NewMoveDir(cl.Client, cl.AbsPath("dir"), cl.AbsPath("other")))
+	eventually(t, "files to be moved", func() bool {
+		return len(cl.HeaderFiles()) == 2
+	})
+
+	if content, _ := cl.uploaded(chat, "b.txt"); content != "a" {
+		t.Fatalf("moved file must replace the destination: want: %q, got: %q", "a", content)
+	}
+
+	if content, _ := cl.uploaded(chat, "other/c.txt"); content != "c" {
+		t.Fatalf("moved dir must replace the destination: want: %q, got: %q", "c", content)
+	}
+
+	trashed := map[string]bool{}
+	for _, entry := range cl.Trash() {
+		trashed[entry.Path] = true
+	}
+
+	if !trashed["b.txt"] || !trashed["other/c.txt"] || len(trashed) != 2 {
+		t.Fatalf("replaced files must be in trash, got: %v", cl.Trash())
+	}
+
+	if count := documents(chat); count != 4 {
+		t.Fatalf("documents of replaced files must be kept: want: 4, got: %d", count)
+	}
+}
+
+func TestVersionsAreKeptAndRestored(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat, func(app *config.App) {
+		app.VersionsKeep = 2
+	})
+
+	for _, content := range []string{"v1", "v2", "v3", "v4"} {
+		cl.writeFile(t, "notes.txt", content)
+		if err := cl.PutFile(context.Background(), "notes.txt"); err != nil {
+			t.Fatalf("put %s: %v", content, err)
+		}
+	}
+
+	versions, ok := cl.FileVersions("notes.txt")
+	if !ok || len(versions) != 2 {
+		t.Fatalf("only last versions must be kept: want: 2, got: %v", versions)
+	}
+
+	if count := documents(chat); count != 3 {
+		t.Fatalf("documents of removed versions must be deleted: want: 3, got: %d", count)
+	}
+
+	if err := cl.RestoreVersion(context.Background(), "notes.txt", versions[0].ID); err != nil {
+		t.Fatalf("restore version: %v", err)
+	}
+
+	// Restore returns only after restored version is uploaded.
+	if content, _ := cl.uploaded(chat, "notes.txt"); content != "v2" {
+		t.Fatalf("restored version must be uploaded: want: %q, got: %q", "v2", content)
+	}
+
+	if content, _ := cl.readFile("notes.txt"); content != "v2" {
+		t.Fatalf("restored version must replace local file: want: %q, got: %q", "v2", content)
+	}
+
+	if versions, _ := cl.FileVersions("notes.txt"); len(versions) != 2 {
+		t.Fatalf("replaced version must be kept: want: 2, got: %v", versions)
+	}
+
+	if err := cl.RestoreVersion(context.Background(), "notes.txt", 1); !errors.Is(err, engine.ErrVersionNotFound) {
+		t.Fatalf("unknown version must not be restored: %v", err)
+	}
+}
+
+func TestExpiredVersionsArePrunedWithoutChanges(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	first := newClient(t, chat, func(app *config.App) {
+		app.VersionsRetention = time.Hour
+	})
+
+	for _, content := range []string{"v1", "v2"} {
+		first.writeFile(t, "notes.txt", content)
+		if err := first.PutFile(context.Background(), "notes.txt"); err != nil {
+			t.Fatalf("put %s: %v", content, err)
+		}
+	}
+
+	if count := documents(chat); count != 2 {
+		t.Fatalf("previous version must be kept: want: 2 documents, got: %d", count)
+	}
+
+	// Client with shorter retention prunes versions on start.
+	time.Sleep(10 * time.Millisecond)
+	second := newClient(t, chat, func(app *config.App) {
+		app.VersionsRetention = time.Millisecond
+	})
+
+	eventually(t, "expired version to be deleted", func() bool {
+		versions, _ := second.FileVersions("notes.txt")
+		return documents(chat) == 1 && len(versions) == 0
+	})
+}
+
+func TestRemoteHeaderUpdateDownloadsFiles(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	first := newClient(t, chat)
+	second := newClient(t, chat)
+
+	first.writeFile(t, "shared.txt", "from first")
+	if err := first.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "file to be downloaded by other client", func() bool {
+		content, ok := second.readFile("shared.txt")
+		return ok && content == "from first"
+	})
+}
+
+func TestUploadIsRetriedAfterSendFailure(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	chat.FailSend(errors.New("network is unreachable"))
+
+	cl.writeFile(t, "retry.txt", "data")
+	if err := cl.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "file to be uploaded after retry", func() bool {
+		content, ok := cl.uploaded(chat, "retry.txt")
+		return ok && content == "data"
+	})
+}
+
+func TestPutFileWaitsForRetries(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	chat.FailSend(errors.New("network is unreachable"))
+
+	cl.writeFile(t, "retry.txt", "data")
+	if err := cl.PutFile(context.Background(), "retry.txt"); err != nil {
+		t.Fatalf("put must succeed after retry: %v", err)
+	}
+
+	if content, ok := cl.uploaded(chat, "retry.txt"); !ok || content != "data" {
+		t.Fatalf("file must be uploaded when put returns, got: %q, %t", content, ok)
+	}
+}
+
+func TestUploadIsNotRetriedAfterPermanentFailure(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	chat.FailSend(&manager.PermanentError{Err: errors.New("bad request")})
+
+	cl.writeFile(t, "bad.txt", "data")
+	if err := cl.PutFile(context.Background(), "bad.txt"); err == nil {
+		t.Fatal("put must fail on permanent error")
+	}
+
+	infos := cl.TaskMonitor.Find(tasks.Filter{Type: "UploadFile"}, 0, -1)
+	if len(infos) != 1 || infos[0].Attempts != 1 || infos[0].Status != tasks.TaskStatusError.String() {
+		t.Fatalf("upload must fail after one attempt, got: %+v", infos)
+	}
+
+	if _, ok := cl.uploaded(chat, "bad.txt"); ok {
+		t.Fatal("file must not be uploaded")
+	}
+}
+
+func TestLongHeaderIsStoredAsDocument(t *testing.T) {
+	server := newServer(t)
+	server.MaxHeaderLength = 100
+	chat := server.Chat(1)
+	first := newClient(t, chat)
+
+	for _, name := range []string{"one.txt", "two.txt", "three.txt", "four.txt", "five.txt"} {
+		first.writeFile(t, name, name)
+	}
+
+	if err := first.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "files to be uploaded", func() bool {
+		return len(first.HeaderFiles()) == 5 && strings.Contains(chat.Header(), `"Document"`)
+	})
+
+	second := newClient(t, chat)
+	if files := second.HeaderFiles(); len(files) != 5 {
+		t.Fatalf("header must be read from the document, got files: %v", files)
+	}
+}
+
+func TestEncryptedFilesAreDecryptedByOtherClient(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	withPassphrase := func(app *config.App) {
+		app.EncryptionPassphrase = "correct horse battery staple"
+	}
+
+	first := newClient(t, chat, withPassphrase)
+	first.writeFile(t, "secret.txt", "plain text")
+	if err := first.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "file to be uploaded", func() bool {
+		_, ok := first.uploaded(chat, "secret.txt")
+		return ok
+	})
+
+	if content, _ := first.uploaded(chat, "secret.txt"); strings.Contains(content, "plain text") {
+		t.Fatal("uploaded document is not encrypted")
+	}
+
+	if strings.Contains(chat.Header(), "secret.txt") {
+		t.Fatal("header is not encrypted")
+	}
+
+	second := newClient(t, chat, withPassphrase)
+	if err := second.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "file to be downloaded", func() bool {
+		content, ok := second.readFile("secret.txt")
+		return ok && content == "plain text"
+	})
+}
+
+func TestSyncOnceWaitsForTasks(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	for _, name := range []string{"a.txt", "b.txt", "dir/c.txt"} {
+		cl.writeFile(t, name, name)
+	}
+
+	if err := cl.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+
+	if err := cl.TaskMonitor.Wait(ctx); err != nil {
+		t.Fatalf("wait for tasks: %v", err)
+	}
+
+	if files := cl.HeaderFiles(); len(files) != 3 {
+		t.Fatalf("all files must be uploaded after wait, got: %v", files)
+	}
+}
+
+func TestVerifyReportsDifferences(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	cl.writeFile(t, "same.txt", "same")
+	cl.writeFile(t, "changed.txt", "before")
+	cl.writeFile(t, "removed.txt", "removed")
+	for _, name := range []string{"same.txt", "changed.txt", "removed.txt"} {
+		if err := cl.PutFile(context.Background(), name); err != nil {
+			t.Fatalf("put %s: %v", name, err)
+		}
+	}
+
+	cl.writeFile(t, "changed.txt", "after")
+	cl.writeFile(t, "new.txt", "new")
+	if err := os.Remove(filepath.Join(cl.dir, "removed.txt")); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+
+	results, err := cl.Verify(context.Background(), true)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	statuses := map[string]string{}
+	for _, result := range results {
+		statuses[result.Path] = result.Status
+	}
+
+	want := map[string]string{
+		"same.txt":    engine.VerifyOK,
+		"changed.txt": engine.VerifyModified,
+		"removed.txt": engine.VerifyMissing,
+		"new.txt":     engine.VerifyNotUploaded,
+	}
+	for name, status := range want {
+		if statuses[name] != status {
+			t.Fatalf("want %s to be %q, got: %v", name, status, results)
+		}
+	}
+}
+
+func TestPausedClientDoesNotRunTasks(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	first := newClient(t, chat)
+
+	first.writeFile(t, "remote.txt", "remote")
+	if err := first.PutFile(context.Background(), "remote.txt"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	second := newClientWithOptions(t, chat, []engine.Option{engine.PauseTasks()})
+	if err := second.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if _, ok := second.readFile("remote.txt"); ok {
+		t.Fatal("paused client must not download files")
+	}
+
+	if _, ok := second.HeaderFile("remote.txt"); !ok {
+		t.Fatal("paused client must read the header")
+	}
+}
+
+func TestOneShotClientDoesNotWriteHeaderOnStart(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	newClientWithOptions(t, chat, []engine.Option{engine.OneShot(), engine.PauseTasks()})
+
+	if header := chat.Header(); header != "" {
+		t.Fatalf("one-shot client must not write the header: %q", header)
+	}
+}
+
+func TestOneShotClientRunsOnlyItsOwnTasks(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	journalPath := filepath.Join(t.TempDir(), "tasks.jsonl")
+	setJournal := func(app *config.App) { app.JournalPath = journalPath }
+
+	// Paused client leaves unfinished uploads in the journal.
+	first := newClientWithOptions(t, chat, []engine.Option{engine.PauseTasks()}, setJournal)
+	first.writeFile(t, "unfinished.txt", "unfinished")
+	first.writeFile(t, "put.txt", "put")
+	if err := first.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	second := newClientWithOptions(t, chat, []engine.Option{engine.OneShot()}, setJournal, func(app *config.App) {
+		app.FilesPath = first.dir
+	})
+	if err := second.PutFile(context.Background(), "put.txt"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if _, ok := second.HeaderFile("put.txt"); !ok {
+		t.Fatal("file must be uploaded by one-shot client")
+	}
+
+	if _, ok := second.HeaderFile("unfinished.txt"); ok {
+		t.Fatal("one-shot client must not run tasks from the journal")
+	}
+
+	if records := second.TaskMonitor.Unfinished(); len(records) != 2 {
+		t.Fatalf("unfinished tasks must be read from the journal: want: 2, got: %d", len(records))
+	}
+}
+
+func TestExcludedFilesStayCloudOnly(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	first := newClient(t, chat)
+
+	first.writeFile(t, "docs/readme.md", "hello")
+	first.writeFile(t, "videos/movie.mp4", "movie")
+	if err := first.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	if err := first.TaskMonitor.Wait(context.Background()); err != nil {
+		t.Fatalf("wait for tasks: %v", err)
+	}
+
+	second := newClient(t, chat, func(app *config.App) {
+		app.SyncExclude = []string{"/videos/"}
+	})
+	if err := second.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	if err := second.TaskMonitor.Wait(context.Background()); err != nil {
+		t.Fatalf("wait for tasks: %v", err)
+	}
+
+	if _, ok := second.readFile("docs/readme.md"); !ok {
+		t.Fatalf("included file must be downloaded")
+	}
+
+	if _, ok := second.readFile("videos/movie.mp4"); ok {
+		t.Fatalf("excluded file must not be downloaded")
+	}
+
+	if !second.CloudOnly("videos/movie.mp4", false) {
+		t.Fatalf("excluded file must be cloud only")
+	}
+
+	results, err := second.Verify(context.Background(), false)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	for _, result := range results {
+		if result.Path == "videos/movie.mp4" && result.Status != engine.VerifyCloudOnly {
+			t.Fatalf("want: %q status of excluded file, got: %q", engine.VerifyCloudOnly, result.Status)
+		}
+	}
+}
+
+func TestCloudOnlyDirIsEvictedAndSyncedBack(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	cl.writeFile(t, "photos/cat.jpg", "cat")
+	if err := cl.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+	cl.startListener(t)
+
+	eventually(t, "file to be uploaded", func() bool {
+		content, ok := cl.uploaded(chat, "photos/cat.jpg")
+		return ok && content == "cat"
+	})
+
+	results, err := cl.SetSynced("photos", false)
+	if err != nil {
+		t.Fatalf("set cloud only: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("want: local copy to be removed, got: %+v", results)
+	}
+
+	if _, ok := cl.readFile("photos/cat.jpg"); ok {
+		t.Fatalf("local copy of cloud only file must be removed")
+	}
+
+	// Removal of local copy must not delete the remote file after debounce.
+	time.Sleep(3 * time.Second)
+	if err := cl.TaskMonitor.Wait(context.Background()); err != nil {
+		t.Fatalf("wait for tasks: %v", err)
+	}
+
+	if _, ok := cl.uploaded(chat, "photos/cat.jpg"); !ok {
+		t.Fatalf("remote file must be kept")
+	}
+
+	if _, err := cl.SetSynced("photos", true); err != nil {
+		t.Fatalf("set synced: %v", err)
+	}
+
+	eventually(t, "file to be downloaded", func() bool {
+		content, ok := cl.readFile("photos/cat.jpg")
+		return ok && content == "cat"
+	})
+}
+
+func TestCachedFilesAreEvictedByLeastRecentUse(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	first := newClient(t, chat)
+
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		first.writeFile(t, "big/"+name, strings.Repeat(name[:1], 400*1024))
+	}
+	if err := first.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	if err := first.TaskMonitor.Wait(context.Background()); err != nil {
+		t.Fatalf("wait for tasks: %v", err)
+	}
+
+	second := newClient(t, chat, func(app *config.App) {
+		app.SyncExclude = []string{"/big/"}
+		app.CacheSizeMB = 1
+	})
+
+	read := func(relativePath string) string {
+		t.Helper()
+
+		f, err := second.OpenCached(context.Background(), relativePath)
+		if err != nil {
+			t.Fatalf("open cached %q: %v", relativePath, err)
+		}
+		defer f.Close()
+
+		content, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatalf("read cached %q: %v", relativePath, err)
+		}
+
+		return string(content)
+	}
+
+	if content := read("big/a.bin"); content != strings.Repeat("a", 400*1024) {
+		t.Fatalf("wrong content of cached file")
+	}
+	read("big/b.bin")
+	// Access makes a.bin more recently used than b.bin.
+	read("big/a.bin")
+	read("big/c.bin")
+
+	cached, err := os.ReadDir(filepath.Join(second.TempPath, "cache"))
+	if err != nil {
+		t.Fatalf("read cache dir: %v", err)
+	}
+
+	isCached := func(relativePath string) bool {
+		msgID, _ := second.HeaderFile(relativePath)
+		for _, entry := range cached {
+			if strings.HasPrefix(entry.Name(), strconv.FormatInt(msgID, 10)+"_") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(cached) != 2 || !isCached("big/a.bin") || !isCached("big/c.bin") {
+		t.Fatalf("least recently used file must be evicted, got %d cached files", len(cached))
+	}
+
+	if _, ok := second.readFile("big/a.bin"); ok {
+		t.Fatalf("cached file must not be written into files dir")
+	}
+}
+
+func TestCacheFetchIsNotCancelledByFirstCaller(t *testing.T) {
+	cache, err := engine.NewCache(t.TempDir(), 1024*1024)
+	if err != nil {
+		t.Fatalf("create cache: %v", err)
+	}
+
+	var fetches int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetch := func(ctx context.Context, w io.Writer) error {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			close(started)
+		}
+
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		_, err := io.WriteString(w, "content")
+		return err
+	}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Open(firstCtx, "key", fetch)
+		firstErr <- err
+	}()
+	<-started
+
+	type result struct {
+		content string
+		err     error
+	}
+	second := make(chan result, 1)
+	go func() {
+		f, err := cache.Open(context.Background(), "key", fetch)
+		if err != nil {
+			second <- result{err: err}
+			return
+		}
+		defer f.Close()
+
+		content, err := io.ReadAll(f)
+		second <- result{content: string(content), err: err}
+	}()
+	// Second caller joins the fetch that is already running.
+	time.Sleep(100 * time.Millisecond)
+
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first caller must stop waiting on its context: %v", err)
+	}
+
+	close(release)
+	res := <-second
+	if res.err != nil || res.content != "content" {
+		t.Fatalf("second caller must get fetched file: %q, %v", res.content, res.err)
+	}
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("file must be fetched once: want: 1, got: %d", n)
+	}
+}
+
+func TestDownloadServesRangesOfRemoteFile(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	first := newClient(t, chat)
+	first.PartSize = 4096
+
+	content := make([]byte, 10000)
+	for i := range content {
+		content[i] = byte('a' + i%26)
+	}
+	first.writeFile(t, "video.mp4", string(content))
+	if err := first.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	if err := first.TaskMonitor.Wait(context.Background()); err != nil {
+		t.Fatalf("wait for tasks: %v", err)
+	}
+
+	if !first.IsChunked("video.mp4") {
+		t.Fatalf("file must be uploaded in parts")
+	}
+
+	// File is not downloaded and cache is disabled, so it is streamed from the chat.
+	second := newClient(t, chat, func(app *config.App) {
+		app.SyncExclude = []string{"*.mp4"}
+		app.CacheSizeMB = -1
+	})
+
+	router := chi.NewRouter()
+	router.Get("/files/download/*", handler.NewHandler(second.Client, 0).FileDownload)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	get := func(header http.Header) (*http.Response, []byte) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/files/download/video.mp4", nil)
+		if err != nil {
+			t.Fatalf("create request: %v", err)
+		}
+		req.Header = header
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("do request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+
+		return resp, body
+	}
+
+	resp, body := get(http.Header{"Range": {"bytes=5000-8999"}})
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("want: status %d, got: %d", http.StatusPartialContent, resp.StatusCode)
+	}
+
+	if string(body) != string(content[5000:9000]) {
+		t.Fatalf("wrong content of the range")
+	}
+
+	if resp.Header.Get("Content-Type") != "video/mp4" {
+		t.Fatalf("want: video/mp4 content type, got: %q", resp.Header.Get("Content-Type"))
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("ETag must be set")
+	}
+
+	resp, body = get(http.Header{})
+	if resp.StatusCode != http.StatusOK || string(body) != string(content) {
+		t.Fatalf("want: whole file, got: status %d and %d bytes", resp.StatusCode, len(body))
+	}
+
+	if resp.Header.Get("Content-Length") != strconv.Itoa(len(content)) {
+		t.Fatalf("want: content length %d, got: %q", len(content), resp.Header.Get("Content-Length"))
+	}
+
+	if resp, _ = get(http.Header{"If-None-Match": {etag}}); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("want: status %d, got: %d", http.StatusNotModified, resp.StatusCode)
+	}
+}
+
+func TestStreamFileReportsCorruptedContent(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	cl.writeFile(t, "file.txt", "original")
+	if err := cl.PutFile(context.Background(), "file.txt"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	// Content of the message is replaced without changing the hash in the caption.
+	msgID, _ := cl.HeaderFile("file.txt")
+	raw := chat.Connect()
+	caption, err := raw.Caption(context.Background(), msgID)
+	if err != nil {
+		t.Fatalf("caption: %v", err)
+	}
+
+	corruptedPath := filepath.Join(t.TempDir(), "corrupted.txt")
+	if err := os.WriteFile(corruptedPath, []byte("corrupte"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	if err := raw.Replace(context.Background(), msgID, corruptedPath, caption, func(int) {}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+
+	var b strings.Builder
+	if err := cl.StreamFile(context.Background(), &b, "file.txt"); err == nil || !strings.Contains(err.Error(), "hash mismatch") {
+		t.Fatalf("corrupted content must be reported: %v", err)
+	}
+}
+
+func TestDownloadOfCorruptedRemoteFileIsAborted(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	first := newClient(t, chat)
+
+	first.writeFile(t, "file.txt", strings.Repeat("original", 1024))
+	if err := first.PutFile(context.Background(), "file.txt"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	msgID, _ := first.HeaderFile("file.txt")
+	raw := chat.Connect()
+	caption, err := raw.Caption(context.Background(), msgID)
+	if err != nil {
+		t.Fatalf("caption: %v", err)
+	}
+
+	corruptedPath := filepath.Join(t.TempDir(), "corrupted.txt")
+	if err := os.WriteFile(corruptedPath, []byte(strings.Repeat("corrupte", 1024)), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	if err := raw.Replace(context.Background(), msgID, corruptedPath, caption, func(int) {}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+
+	// File is not downloaded and cache is disabled, so it is streamed from the chat.
+	second := newClient(t, chat, func(app *config.App) {
+		app.SyncExclude = []string{"*.txt"}
+		app.CacheSizeMB = -1
+	})
+
+	router := chi.NewRouter()
+	router.Get("/files/download/*", handler.NewHandler(second.Client, 0).FileDownload)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + "/files/download/file.txt")
+	if err != nil {
+		t.Fatalf("do request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err == nil {
+		t.Fatalf("download of corrupted file must be aborted, got %d bytes", len(body))
+	}
+
+	if len(body) == len(strings.Repeat("corrupte", 1024)) {
+		t.Fatalf("whole corrupted content must not be served")
+	}
+}
+
+func TestTasksAreListedWhileTheyRun(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat, func(app *config.App) {
+		app.Workers = 4
+	})
+
+	for i := 0; i < 20; i++ {
+		cl.writeFile(t, "file"+strconv.Itoa(i)+".txt", strings.Repeat("x", 64*1024))
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		// Progress, status and details are read while workers change them.
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				cl.TaskMonitor.Find(tasks.Filter{}, 0, -1)
+			}
+		}
+	}()
+
+	if err := cl.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "files to be uploaded", func() bool {
+		return len(cl.HeaderFiles()) == 20
+	})
+	close(stop)
+	<-stopped
+}
+
+// changeOnBothSides uploads remote change of the file by the first client,
+// and changes the file locally on the second, watched client.
+func TestFinishedTasksAreForgottenOverLimit(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat, func(app *config.App) {
+		app.KeepFinishedTasks = 2
+	})
+
+	for i := 0; i < 4; i++ {
+		name := "file" + strconv.Itoa(i) + ".txt"
+		cl.writeFile(t, name, "data")
+		if err := cl.PutFile(context.Background(), name); err != nil {
+			t.Fatalf("put %s: %v", name, err)
+		}
+	}
+
+	infos := cl.TaskMonitor.Find(tasks.Filter{}, 0, -1)
+	if len(infos) != 2 || infos[0].Name != "file2.txt" || infos[1].Name != "file3.txt" {
+		t.Fatalf("only the last finished tasks must be kept: %+v", infos)
+	}
+}
+
+func TestPreAddHooksAreRemoved(t *testing.T) {
+	chat := newServer(t).Chat(1)
+	cl := newClient(t, chat)
+
+	var calls [3]int32
+	var remove func()
+	for i := range calls {
+		i := i
+		remove = cl.AddPreAddHook(func(task tasks.Task) (tasks.Task, bool, error) {
+			atomic.AddInt32(&calls[i], 1)
+			// The last hook is not finished, it is removed instead.
+			return task, i != 2, nil
+		})
+	}
+
+	// Hooks that finish together must all be removed.
+	cl.writeFile(t, "first.txt", "data")
+	if err := cl.PutFile(context.Background(), "first.txt"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	remove()
+
+	cl.writeFile(t, "second.txt", "data")
+	if err := cl.PutFile(context.Background(), "second.txt"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	for i := range calls {
+		if n := atomic.LoadInt32(&calls[i]); n != 1 {
+			t.Fatalf("hook %d must be called once, got: %d", i, n)
+		}
+	}
+}
+
+func changeOnBothSides(t *testing.T, policy string) (*fake.Chat, *testClient) {
+	t.Helper()
+
+	chat := newServer(t).Chat(1)
+	first := newClient(t, chat)
+	first.writeFile(t, "shared.txt", "v1")
+	if err := first.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "file to be uploaded", func() bool {
+		_, ok := first.uploaded(chat, "shared.txt")
+		return ok
+	})
+
+	second := newClient(t, chat, func(app *config.App) {
+		app.ConflictPolicy = policy
+	})
+	if err := second.SynchronizeFiles(); err != nil {
+		t.Fatalf("synchronize: %v", err)
+	}
+
+	eventually(t, "file to be downloaded", func() bool {
+		content, ok := second.readFile("shared.txt")
+		return ok && content == "v1"
+	})
+
+	// Content of the single message is replaced without changing the header,
+	// so second client learns about the change only when it uploads the file.
+	first.writeFile(t, "shared.txt", "remote")
+	first.AddTask(engine.NewUploadFile(first.Client, first.AbsPath("shared.txt")))
+	eventually(t, "remote change to be uploaded", func() bool {
+		content, ok := first.uploaded(chat, "shared.txt")
+		return ok && content == "remote"
+	})
+
+	second.startListener(t)
+	second.writeFile(t, "shared.txt", "local")
+
+	eventually(t, "conflict to be detected", func() bool {
+		return len(second.TaskMonitor.Find(tasks.Filter{Type: "ResolveConflict"}, 0, -1)) != 0
+	})
+
+	return chat, second
+}
+
+func TestWatcherUploadConflictKeepsBoth(t *testing.T) {
+	chat, cl := changeOnBothSides(t, engine.ConflictKeepBoth)
+
+	eventually(t, "remote file to be downloaded", func() bool {
+		content, ok := cl.readFile("shared.txt")
+		return ok && content == "remote"
+	})
+
+	eventually(t, "local copy to be uploaded", func() bool {
+		for relativePath := range cl.HeaderFiles() {
+			if strings.HasPrefix(relativePath, "shared (conflict from test ") {
+				content, ok := cl.uploaded(chat, relativePath)
+				return ok && content == "local"
+			}
+		}
+
+		return false
+	})
+
+	if content, _ := cl.uploaded(chat, "shared.txt"); content != "remote" {
+		t.Fatalf("remote file must not be overwritten: want: %q, got: %q", "remote", content)
+	}
+}
+
+func TestWatcherUploadConflictPrefersLocal(t *testing.T) {
+	chat, cl := changeOnBothSides(t, engine.ConflictPreferLocal)
+
+	eventually(t, "local file to be uploaded", func() bool {
+		content, ok := cl.uploaded(chat, "shared.txt")
+		return ok && content == "local"
+	})
+
+	if content, _ := cl.readFile("shared.txt"); content != "local" {
+		t.Fatalf("local file must be kept: want: %q, got: %q", "local", content)
+	}
+}
+
+func TestWatcherUploadConflictPrefersRemote(t *testing.T) {
+	chat, cl := changeOnBothSides(t, engine.ConflictPreferRemote)
+
+	eventually(t, "remote file to be downloaded", func() bool {
+		content, ok := cl.readFile("shared.txt")
+		return ok && content == "remote"
+	})
+
+	if content, _ := cl.uploaded(chat, "shared.txt"); content != "remote" {
+		t.Fatalf("remote file must not be overwritten: want: %q, got: %q", "remote", content)
+	}
+}
+
+func TestFoldersAreRoutedByName(t *testing.T) {
+	server := newServer(t)
+	photos := newClient(t, server.Chat(1))
+	docs := newClient(t, server.Chat(2))
+
+	photos.writeFile(t, "cat.jpg", "cat")
+	docs.writeFile(t, "readme.md", "readme")
+	for _, cl := range []*testClient{photos, docs} {
+		if err := cl.SynchronizeFiles(); err != nil {
+			t.Fatalf("synchronize: %v", err)
+		}
+
+		if err := cl.TaskMonitor.Wait(context.Background()); err != nil {
+			t.Fatalf("wait for tasks: %v", err)
+		}
+	}
+
+	routes := web.NewClientRoutes(config.App{}, nil)
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get("/files/list"); code != http.StatusServiceUnavailable {
+		t.Fatalf("routes must not be served before folders are set: got: %d", code)
+	}
+
+	routes.SetFolders([]web.Folder{{Name: "photos", Client: photos.Client}, {Name: "docs", Client: docs.Client}})
+
+	if code, body := get("/folders"); code != http.StatusOK || !strings.Contains(body, `["photos","docs"]`) {
+		t.Fatalf("folders must be listed in order: %d %s", code, body)
+	}
+
+	tests := []struct {
+		path    string
+		want    string
+		notWant string
+	}{
+		{path: "/folders/photos/files/list", want: "cat.jpg", notWant: "readme.md"},
+		{path: "/folders/docs/files/list", want: "readme.md", notWant: "cat.jpg"},
+		// First folder is served without prefix.
+		{path: "/files/list", want: "cat.jpg", notWant: "readme.md"},
+		// Page of the folder lists its tasks and adds new ones.
+		{path: "/folders/docs/?limit=10", want: "const tasksLimit =  10 ;", notWant: "/folders/photos/tasks/"},
+		// Not positive limit means the default page size.
+		{path: "/folders/docs/?limit=0", want: "const tasksLimit =  100 ;", notWant: "/folders/photos/tasks/"},
+		{path: "/folders/docs/tasks?limit=0", want: "readme.md", notWant: "cat.jpg"},
+		{path: "/folders/docs/tasks?limit=-1", want: "readme.md", notWant: "cat.jpg"},
+	}
+
+	for _, tt := range tests {
+		code, body := get(tt.path)
+		if code != http.StatusOK || !strings.Contains(body, tt.want) || strings.Contains(body, tt.notWant) {
+			t.Fatalf("%s: want %q without %q, got: %d %s", tt.path, tt.want, tt.notWant, code, body)
+		}
+	}
+
+	if code, _ := get("/folders/unknown/files/list"); code != http.StatusNotFound {
+		t.Fatalf("unknown folder must not be found: got: %d", code)
+	}
+}
@@ -0,0 +1,266 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	KeySize  = 32
+	SaltSize = 16
+	// ChunkSize is the size of a plaintext chunk that is sealed separately
+	// when encrypting streams, so files do not need to fit in memory.
+	ChunkSize = 64 * 1024
+)
+
+var (
+	ErrNoKey = errors.New("encryption key is not configured")
+
+	lastChunkAD  = []byte("last")
+	innerChunkAD = []byte("next")
+)
+
+// Cipher encrypts and decrypts data with AES-256-GCM.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("wrong key size: want: %d, got: %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create block cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+// NewSalt returns random salt to be used with KeyFromPassphrase.
+func NewSalt() []byte {
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+
+	return salt
+}
+
+func KeyFromPassphrase(passphrase string, salt []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, ErrNoKey
+	}
+
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, KeySize)
+}
+
+// KeyFromFile derives key from the content of the key file.
+//
+// Key file is expected to contain enough random data,
+// so it is only hashed to get key of the needed size.
+func KeyFromFile(keyFilePath string) ([]byte, error) {
+	data, err := os.ReadFile(keyFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("key file %q is empty", keyFilePath)
+	}
+
+	key := sha256.Sum256(data)
+
+	return key[:], nil
+}
+
+// Seal encrypts data and prepends random nonce to it.
+func (c *Cipher) Seal(plain []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+
+	return c.aead.Seal(nonce, nonce, plain, nil)
+}
+
+func (c *Cipher) Open(data []byte) ([]byte, error) {
+	if len(data) < c.aead.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+
+	nonce, encrypted := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+
+	return c.aead.Open(nil, nonce, encrypted, nil)
+}
+
+// SealString returns base64 representation of sealed data.
+func (c *Cipher) SealString(plain []byte) string {
+	return base64.StdEncoding.EncodeToString(c.Seal(plain))
+}
+
+func (c *Cipher) OpenString(data string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode base64: %w", err)
+	}
+
+	return c.Open(decoded)
+}
+
+// Encrypt reads src till EOF and writes encrypted stream to dst.
+//
+// Stream starts with random base nonce, followed by sealed chunks
+// of ChunkSize plaintext bytes. Each chunk uses its own nonce derived
+// from base nonce and chunk index. Last chunk is sealed with different
+// additional data, so truncated stream will fail to decrypt.
+func (c *Cipher) Encrypt(dst io.Writer, src io.Reader) error {
+	baseNonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(baseNonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+
+	if _, err := dst.Write(baseNonce); err != nil {
+		return fmt.Errorf("write nonce: %w", err)
+	}
+
+	reader := bufio.NewReaderSize(src, ChunkSize)
+	plain := make([]byte, ChunkSize)
+	sealed := make([]byte, 0, ChunkSize+c.aead.Overhead())
+
+	for idx := uint64(0); ; idx++ {
+		n, err := io.ReadFull(reader, plain)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("read plain data: %w", err)
+		}
+
+		last := n < ChunkSize
+		if !last {
+			if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+				last = true
+			}
+		}
+
+		sealed = c.aead.Seal(sealed[:0], chunkNonce(baseNonce, idx), plain[:n], chunkAD(last))
+		if _, err := dst.Write(sealed); err != nil {
+			return fmt.Errorf("write encrypted chunk: %w", err)
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// Decrypt reads stream produced by Encrypt from src
+// and writes decrypted data to dst.
+func (c *Cipher) Decrypt(dst io.Writer, src io.Reader) error {
+	baseNonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(src, baseNonce); err != nil {
+		return fmt.Errorf("read nonce: %w", err)
+	}
+
+	reader := bufio.NewReaderSize(src, ChunkSize+c.aead.Overhead())
+	sealed := make([]byte, ChunkSize+c.aead.Overhead())
+	plain := make([]byte, 0, ChunkSize)
+
+	for idx := uint64(0); ; idx++ {
+		n, err := io.ReadFull(reader, sealed)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("read encrypted data: %w", err)
+		}
+
+		last := n < len(sealed)
+		if !last {
+			if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+				last = true
+			}
+		}
+
+		plain, err = c.aead.Open(plain[:0], chunkNonce(baseNonce, idx), sealed[:n], chunkAD(last))
+		if err != nil {
+			return fmt.Errorf("open chunk %d: %w", idx, err)
+		}
+
+		if _, err := dst.Write(plain); err != nil {
+			return fmt.Errorf("write decrypted chunk: %w", err)
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// EncryptFile encrypts file at srcPath into new file at dstPath.
+func (c *Cipher) EncryptFile(dstPath, srcPath string) error {
+	return processFile(dstPath, srcPath, c.Encrypt)
+}
+
+// DecryptFile decrypts file at srcPath into new file at dstPath.
+func (c *Cipher) DecryptFile(dstPath, srcPath string) error {
+	return processFile(dstPath, srcPath, c.Decrypt)
+}
+
+func processFile(dstPath, srcPath string, process func(dst io.Writer, src io.Reader) error) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("open source file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return fmt.Errorf("create destination file: %w", err)
+	}
+
+	bufDst := bufio.NewWriterSize(dst, ChunkSize)
+	if err := process(bufDst, src); err != nil {
+		dst.Close()
+		os.Remove(dstPath)
+		return err
+	}
+
+	if err := bufDst.Flush(); err != nil {
+		dst.Close()
+		os.Remove(dstPath)
+		return fmt.Errorf("flush destination file: %w", err)
+	}
+
+	return dst.Close()
+}
+
+func chunkNonce(baseNonce []byte, idx uint64) []byte {
+	nonce := make([]byte, len(baseNonce))
+	copy(nonce, baseNonce)
+
+	counter := binary.BigEndian.Uint64(nonce[len(nonce)-8:])
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter^idx)
+
+	return nonce
+}
+
+func chunkAD(last bool) []byte {
+	if last {
+		return lastChunkAD
+	}
+
+	return innerChunkAD
+}
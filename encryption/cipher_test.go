@@ -0,0 +1,195 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func newTestCipher(t *testing.T) *Cipher {
+	t.Helper()
+
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatalf("new cipher: %v", err)
+	}
+
+	return c
+}
+
+func randomData(t *testing.T, size int) []byte {
+	t.Helper()
+
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("generate data: %v", err)
+	}
+
+	return data
+}
+
+func encrypt(t *testing.T, c *Cipher, plain []byte) []byte {
+	t.Helper()
+
+	var encrypted bytes.Buffer
+	if err := c.Encrypt(&encrypted, bytes.NewReader(plain)); err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	return encrypted.Bytes()
+}
+
+func decrypt(c *Cipher, encrypted []byte) ([]byte, error) {
+	var plain bytes.Buffer
+	err := c.Decrypt(&plain, bytes.NewReader(encrypted))
+
+	return plain.Bytes(), err
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	c := newTestCipher(t)
+
+	tests := []struct {
+		name   string
+		size   int
+		chunks int
+	}{
+		{name: "empty", size: 0, chunks: 1},
+		{name: "smaller than chunk", size: 100, chunks: 1},
+		{name: "exactly one chunk", size: ChunkSize, chunks: 1},
+		{name: "one byte over chunk", size: ChunkSize + 1, chunks: 2},
+		{name: "exactly two chunks", size: 2 * ChunkSize, chunks: 2},
+		{name: "several chunks", size: 3*ChunkSize + 123, chunks: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := randomData(t, tt.size)
+			encrypted := encrypt(t, c, plain)
+
+			wantSize := c.aead.NonceSize() + tt.size + tt.chunks*c.aead.Overhead()
+			if len(encrypted) != wantSize {
+				t.Fatalf("encrypted size: want: %d, got: %d", wantSize, len(encrypted))
+			}
+
+			decrypted, err := decrypt(c, encrypted)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+
+			if !bytes.Equal(decrypted, plain) {
+				t.Fatal("decrypted data differs from plain data")
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsTruncatedStream(t *testing.T) {
+	c := newTestCipher(t)
+	sealedChunk := ChunkSize + c.aead.Overhead()
+
+	tests := []struct {
+		name string
+		size int
+		// cut is the number of bytes removed from the end of the stream.
+		cut int
+	}{
+		{name: "last chunk is dropped", size: ChunkSize + 10, cut: 10 + c.aead.Overhead()},
+		{name: "last full chunk is dropped", size: 2 * ChunkSize, cut: sealedChunk},
+		{name: "chunk on boundary is dropped", size: ChunkSize, cut: sealedChunk},
+		{name: "last chunk is cut", size: ChunkSize + 10, cut: 1},
+		{name: "only nonce is left", size: 10, cut: 10 + c.aead.Overhead()},
+		{name: "nonce is cut", size: 0, cut: c.aead.Overhead() + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted := encrypt(t, c, randomData(t, tt.size))
+
+			if _, err := decrypt(c, encrypted[:len(encrypted)-tt.cut]); err == nil {
+				t.Fatal("truncated stream must not be decrypted")
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsTamperedStream(t *testing.T) {
+	c := newTestCipher(t)
+	encrypted := encrypt(t, c, randomData(t, 2*ChunkSize+10))
+
+	for _, pos := range []int{0, c.aead.NonceSize(), ChunkSize, len(encrypted) - 1} {
+		tampered := append([]byte(nil), encrypted...)
+		tampered[pos] ^= 1
+
+		if _, err := decrypt(c, tampered); err == nil {
+			t.Fatalf("stream tampered at %d must not be decrypted", pos)
+		}
+	}
+
+	// Chunks must not be reordered.
+	sealedChunk := ChunkSize + c.aead.Overhead()
+	first := encrypted[c.aead.NonceSize() : c.aead.NonceSize()+sealedChunk]
+	second := encrypted[c.aead.NonceSize()+sealedChunk : c.aead.NonceSize()+2*sealedChunk]
+
+	var swapped []byte
+	swapped = append(swapped, encrypted[:c.aead.NonceSize()]...)
+	swapped = append(swapped, second...)
+	swapped = append(swapped, first...)
+	swapped = append(swapped, encrypted[c.aead.NonceSize()+2*sealedChunk:]...)
+
+	if _, err := decrypt(c, swapped); err == nil {
+		t.Fatal("stream with reordered chunks must not be decrypted")
+	}
+}
+
+func TestDecryptRejectsWrongKey(t *testing.T) {
+	plain := randomData(t, ChunkSize+10)
+	encrypted := encrypt(t, newTestCipher(t), plain)
+
+	if _, err := decrypt(newTestCipher(t), encrypted); err == nil {
+		t.Fatal("stream must not be decrypted with wrong key")
+	}
+}
+
+func TestDecryptRejectsWrongPassphrase(t *testing.T) {
+	salt := NewSalt()
+
+	key, err := KeyFromPassphrase("secret", salt)
+	if err != nil {
+		t.Fatalf("key from passphrase: %v", err)
+	}
+
+	wrongKey, err := KeyFromPassphrase("wrong", salt)
+	if err != nil {
+		t.Fatalf("key from wrong passphrase: %v", err)
+	}
+
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatalf("new cipher: %v", err)
+	}
+
+	wrong, err := NewCipher(wrongKey)
+	if err != nil {
+		t.Fatalf("new wrong cipher: %v", err)
+	}
+
+	encrypted := encrypt(t, c, randomData(t, 100))
+
+	if _, err := decrypt(wrong, encrypted); err == nil {
+		t.Fatal("stream must not be decrypted with key of wrong passphrase")
+	}
+
+	if _, err := wrong.OpenString(c.SealString([]byte("header"))); err == nil {
+		t.Fatal("sealed data must not be opened with key of wrong passphrase")
+	}
+
+	if _, err := KeyFromPassphrase("", salt); err != ErrNoKey {
+		t.Fatalf("empty passphrase must be rejected, got: %v", err)
+	}
+}
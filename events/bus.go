@@ -0,0 +1,63 @@
+package events
+
+import "sync"
+
+const (
+	TaskCreated     = "task.created"
+	TaskProgress    = "task.progress"
+	TaskStatus      = "task.status"
+	ConnectionState = "connection.state"
+)
+
+// SubscriberBuffer is the number of events that subscriber may lag behind.
+// Events that do not fit are dropped for that subscriber.
+const SubscriberBuffer = 64
+
+type Event struct {
+	Type string
+	Data any
+}
+
+// Bus delivers published events to all subscribers.
+//
+// Nil bus is valid and drops all events.
+type Bus struct {
+	subscribers map[chan Event]struct{}
+	mu          sync.Mutex
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: map[chan Event]struct{}{}}
+}
+
+// Subscribe returns channel with events and function
+// that must be called to unsubscribe.
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, SubscriberBuffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
+
+func (b *Bus) Publish(eventType string, data any) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- Event{Type: eventType, Data: data}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package fsnotify
+
+import "os"
+
+// inode is not available, so moves are detected only by size and modification time.
+func inode(_ os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package fsnotify
+
+import (
+	"os"
+	"syscall"
+)
+
+func inode(stat os.FileInfo) uint64 {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+
+	return uint64(sys.Ino)
+}
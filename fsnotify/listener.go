@@ -6,26 +6,29 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 
-	"github.com/ffenix113/teleporter/manager/arman92"
+	"github.com/ffenix113/teleporter/ignore"
+	"github.com/ffenix113/teleporter/manager/engine"
 	"github.com/ffenix113/teleporter/tasks"
 )
 
-const MaxFileSizeInKB = 50 * 1024
-
-func NewListener(path string, cl *arman92.Client) *fsnotify.Watcher {
+func NewListener(path string, cl *engine.Client) *fsnotify.Watcher {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	processFunc := NewProcessEventFunc(cl, watcher)
+	debounceDuration := 2 * time.Second
+	// Events of the old and new path of moved file are debounced separately,
+	// so wait for the pair a bit longer than debounce.
+	moves := NewMoves(2 * debounceDuration)
+
+	processFunc := NewProcessEventFunc(cl, watcher, moves)
 
-	debouncer := NewDebounce(2 * time.Second)
+	debouncer := NewDebounce(debounceDuration)
 
 	go func() {
 		defer func() {
@@ -38,8 +41,12 @@ func NewListener(path string, cl *arman92.Client) *fsnotify.Watcher {
 					return
 				}
 
-				// Do not store cache and temp files.
-				if strings.HasSuffix(event.Name, "~") {
+				if filepath.Base(event.Name) == ignore.FileName {
+					cl.Ignore.Invalidate(filepath.Dir(cl.RelativePath(filepath.ToSlash(event.Name))))
+				}
+
+				stat, err := os.Stat(event.Name)
+				if cl.Ignore.IgnoredAbs(filepath.ToSlash(event.Name), err == nil && stat.IsDir()) {
 					continue
 				}
 
@@ -53,23 +60,41 @@ func NewListener(path string, cl *arman92.Client) *fsnotify.Watcher {
 		}
 	}()
 
-	err = AddRecursively(watcher, path)
+	err = AddRecursively(watcher, path, cl.Ignore)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if err := moves.Scan(path); err != nil {
+		log.Fatal(err)
+	}
+
 	return watcher
 }
 
 type ProcessEventFunc func(event fsnotify.Event)
 
-func NewProcessEventFunc(cl *arman92.Client, watcher *fsnotify.Watcher) func(fsnotify.Event) {
+func NewProcessEventFunc(cl *engine.Client, watcher *fsnotify.Watcher, moves *Moves) func(fsnotify.Event) {
 	return func(event fsnotify.Event) {
 		switch {
 		case IsOp(event.Op, fsnotify.Create):
 			stat, err := os.Stat(event.Name)
 			if err != nil {
-				cl.AddTask(arman92.NewStaticTask(event.Name, arman92.NewCommon(nil, "UploadFile", tasks.TaskStatusError, fmt.Sprintf("stat file failed: %s", err.Error()))))
+				cl.AddTask(engine.NewStaticTask(event.Name, engine.NewCommon(nil, "UploadFile", tasks.TaskStatusError, fmt.Sprintf("stat file failed: %s", err.Error()))))
+				return
+			}
+
+			if oldPath, ok := moves.Created(event.Name, stat); ok {
+				if !stat.IsDir() {
+					cl.AddTask(engine.NewMoveFile(cl, oldPath, event.Name))
+					return
+				}
+
+				// Watches of moved subdirectories still have old paths.
+				if err := AddRecursively(watcher, event.Name, cl.Ignore); err != nil {
+					log.Printf("add moved dir: %s", err.Error())
+				}
+				cl.AddTask(engine.NewMoveDir(cl, oldPath, event.Name))
 				return
 			}
 
@@ -79,41 +104,72 @@ func NewProcessEventFunc(cl *arman92.Client, watcher *fsnotify.Watcher) func(fsn
 				}
 				return
 			}
-			if fileSize := stat.Size(); fileSize != 0 {
-				kbs := fileSize / 1024
-				if kbs > MaxFileSizeInKB {
-					cl.AddTask(arman92.NewStaticTask(event.Name, arman92.NewCommon(nil, "UploadFile", tasks.TaskStatusError, fmt.Sprintf("file is larger than supported: %d > %d KB", kbs, MaxFileSizeInKB))))
-					return
-				}
-				cl.AddTask(arman92.NewUploadFile(cl, event.Name, "file created"))
+			if stat.Size() != 0 {
+				cl.AddTask(engine.NewUploadFile(cl, event.Name, "file created"))
 			}
 		case IsOp(event.Op, fsnotify.Write):
 			stat, err := os.Stat(event.Name)
 			if err != nil {
-				cl.AddTask(arman92.NewStaticTask(event.Name, arman92.NewCommon(nil, "UploadFile", tasks.TaskStatusError, fmt.Sprintf("stat file failed: %s", err.Error()))))
+				cl.AddTask(engine.NewStaticTask(event.Name, engine.NewCommon(nil, "UploadFile", tasks.TaskStatusError, fmt.Sprintf("stat file failed: %s", err.Error()))))
 				return
 			}
-			if fileSize := stat.Size(); fileSize != 0 {
-				if kbs := fileSize / 1024; kbs > MaxFileSizeInKB {
-					cl.AddTask(arman92.NewStaticTask(event.Name, arman92.NewCommon(nil, "UploadFile", tasks.TaskStatusError, fmt.Sprintf("file is larger than supported: %d > %d KB", kbs, MaxFileSizeInKB))))
-					return
-				}
-				cl.AddTask(arman92.NewUploadFile(cl, event.Name, "file write"))
+			moves.Track(event.Name, stat)
+			if stat.Size() != 0 {
+				cl.AddTask(engine.NewUploadFile(cl, event.Name, "file write"))
 				return
 			}
-		case IsOp(event.Op, fsnotify.Remove) || IsOp(event.Op, fsnotify.Rename):
-			// Rename will be accompanied by a Create event.
-			cl.AddTask(arman92.NewDeleteFile(cl, event.Name))
+		case IsOp(event.Op, fsnotify.Rename):
+			// Rename may be accompanied by a Create event of the new path.
+			moves.Renamed(event.Name, func() {
+				if _, err := os.Stat(event.Name); err == nil {
+					// Path was replaced by another file, Create event of which
+					// was merged with this Rename by the debouncer.
+					cl.AddTask(engine.NewUploadFile(cl, event.Name, "file replaced"))
+					return
+				}
+
+				addDeleteTask(cl, event.Name)
+			})
+		case IsOp(event.Op, fsnotify.Remove):
+			moves.Forget(event.Name)
+			addDeleteTask(cl, event.Name)
 		}
 	}
 }
 
-func AddRecursively(w *fsnotify.Watcher, dirPath string) error {
+// addDeleteTask adds task to delete removed file or directory.
+func addDeleteTask(cl *engine.Client, path string) {
+	// Removal of the whole directory produces events for each file in it,
+	// while the directory will be deleted by a single task.
+	if _, err := os.Stat(filepath.Dir(path)); err != nil {
+		return
+	}
+
+	relativePath := cl.RelativePath(path)
+	if _, ok := cl.HeaderFile(relativePath); ok {
+		// Local copy of cloud only file is removed without deleting remote one.
+		if !cl.CloudOnly(relativePath, false) {
+			cl.AddTask(engine.NewDeleteFile(cl, path))
+		}
+		return
+	}
+
+	// Directory may be already deleted, i.e. by a request to web API.
+	if _, ok := cl.ListDir(relativePath); ok && !cl.CloudOnly(relativePath, true) {
+		cl.AddTask(engine.NewDeleteDir(cl, path))
+	}
+}
+
+func AddRecursively(w *fsnotify.Watcher, dirPath string, matcher *ignore.Matcher) error {
 	return filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return fmt.Errorf("add listen dir: %w", err)
 		}
 
+		if d.IsDir() && matcher.IgnoredAbs(filepath.ToSlash(path), true) {
+			return filepath.SkipDir
+		}
+
 		if d.IsDir() {
 			if err := w.Add(path); err != nil {
 				return err
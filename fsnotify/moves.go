@@ -0,0 +1,211 @@
+package fsnotify
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileID identifies file across renames.
+type fileID struct {
+	ino     uint64
+	size    int64
+	modTime int64
+	dir     bool
+}
+
+func newFileID(stat os.FileInfo) fileID {
+	id := fileID{ino: inode(stat), dir: stat.IsDir()}
+	// Size and modification time of directory change with its content,
+	// so only inode is used for directories.
+	if !id.dir {
+		id.size = stat.Size()
+		id.modTime = stat.ModTime().UnixNano()
+	}
+
+	return id
+}
+
+// valid reports whether file can be reliably found by this ID.
+func (id fileID) valid() bool {
+	return !id.dir || id.ino != 0
+}
+
+// Moves pairs Rename events with Create events of the same file,
+// so moved files are not uploaded again.
+//
+// fsnotify reports move as Rename of the old path and Create of the new one,
+// which can be processed in any order.
+type Moves struct {
+	window time.Duration
+
+	paths map[string]fileID
+	ids   map[fileID]string
+	// renamed holds paths that were renamed, but their new path is not known yet.
+	renamed map[string]*time.Timer
+	// moved holds paths which new location was found before their Rename was processed.
+	moved map[string]struct{}
+	mu    sync.Mutex
+}
+
+func NewMoves(window time.Duration) *Moves {
+	return &Moves{
+		window:  window,
+		paths:   map[string]fileID{},
+		ids:     map[fileID]string{},
+		renamed: map[string]*time.Timer{},
+		moved:   map[string]struct{}{},
+	}
+}
+
+// Scan tracks all files and directories in the root directory.
+func (m *Moves) Scan(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		m.Track(filepath.ToSlash(path), stat)
+
+		return nil
+	})
+}
+
+// Track remembers current state of the file.
+func (m *Moves) Track(path string, stat os.FileInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.track(path, newFileID(stat))
+}
+
+func (m *Moves) track(path string, id fileID) {
+	if old, ok := m.paths[path]; ok && m.ids[old] == path {
+		delete(m.ids, old)
+	}
+
+	if !id.valid() {
+		delete(m.paths, path)
+		return
+	}
+
+	m.paths[path] = id
+	m.ids[id] = path
+}
+
+// Forget removes the path and everything in it from tracked files.
+func (m *Moves) Forget(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.forget(path)
+}
+
+func (m *Moves) forget(path string) {
+	for trackedPath, id := range m.paths {
+		if trackedPath != path && !strings.HasPrefix(trackedPath, path+"/") {
+			continue
+		}
+
+		delete(m.paths, trackedPath)
+		if m.ids[id] == trackedPath {
+			delete(m.ids, id)
+		}
+	}
+}
+
+// Renamed handles Rename event of the path.
+//
+// If the new location of the path is not found within the window,
+// onRemove is called.
+func (m *Moves) Renamed(path string, onRemove func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.moved[path]; ok {
+		delete(m.moved, path)
+		return
+	}
+
+	if _, ok := m.paths[path]; !ok {
+		go onRemove()
+		return
+	}
+
+	if timer, ok := m.renamed[path]; ok {
+		timer.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(m.window, func() {
+		m.mu.Lock()
+		if m.renamed[path] != timer {
+			// Path was moved or renamed again meanwhile.
+			m.mu.Unlock()
+			return
+		}
+
+		delete(m.renamed, path)
+		m.forget(path)
+		m.mu.Unlock()
+
+		onRemove()
+	})
+
+	m.renamed[path] = timer
+}
+
+// Created handles Create event of the path.
+//
+// It returns the old path of the file if it was moved here.
+func (m *Moves) Created(path string, stat os.FileInfo) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := newFileID(stat)
+
+	oldPath, ok := m.ids[id]
+	if !ok || oldPath == path || !id.valid() {
+		m.track(path, id)
+		return "", false
+	}
+
+	// Old path still exists, so this is a hard link and not a move.
+	if _, err := os.Lstat(oldPath); err == nil {
+		m.track(path, id)
+		return "", false
+	}
+
+	if timer, ok := m.renamed[oldPath]; ok {
+		timer.Stop()
+		delete(m.renamed, oldPath)
+	} else {
+		m.moved[oldPath] = struct{}{}
+	}
+
+	if id.dir {
+		for trackedPath, trackedID := range m.paths {
+			if !strings.HasPrefix(trackedPath, oldPath+"/") {
+				continue
+			}
+
+			newPath := path + strings.TrimPrefix(trackedPath, oldPath)
+			delete(m.paths, trackedPath)
+			m.paths[newPath] = trackedID
+			m.ids[trackedID] = newPath
+		}
+	}
+
+	delete(m.paths, oldPath)
+	m.track(path, id)
+
+	return oldPath, true
+}
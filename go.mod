@@ -6,6 +6,7 @@ require (
 	github.com/Arman92/go-tdlib/v2 v2.0.0
 	github.com/fsnotify/fsnotify v1.5.1
 	github.com/go-chi/chi/v5 v5.0.7
+	golang.org/x/crypto v0.0.0-20220321153916-2c7772ba3064
 	golang.org/x/exp v0.0.0-20220328175248-053ad81199eb
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
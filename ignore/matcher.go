@@ -0,0 +1,160 @@
+// Package ignore decides which files should not be synchronized,
+// based on gitignore-style patterns.
+package ignore
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// FileName is the name of the file with patterns.
+// Such file can be placed in any directory and its patterns
+// are applied relative to that directory.
+const FileName = ".teleporterignore"
+
+// DefaultPatterns are applied before any other pattern.
+var DefaultPatterns = []string{
+	"*~",
+	".*.sw[a-p]",
+	".DS_Store",
+}
+
+// Matcher checks paths against global patterns
+// and patterns from ignore files in the synchronized directory.
+//
+// Ignore files are read lazily and cached till Invalidate is called.
+type Matcher struct {
+	root   string
+	global []Pattern
+	// dirs holds patterns from ignore files by directory relative to root.
+	dirs   map[string][]Pattern
+	dirsMu sync.Mutex
+}
+
+// NewMatcher creates matcher for files in root directory.
+// Global patterns are applied relative to the root.
+func NewMatcher(root string, patterns []string) *Matcher {
+	m := &Matcher{
+		root: strings.TrimSuffix(root, "/"),
+		dirs: map[string][]Pattern{},
+	}
+
+	for _, line := range append(append([]string{}, DefaultPatterns...), patterns...) {
+		if p, ok := ParsePattern("", line); ok {
+			m.global = append(m.global, p)
+		}
+	}
+
+	return m
+}
+
+// Ignored reports whether path relative to the root should not be synchronized.
+//
+// Path is ignored if it or any of its parent directories match.
+func (m *Matcher) Ignored(relativePath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+
+	return matchParents(relativePath, isDir, m.match)
+}
+
+// IgnoredAbs is the same as Ignored, but for absolute path.
+// Paths outside of the root are never ignored.
+func (m *Matcher) IgnoredAbs(absPath string, isDir bool) bool {
+	if m == nil || !strings.HasPrefix(absPath, m.root+"/") {
+		return false
+	}
+
+	return m.Ignored(absPath[len(m.root)+1:], isDir)
+}
+
+// match applies patterns to the path without checking its parents.
+// Last matching pattern wins.
+func (m *Matcher) match(relativePath string, isDir bool) bool {
+	var ignored bool
+	for _, p := range m.patterns(path.Dir(relativePath)) {
+		if p.match(relativePath, isDir) {
+			ignored = !p.negate
+		}
+	}
+
+	return ignored
+}
+
+// patterns returns global patterns and patterns
+// from ignore files in the directory and its parents.
+func (m *Matcher) patterns(relativeDir string) []Pattern {
+	patterns := append([]Pattern{}, m.global...)
+	patterns = append(patterns, m.dirPatterns("")...)
+
+	if relativeDir == "." {
+		return patterns
+	}
+
+	parts := strings.Split(relativeDir, "/")
+	for i := range parts {
+		patterns = append(patterns, m.dirPatterns(strings.Join(parts[:i+1], "/"))...)
+	}
+
+	return patterns
+}
+
+func (m *Matcher) dirPatterns(relativeDir string) []Pattern {
+	m.dirsMu.Lock()
+	defer m.dirsMu.Unlock()
+
+	if patterns, ok := m.dirs[relativeDir]; ok {
+		return patterns
+	}
+
+	patterns, err := m.readPatterns(relativeDir)
+	if err != nil {
+		log.Printf("read ignore file: %s\n", err.Error())
+	}
+
+	m.dirs[relativeDir] = patterns
+
+	return patterns
+}
+
+func (m *Matcher) readPatterns(relativeDir string) ([]Pattern, error) {
+	f, err := os.Open(path.Join(m.root, relativeDir, FileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns, err := ParsePatterns(relativeDir, f)
+	if err != nil {
+		return nil, fmt.Errorf("parse %q: %w", f.Name(), err)
+	}
+
+	return patterns, nil
+}
+
+// Invalidate drops cached patterns of the ignore file in the directory,
+// so they will be read again on next check.
+func (m *Matcher) Invalidate(relativeDir string) {
+	if m == nil {
+		return
+	}
+
+	relativeDir = strings.Trim(relativeDir, "/")
+	if relativeDir == "." {
+		relativeDir = ""
+	}
+
+	m.dirsMu.Lock()
+	defer m.dirsMu.Unlock()
+
+	delete(m.dirs, relativeDir)
+}
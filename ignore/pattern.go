@@ -0,0 +1,171 @@
+package ignore
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"strings"
+)
+
+// Pattern is a single gitignore-style pattern.
+type Pattern struct {
+	// base is the directory relative to the root in which pattern was defined.
+	base string
+	glob []string
+	// negate re-includes paths matched by previous patterns.
+	negate bool
+	// dirOnly patterns match only directories.
+	dirOnly bool
+	// anchored patterns are matched against the path relative to base,
+	// while others are matched against the name at any level.
+	anchored bool
+}
+
+// ParsePattern parses pattern defined in base directory.
+//
+// It returns false for empty lines and comments.
+func ParsePattern(base, line string) (Pattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return Pattern{}, false
+	}
+
+	p := Pattern{base: strings.Trim(base, "/")}
+
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+
+	// Escaped leading characters are literal.
+	line = strings.TrimPrefix(line, `\`)
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	p.anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return Pattern{}, false
+	}
+
+	p.glob = strings.Split(line, "/")
+
+	return p, true
+}
+
+// ParsePatterns reads patterns from reader, one per line.
+func ParsePatterns(base string, r io.Reader) ([]Pattern, error) {
+	var patterns []Pattern
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if p, ok := ParsePattern(base, scanner.Text()); ok {
+			patterns = append(patterns, p)
+		}
+	}
+
+	return patterns, scanner.Err()
+}
+
+// Patterns is a list of patterns that are not read from ignore files.
+type Patterns []Pattern
+
+// NewPatterns parses patterns defined in the root directory.
+func NewPatterns(lines []string) Patterns {
+	var patterns Patterns
+	for _, line := range lines {
+		if p, ok := ParsePattern("", line); ok {
+			patterns = append(patterns, p)
+		}
+	}
+
+	return patterns
+}
+
+// Match reports whether path relative to the root matches patterns.
+//
+// Path matches if it or any of its parent directories match.
+// Last matching pattern wins.
+func (ps Patterns) Match(relativePath string, isDir bool) bool {
+	return matchParents(relativePath, isDir, func(relativePath string, isDir bool) bool {
+		var matched bool
+		for _, p := range ps {
+			if p.match(relativePath, isDir) {
+				matched = !p.negate
+			}
+		}
+
+		return matched
+	})
+}
+
+// matchParents reports whether the path or any of its parent directories match.
+func matchParents(relativePath string, isDir bool, match func(relativePath string, isDir bool) bool) bool {
+	relativePath = strings.Trim(relativePath, "/")
+	if relativePath == "" {
+		return false
+	}
+
+	parts := strings.Split(relativePath, "/")
+	for i := range parts {
+		last := i == len(parts)-1
+		if match(strings.Join(parts[:i+1], "/"), isDir || !last) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match reports whether path relative to the root matches the pattern.
+func (p Pattern) match(relativePath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	if p.base != "" {
+		if !strings.HasPrefix(relativePath, p.base+"/") {
+			return false
+		}
+
+		relativePath = relativePath[len(p.base)+1:]
+	}
+
+	if !p.anchored {
+		ok, _ := path.Match(p.glob[0], path.Base(relativePath))
+		return ok
+	}
+
+	return matchSegments(p.glob, strings.Split(relativePath, "/"))
+}
+
+// matchSegments matches path segments against glob segments,
+// where "**" matches any number of segments.
+func matchSegments(glob, segments []string) bool {
+	for len(glob) != 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(glob[1:], segments[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(glob[0], segments[0]); !ok {
+			return false
+		}
+
+		glob, segments = glob[1:], segments[1:]
+	}
+
+	return len(segments) == 0
+}
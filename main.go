@@ -2,46 +2,56 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 
 	"github.com/ffenix113/teleporter/config"
-	"github.com/ffenix113/teleporter/fsnotify"
-	"github.com/ffenix113/teleporter/manager/arman92"
-	"github.com/ffenix113/teleporter/web"
 )
 
 func main() {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(log.LstdFlags | log.Llongfile | log.Lmicroseconds)
-	log.Println("hello")
 
-	cnf := config.Load()
+	name, args := "serve", os.Args[1:]
+	switch {
+	case len(args) != 0 && (args[0] == "-h" || args[0] == "--help"):
+		name = "help"
+	case len(args) != 0 && !strings.HasPrefix(args[0], "-"):
+		name, args = args[0], args[1:]
+	}
 
-	log.Println("create client")
-	cl, err := arman92.NewClient(context.Background(), cnf)
-	if err != nil {
-		panic(err)
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(2)
 	}
 
-	log.Println("starting web server")
-	go web.Listen(cnf, cnf.App.WebListen, cnf.App.TemplatePath, cl)
+	// Output of one-shot commands may be piped, so logs go to stderr.
+	if cmd.Name != "serve" {
+		log.SetOutput(os.Stderr)
+	}
 
-	log.Println("update files state on start")
-	if err := cl.SynchronizeFiles(); err != nil {
-		panic(err)
+	// Help does not need config to be present.
+	var cnf config.Config
+	if cmd.Name != "help" {
+		cnf = config.Load()
 	}
-	log.Println("update files state on start done")
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
 
-	log.Println("start file listener")
-	listener := fsnotify.NewListener(cnf.App.FilesPath, cl)
+	if err := cmd.Run(ctx, cnf, args); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "usage: teleporter %s\n", cmd.Usage)
+			os.Exit(2)
+		}
 
-	log.Println("waiting for exit")
-	<-ctx.Done()
-	listener.Close()
-	log.Println("Shutdown", ctx.Err().Error())
+		fmt.Fprintf(os.Stderr, "teleporter %s: %s\n", cmd.Name, err.Error())
+		os.Exit(1)
+	}
 }
@@ -1,61 +1,61 @@
 package arman92
 
 import (
+	"errors"
 	"fmt"
-	"io"
 
 	"github.com/Arman92/go-tdlib/v2/tdlib"
-)
 
-// Sentinel questions
-const (
-	PhonePrompt    = "Enter phone number: "
-	CodePrompt     = "Enter code: "
-	PasswordPrompt = "Enter Password: "
+	"github.com/ffenix113/teleporter/manager"
 )
 
-// Auth will block until authorization is complete.
+var _ manager.Authorizer = (*Client)(nil)
+
+// AuthState returns the current authorization state.
 //
-// Set reader and writer to appropriate values to redirect auth IO.
-// Use sentinel prompts to check to what info is required.
-func (c *Client) Auth(r io.Reader, w io.Writer) error {
-	for {
-		currentState, err := c.TDClient.Authorize()
-		if err != nil {
-			return fmt.Errorf("error getting current auth state: %w", err)
-		}
-
-		switch currentState.GetAuthorizationStateEnum() {
-		case tdlib.AuthorizationStateWaitPhoneNumberType:
-			fmt.Fprint(w, PhonePrompt)
-			var number string
-			fmt.Fscanln(r, &number)
-			_, err := c.TDClient.SendPhoneNumber(number)
-			if err != nil {
-				fmt.Printf("Error sending phone number: %v", err)
-			}
-		case tdlib.AuthorizationStateWaitCodeType:
-			fmt.Fprint(w, CodePrompt)
-			var code string
-			fmt.Fscanln(r, &code)
-			_, err := c.TDClient.SendAuthCode(code)
-			if err != nil {
-				fmt.Printf("Error sending auth code : %v", err)
-			}
-		case tdlib.AuthorizationStateWaitPasswordType:
-			fmt.Fprint(w, PasswordPrompt)
-			var password string
-			fmt.Fscanln(r, &password)
-			_, err := c.TDClient.SendAuthPassword(password)
-			if err != nil {
-				fmt.Printf("Error sending auth password: %v", err)
-			}
-		case tdlib.AuthorizationStateWaitTdlibParametersType:
-			panic("probably wrong client parameters in config: client was not able to send parameters")
-		case tdlib.AuthorizationStateReadyType:
-			return nil
-		default:
-			panic(fmt.Sprintf("unknown returned client auth state: %q", currentState.GetAuthorizationStateEnum()))
-		}
+// It also sends tdlib parameters if client waits for them.
+func (c *Client) AuthState() (string, error) {
+	currentState, err := c.TDClient.Authorize()
+	if err != nil {
+		return "", fmt.Errorf("get current auth state: %w", err)
+	}
+
+	switch currentState.GetAuthorizationStateEnum() {
+	case tdlib.AuthorizationStateWaitPhoneNumberType:
+		return manager.AuthWaitPhone, nil
+	case tdlib.AuthorizationStateWaitCodeType:
+		return manager.AuthWaitCode, nil
+	case tdlib.AuthorizationStateWaitPasswordType:
+		return manager.AuthWaitPassword, nil
+	case tdlib.AuthorizationStateReadyType:
+		return manager.AuthReady, nil
+	case tdlib.AuthorizationStateWaitRegistrationType:
+		return "", errors.New("phone number is not registered in Telegram")
+	default:
+		return manager.AuthPending, nil
+	}
+}
+
+func (c *Client) SendPhone(phone string) error {
+	if _, err := c.TDClient.SendPhoneNumber(phone); err != nil {
+		return fmt.Errorf("send phone number: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) SendCode(code string) error {
+	if _, err := c.TDClient.SendAuthCode(code); err != nil {
+		return fmt.Errorf("send auth code: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) SendPassword(password string) error {
+	if _, err := c.TDClient.SendAuthPassword(password); err != nil {
+		return fmt.Errorf("send auth password: %w", err)
 	}
+
+	return nil
 }
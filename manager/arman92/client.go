@@ -5,12 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
 	"log"
-	"os"
-	"path"
-	"path/filepath"
-	"strings"
+	"regexp"
+	"strconv"
 	"sync"
 	"time"
 
@@ -19,71 +16,76 @@ import (
 
 	"github.com/ffenix113/teleporter/config"
 	"github.com/ffenix113/teleporter/manager"
-	"github.com/ffenix113/teleporter/tasks"
 )
 
-const Teleporter = "Teleporter"
+var floodWaitRegexp = regexp.MustCompile(`(?:FLOOD_WAIT_|retry after )(\d+)`)
 
 // UpdateHandler will return true when appropriate update
 // is caught and this handler can be removed.
 type UpdateHandler func(update tdlib.UpdateMsg) bool
 
+// session is the tdlib client with its updates,
+// which is shared by clients of different chats.
+type session struct {
+	TDClient   *client.Client
+	rawUpdates chan tdlib.UpdateMsg
+
+	updateHandlers   []updateHandler
+	lastHandlerID    uint64
+	updateHandlersMu sync.Mutex
+}
+
+// updateHandler is the registered handler with its ID,
+// so it can be removed before it handles the update.
+type updateHandler struct {
+	id     uint64
+	handle UpdateHandler
+}
+
+// Client is a storage backend that keeps files
+// in the Telegram chat using tdlib.
 type Client struct {
-	TDClient     *client.Client
-	PinnedHeader manager.PinnedHeader
-	FileTree     *manager.Tree
-	FilesPath    string
-	TaskMonitor  *tasks.Monitor
-	rawUpdates   chan tdlib.UpdateMsg
+	*session
+	cnf config.Telegram
 	// chatID is the chat in which files are stored.
 	chatID int64
 	// pinnedHeaderMessageID is the ID of the pinned header.
+	// It is zero till the header is written first time.
 	pinnedHeaderMessageID int64
-
-	updateHandlers   []UpdateHandler
-	updateHandlersMu sync.Mutex
-
-	ConnectionState string
-	TempPath        string
+	// pinnedMu guards pinnedHeaderMessageID.
+	pinnedMu sync.RWMutex
+
+	// watchMu guards watchers and connection state.
+	watchMu         sync.Mutex
+	headerWatchers  []func(text string)
+	stateWatchers   []func(state string)
+	connectionState string
 }
 
+var (
+	_ manager.Manager = (*Client)(nil)
+	_ manager.Session = (*Client)(nil)
+)
+
 // NewClient returns a new client to access Telegram.
 //
-// Context must live for as long as application should live.
-func NewClient(ctx context.Context, cnf config.Config) (*Client, error) {
-	client.SetLogVerbosityLevel(cnf.Telegram.LogLevel)
+// Client has to be authorized and started before it is used.
+func NewClient(cnf config.Telegram) *Client {
+	client.SetLogVerbosityLevel(cnf.LogLevel)
 	// Create new instance of TDClient
-	client := client.NewClient(cnf.Telegram.Config)
-
-	if !strings.HasSuffix(cnf.App.FilesPath, "/") {
-		cnf.App.FilesPath += "/"
-	}
-
-	c := &Client{
-		TDClient:     client,
-		TaskMonitor:  tasks.NewMonitor(ctx),
-		FilesPath:    cnf.App.FilesPath,
-		TempPath:     cnf.App.TempPath,
-		PinnedHeader: manager.PinnedHeader{Header: Teleporter, Files: map[string]int64{}},
-		FileTree:     &manager.Tree{},
+	return &Client{
+		session: &session{TDClient: client.NewClient(client.Config(cnf.Config))},
+		cnf:     cnf,
 	}
+}
 
-	if c.TempPath == "" {
-		c.TempPath = c.tempPath()
-	}
-
-	if _, err := os.Stat(c.TempPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(c.TempPath, 0755); err != nil {
-			return nil, fmt.Errorf("create temp files dir: %w", err)
-		}
-	}
-
-	log.Println("authenticating")
-	c.Auth(os.Stdin, os.Stdout)
-
+// Start starts listening for updates and finds the chat.
+//
+// It will block until client is connected.
+func (c *Client) Start(ctx context.Context) error {
 	c.rawUpdates = c.TDClient.GetRawUpdatesChannel(10)
 	// c.AddUpdateHandler(VerboseUpdateHandler)
-	c.AddUpdateHandler(c.ListenHeaderMessageUpdates)
+	c.listenUpdates()
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -97,9 +99,7 @@ func NewClient(ctx context.Context, cnf config.Config) (*Client, error) {
 			var updateState tdlib.UpdateConnectionState
 			json.Unmarshal(update.Raw, &updateState)
 
-			connectionState := string(updateState.State.GetConnectionStateEnum())
-			c.ConnectionState = strings.TrimPrefix(connectionState, "connectionState")
-			if tdlib.ConnectionStateEnum(connectionState) == tdlib.ConnectionStateReadyType {
+			if updateState.State.GetConnectionStateEnum() == tdlib.ConnectionStateReadyType {
 				log.Println("status ready, continuing")
 				wg.Done()
 				return true
@@ -113,25 +113,103 @@ func NewClient(ctx context.Context, cnf config.Config) (*Client, error) {
 	wg.Wait()
 
 	log.Println("fetching init information")
-	if err := c.FetchInitInformation(ctx, cnf.Telegram); err != nil {
+	if err := c.FetchInitInformation(ctx, c.cnf); err != nil {
+		return fmt.Errorf("fetch init: %w", err)
+	}
+
+	return nil
+}
+
+// ForChat returns client for other chat, which uses the same session.
+// Client must be started.
+func (c *Client) ForChat(ctx context.Context, chatName string, chatID int64) (manager.Manager, error) {
+	cnf := c.cnf
+	cnf.ChatName, cnf.ChatID = chatName, chatID
+
+	// Connection is shared, so its state is known already.
+	c.watchMu.Lock()
+	chatClient := &Client{session: c.session, cnf: cnf, connectionState: c.connectionState}
+	c.watchMu.Unlock()
+
+	chatClient.listenUpdates()
+
+	if err := chatClient.FetchInitInformation(ctx, cnf); err != nil {
 		return nil, fmt.Errorf("fetch init: %w", err)
 	}
 
-	return c, nil
+	return chatClient, nil
+}
+
+// listenUpdates adds handlers of updates of the chat.
+func (c *Client) listenUpdates() {
+	c.AddUpdateHandler(c.ListenHeaderMessageUpdates)
+	c.AddUpdateHandler(c.ListenConnectionStateUpdates)
+}
+
+func (c *Client) FetchInitInformation(ctx context.Context, cnf config.Telegram) error {
+	filesChat, err := c.FindChat(ctx, cnf)
+	if err != nil {
+		return fmt.Errorf("find chat: %w", err)
+	}
+
+	c.chatID = filesChat.ID
+
+	pinnedID, err := c.findPinnedMessage(ctx)
+	if err != nil {
+		return fmt.Errorf("find pinned message: %w", err)
+	}
+
+	c.setPinnedMessageID(pinnedID)
+
+	return nil
 }
 
-func (c *Client) AddTask(tsk tasks.Task) {
-	c.TaskMonitor.AddTask(tsk)
+func (c *Client) FindChat(ctx context.Context, tConf config.Telegram) (*tdlib.Chat, error) {
+	if tConf.ChatName != "" {
+		chats, err := c.TDClient.SearchChatsOnServer(tConf.ChatName, 2)
+		if err != nil {
+			return nil, fmt.Errorf("search chat: %w", err)
+		}
+
+		if len(chats.ChatIDs) != 1 {
+			return nil, fmt.Errorf("wrong number of channels found: want: 1, found: %d", len(chats.ChatIDs))
+		}
+
+		tConf.ChatID = chats.ChatIDs[0]
+	}
+
+	chat, err := c.TDClient.GetChat(tConf.ChatID)
+	if err != nil {
+		return nil, fmt.Errorf("get chat: %w", err)
+	}
+
+	return chat, nil
 }
 
-func (c *Client) AddPreAddHook(hook tasks.Hook) {
-	c.TaskMonitor.AddPreAddHook(hook)
+func (s *session) AddUpdateHandler(handler UpdateHandler) {
+	s.addUpdateHandler(handler)
 }
 
-func (c *Client) AddUpdateHandler(handler UpdateHandler) {
-	c.updateHandlersMu.Lock()
-	c.updateHandlers = append(c.updateHandlers, handler)
-	c.updateHandlersMu.Unlock()
+// addUpdateHandler adds handler and returns function that removes it,
+// for handlers that wait for the update of request that can be cancelled.
+func (s *session) addUpdateHandler(handler UpdateHandler) (remove func()) {
+	s.updateHandlersMu.Lock()
+	s.lastHandlerID++
+	id := s.lastHandlerID
+	s.updateHandlers = append(s.updateHandlers, updateHandler{id: id, handle: handler})
+	s.updateHandlersMu.Unlock()
+
+	return func() {
+		s.updateHandlersMu.Lock()
+		defer s.updateHandlersMu.Unlock()
+
+		for i, h := range s.updateHandlers {
+			if h.id == id {
+				s.updateHandlers = append(s.updateHandlers[:i], s.updateHandlers[i+1:]...)
+				return
+			}
+		}
+	}
 }
 
 func VerboseUpdateHandler(update tdlib.UpdateMsg) bool {
@@ -140,34 +218,38 @@ func VerboseUpdateHandler(update tdlib.UpdateMsg) bool {
 	return false
 }
 
-func (c *Client) listenRawUpdates() {
+func (s *session) listenRawUpdates() {
 	// TODO: This should have custom handlers.
-	for update := range c.rawUpdates {
-		c.updateHandlersMu.Lock()
-		for i, handler := range c.updateHandlers {
-			if handled := handler(update); handled {
-				// FIXME: panics on 'i' here
-				c.updateHandlers = append(c.updateHandlers[:i], c.updateHandlers[i+1:]...)
+	for update := range s.rawUpdates {
+		s.updateHandlersMu.Lock()
+		// Handlers of concurrently running requests may finish
+		// on the same update, so keep only unfinished ones.
+		kept := s.updateHandlers[:0]
+		for _, handler := range s.updateHandlers {
+			if handled := handler.handle(update); !handled {
+				kept = append(kept, handler)
 			}
 		}
-		c.updateHandlersMu.Unlock()
+		s.updateHandlers = kept
+		s.updateHandlersMu.Unlock()
 	}
 }
 
 // SendMessage Sends a message. Returns the sent message
+// @param ctx Cancelling context will cancel sending of the message
 // @param chatID Target chat
 // @param messageThreadID If not 0, a message thread identifier in which the message will be sent
 // @param replyToMessageID Identifier of the message to reply to or 0
 // @param options Options to be used to send the message
 // @param replyMarkup Markup for replying to the message; for bots only
 // @param inputMessageContent The content of the message to be sent
-func (c *Client) SendMessage(chatID int64, messageThreadID int64, replyToMessageID int64, options *tdlib.MessageSendOptions, replyMarkup tdlib.ReplyMarkup, inputMessageContent tdlib.InputMessageContent) (*tdlib.Message, error) {
+func (c *Client) SendMessage(ctx context.Context, chatID int64, messageThreadID int64, replyToMessageID int64, options *tdlib.MessageSendOptions, replyMarkup tdlib.ReplyMarkup, inputMessageContent tdlib.InputMessageContent) (*tdlib.Message, error) {
 	msg, err := c.TDClient.SendMessage(chatID, messageThreadID, replyToMessageID, options, replyMarkup, inputMessageContent)
 	if err != nil {
 		return nil, err
 	}
 
-	newID, err := c.waitForMessageSent(msg.ID)
+	newID, err := c.waitForMessageSent(ctx, chatID, msg.ID)
 	if err != nil {
 		return nil, fmt.Errorf("send message: %w", err)
 	}
@@ -177,9 +259,14 @@ func (c *Client) SendMessage(chatID int64, messageThreadID int64, replyToMessage
 	return msg, nil
 }
 
-func (c *Client) waitForMessageSent(msgID int64) (newMsgID int64, err error) {
-	waiter := make(chan struct{})
-	c.AddUpdateHandler(func(update tdlib.UpdateMsg) bool {
+func (c *Client) waitForMessageSent(ctx context.Context, chatID, msgID int64) (int64, error) {
+	type sendResult struct {
+		newMsgID int64
+		err      error
+	}
+
+	waiter := make(chan sendResult, 1)
+	remove := c.addUpdateHandler(func(update tdlib.UpdateMsg) bool {
 		switch tdlib.UpdateEnum(update.Data["@type"].(string)) {
 		case tdlib.UpdateMessageSendSucceededType:
 			var upd tdlib.UpdateMessageSendSucceeded
@@ -189,145 +276,74 @@ func (c *Client) waitForMessageSent(msgID int64) (newMsgID int64, err error) {
 				return false
 			}
 
-			newMsgID = upd.Message.ID
-			close(waiter)
+			waiter <- sendResult{newMsgID: upd.Message.ID}
 			return true
 		case tdlib.UpdateMessageSendFailedType:
 			var upd tdlib.UpdateMessageSendFailed
 			json.Unmarshal(update.Raw, &upd)
 
-			err = fmt.Errorf("send failed: code: %d, error: %s", upd.ErrorCode, upd.ErrorMessage)
-			close(waiter)
+			if upd.OldMessageID != msgID {
+				return false
+			}
+
+			waiter <- sendResult{err: fmt.Errorf("send failed: %w", tdlib.RequestError{Code: int(upd.ErrorCode), Message: upd.ErrorMessage})}
 			return true
 		}
 
 		return false
 	})
-
-	<-waiter
-	return
-}
-
-func (c *Client) FetchInitInformation(ctx context.Context, tConf config.Telegram) error {
-	filesChat, err := c.FindChat(ctx, tConf)
-	if err != nil {
-		return fmt.Errorf("find chat: %w", err)
-	}
-
-	c.chatID = filesChat.ID
-	pinnedHeader, err := c.GetOrInitPinnedMessage(ctx, c.chatID)
-	if err != nil {
-		return fmt.Errorf("find or init pinned message: %w", err)
-	}
-
-	c.pinnedHeaderMessageID = pinnedHeader.ID
-
-	if err := manager.Unmarshal([]byte(pinnedHeader.Content.(*tdlib.MessageText).Text.Text), &c.PinnedHeader); err != nil {
-		return fmt.Errorf("unmarshal pinned message text: %w", err)
-	}
-
-	// TODO: decrypt if header is encrypted. Do in next iteration.
-
-	c.addFilesToTree()
-
-	return nil
-}
-
-func (c *Client) addFilesToTree() {
-	for filePath, msgID := range c.PinnedHeader.Files {
-		data, err := c.GetFileDataByMsgID(context.TODO(), msgID)
-		if err != nil {
-			c.AddTask(NewStaticTask(filePath, &Common{
-				taskType: "FetchData",
-				status:   tasks.TaskStatusError,
-				details:  fmt.Sprintf("get file header: %s", err.Error()),
-			}))
-			continue
+	defer remove()
+
+	select {
+	case res := <-waiter:
+		return res.newMsgID, res.err
+	case <-ctx.Done():
+		// Deleting the message that is not yet sent cancels its sending.
+		if _, err := c.TDClient.DeleteMessages(chatID, []int64{msgID}, true); err != nil {
+			log.Printf("cancel sending message: %s\n", err.Error())
 		}
 
-		data.Name = filepath.Base(data.Path)
-
-		c.FileTree.Add(filePath, &manager.Tree{
-			File: &data,
-		})
+		return 0, ctx.Err()
 	}
 }
 
-func (c *Client) SynchronizeFiles() error {
-	c.DownloadRemoteFiles()
-
-	err := filepath.WalkDir(c.FilesPath, func(path string, d fs.DirEntry, err error) error {
+func (c *Client) EnsureMessagesAreKnown(ctx context.Context, ids ...int64) error {
+	for _, msgId := range ids {
+		_, err := c.TDClient.GetChatHistory(c.chatID, msgId, 0, 1, true)
 		if err != nil {
-			return err
-		}
-
-		if d.IsDir() {
-			return nil
-		}
-
-		if _, exists := c.PinnedHeader.Files[c.RelativePath(path)]; !exists {
-			c.AddTask(NewUploadFile(c, path))
+			_, err = c.TDClient.GetChatHistory(c.chatID, msgId, 0, 1, false)
+			if err != nil {
+				return fmt.Errorf("ensure message online: %w", err)
+			}
 		}
-
-		return nil
-	})
-
-	if err != nil {
-		return fmt.Errorf("walk dir: %w", err)
 	}
 
 	return nil
 }
 
-func (c *Client) DownloadRemoteFiles() {
-	for relativeFilePath, msgID := range c.PinnedHeader.Files {
-		stat, err := os.Stat(c.AbsPath(relativeFilePath))
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				c.AddTask(NewDownloadFile(c, relativeFilePath, "file does not exist"))
-				continue
-			}
-
-			c.AddTask(NewStaticTask(relativeFilePath, &Common{
-				taskType: "VerifyLocalFileExist",
-				status:   tasks.TaskStatusError,
-				progress: 100,
-				details:  fmt.Sprintf("local file stat: %s", err.Error()),
-			}))
-
-			continue
-		}
-
-		data, err := c.GetFileDataByMsgID(context.TODO(), msgID)
-		if err != nil {
-			c.AddTask(NewStaticTask(relativeFilePath, &Common{
-				taskType: "VerifyLocalFileExist",
-				status:   tasks.TaskStatusError,
-				progress: 100,
-				details:  fmt.Sprintf("get file header: %s", err.Error()),
-			}))
-			continue
-		}
-
-		// TODO: decrypt file data
+// retryError converts FLOOD_WAIT error of Telegram
+// into manager.RetryAfterError, and errors of requests
+// that will fail again into manager.PermanentError.
+func retryError(err error) error {
+	var reqErr tdlib.RequestError
+	if err == nil || !errors.As(err, &reqErr) {
+		return err
+	}
 
-		switch {
-		case data.FileUpdatedAt.After(stat.ModTime()):
-			c.AddTask(NewDownloadFile(c, relativeFilePath, fmt.Sprintf("%s > %s", data.FileUpdatedAt.Format(time.RFC3339Nano), stat.ModTime().Format(time.RFC3339Nano))))
-		case data.FileUpdatedAt.Before(stat.ModTime()):
-			c.AddTask(NewUploadFile(c, c.AbsPath(relativeFilePath)))
-		}
+	switch reqErr.Code {
+	case 400, 403, 404:
+		return &manager.PermanentError{Err: err}
+	case 429:
+	default:
+		return err
 	}
-}
 
-func (c *Client) RelativePath(absPath string) string {
-	return strings.TrimPrefix(absPath, c.FilesPath)
-}
+	match := floodWaitRegexp.FindStringSubmatch(reqErr.Message)
+	if match == nil {
+		return err
+	}
 
-func (c *Client) AbsPath(relative string) string {
-	return path.Join(c.FilesPath, relative)
-}
+	seconds, _ := strconv.Atoi(match[1])
 
-func (c *Client) tempPath() string {
-	return path.Join(filepath.Dir(c.FilesPath), ".tmp")
+	return &manager.RetryAfterError{After: time.Duration(seconds) * time.Second, Err: err}
 }
@@ -2,41 +2,221 @@ package arman92
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"strings"
+	"log"
 
 	"github.com/Arman92/go-tdlib/v2/tdlib"
-
-	"github.com/ffenix113/teleporter/config"
-	"github.com/ffenix113/teleporter/manager"
-	"github.com/ffenix113/teleporter/tasks"
 )
 
 const header = `"Header": "Teleporter"`
 
-func (c *Client) FindChat(ctx context.Context, tConf config.Telegram) (*tdlib.Chat, error) {
-	if tConf.ChatName != "" {
-		chats, err := c.TDClient.SearchChatsOnServer(tConf.ChatName, 2)
+// MaxHeaderLength is the max length of the text message.
+// Header that is longer will be sent as a document.
+const MaxHeaderLength = 4096
+
+func (c *Client) Put(ctx context.Context, localPath, caption string, progress func(int)) (int64, error) {
+	_, stopWatch := c.watchUpload(func(file *tdlib.File) bool {
+		return file.Local.Path == localPath
+	}, progress)
+	defer stopWatch()
+
+	msg, err := c.SendMessage(ctx, c.chatID, 0, 0,
+		tdlib.NewMessageSendOptions(true, false, nil),
+		nil,
+		tdlib.NewInputMessageDocument(
+			tdlib.NewInputFileLocal(localPath),
+			nil,
+			true,
+			tdlib.NewFormattedText(caption, nil),
+		),
+	)
+	if err != nil {
+		return 0, retryError(err)
+	}
+
+	return msg.ID, nil
+}
+
+// Replace uploads the file first, as upload started by the edit
+// of the message can not be cancelled, and then edits the message with it.
+func (c *Client) Replace(ctx context.Context, msgID int64, localPath, caption string, progress func(int)) error {
+	file, err := c.TDClient.UploadFile(tdlib.NewInputFileLocal(localPath), tdlib.NewFileTypeDocument(), 1)
+	if err != nil {
+		return fmt.Errorf("upload file: %w", retryError(err))
+	}
+
+	uploaded, stopWatch := c.watchUpload(func(update *tdlib.File) bool {
+		return update.ID == file.ID
+	}, progress)
+	defer stopWatch()
+
+	// Upload may be completed before it is watched.
+	if file, err = c.TDClient.GetFile(file.ID); err != nil {
+		return fmt.Errorf("get uploaded file: %w", retryError(err))
+	}
+
+	if file.Remote.IsUploadingCompleted {
+		progress(100)
+	} else {
+		select {
+		case <-uploaded:
+		case <-ctx.Done():
+			if _, err := c.TDClient.CancelUploadFile(file.ID); err != nil {
+				log.Printf("cancel upload: %s\n", err.Error())
+			}
+
+			return ctx.Err()
+		}
+	}
+
+	_, err = c.TDClient.EditMessageMedia(c.chatID, msgID, nil,
+		tdlib.NewInputMessageDocument(
+			tdlib.NewInputFileID(file.ID),
+			nil,
+			false,
+			tdlib.NewFormattedText(caption, nil),
+		),
+	)
+
+	return retryError(err)
+}
+
+func (c *Client) SetCaption(ctx context.Context, msgID int64, caption string) error {
+	if err := c.EnsureMessagesAreKnown(ctx, msgID); err != nil {
+		return fmt.Errorf("ensure message exists: %w", err)
+	}
+
+	_, err := c.TDClient.EditMessageCaption(c.chatID, msgID, nil, tdlib.NewFormattedText(caption, nil))
+
+	return retryError(err)
+}
+
+func (c *Client) Get(ctx context.Context, msgID int64, progress func(int)) (string, string, error) {
+	file, caption, err := c.downloadDocument(ctx, msgID, progress)
+	if err != nil {
+		return "", "", retryError(err)
+	}
+
+	return file.Local.Path, caption, nil
+}
+
+func (c *Client) Caption(ctx context.Context, msgID int64) (string, error) {
+	if err := c.EnsureMessagesAreKnown(ctx, msgID); err != nil {
+		return "", fmt.Errorf("ensure message exists: %w", err)
+	}
+
+	msg, err := c.TDClient.GetMessage(c.chatID, msgID)
+	if err != nil {
+		return "", fmt.Errorf("get message: %w", retryError(err))
+	}
+
+	doc, ok := msg.Content.(*tdlib.MessageDocument)
+	if !ok {
+		return "", fmt.Errorf("fetched message %d does not contain document", msgID)
+	}
+
+	return doc.Caption.Text, nil
+}
+
+func (c *Client) Delete(_ context.Context, msgIDs ...int64) error {
+	if len(msgIDs) == 0 {
+		return nil
+	}
+
+	_, err := c.TDClient.DeleteMessages(c.chatID, msgIDs, true)
+
+	return retryError(err)
+}
+
+func (c *Client) List(_ context.Context) ([]int64, error) {
+	var ids []int64
+	seen := map[int64]struct{}{}
+
+	var fromID int64
+	for {
+		msgs, err := c.TDClient.SearchChatMessages(c.chatID, "", nil, fromID, 0, 100, tdlib.NewSearchMessagesFilterDocument(), 0)
 		if err != nil {
-			return nil, fmt.Errorf("search chat: %w", err)
+			return nil, fmt.Errorf("search documents: %w", retryError(err))
 		}
 
-		if len(chats.ChatIDs) != 1 {
-			return nil, fmt.Errorf("wrong number of channels found: want: 1, found: %d", len(chats.ChatIDs))
+		found := false
+		for _, msg := range msgs.Messages {
+			if _, ok := seen[msg.ID]; ok {
+				continue
+			}
+
+			seen[msg.ID] = struct{}{}
+			ids = append(ids, msg.ID)
+			fromID = msg.ID
+			found = true
 		}
 
-		tConf.ChatID = chats.ChatIDs[0]
+		if !found {
+			return ids, nil
+		}
 	}
+}
 
-	chat, err := c.TDClient.GetChat(tConf.ChatID)
+func (c *Client) ReadHeader(_ context.Context) (string, error) {
+	pinnedID := c.pinnedMessageID()
+	if pinnedID == 0 {
+		return "", nil
+	}
+
+	msg, err := c.TDClient.GetMessage(c.chatID, pinnedID)
 	if err != nil {
-		return nil, fmt.Errorf("get chat: %w", err)
+		return "", fmt.Errorf("get pinned message: %w", retryError(err))
+	}
+
+	text, ok := msg.Content.(*tdlib.MessageText)
+	if !ok {
+		return "", fmt.Errorf("pinned message %d does not contain text", pinnedID)
 	}
 
-	return chat, nil
+	return text.Text.Text, nil
 }
 
-func (c *Client) GetOrInitPinnedMessage(ctx context.Context, chatID int64) (tdlib.Message, error) {
+func (c *Client) WriteHeader(ctx context.Context, text string) error {
+	pinnedID := c.pinnedMessageID()
+	if pinnedID == 0 {
+		return c.createPinnedMessage(ctx, text)
+	}
+
+	msgText := tdlib.NewInputMessageText(tdlib.NewFormattedText(text, nil), true, false)
+
+	_, err := c.TDClient.EditMessageText(c.chatID, pinnedID, nil, msgText)
+	if err != nil {
+		return fmt.Errorf("edit header message text: %w", retryError(err))
+	}
+
+	return nil
+}
+
+func (c *Client) MaxHeaderLength() int {
+	// Telegram counts message length in UTF-16 code units,
+	// which is never more than number of bytes.
+	return MaxHeaderLength
+}
+
+func (c *Client) Watch(onHeader func(text string), onState func(state string)) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+
+	if onHeader != nil {
+		c.headerWatchers = append(c.headerWatchers, onHeader)
+	}
+
+	if onState != nil {
+		c.stateWatchers = append(c.stateWatchers, onState)
+
+		if c.connectionState != "" {
+			onState(c.connectionState)
+		}
+	}
+}
+
+func (c *Client) findPinnedMessage(_ context.Context) (int64, error) {
 	msg, err := c.TDClient.SearchChatMessages(
 		c.chatID,
 		header, // Constant
@@ -48,115 +228,165 @@ func (c *Client) GetOrInitPinnedMessage(ctx context.Context, chatID int64) (tdli
 		0,
 	)
 	if err != nil {
-		return tdlib.Message{}, fmt.Errorf("search pinned message: %w", err)
+		return 0, fmt.Errorf("search pinned message: %w", err)
 	}
 
-	var pinnedMessage tdlib.Message
-	switch len(msg.Messages) {
-	case 0:
-		pinnedMessage, err = c.CreatePinnedMessage(ctx, chatID)
-		if err != nil {
-			return tdlib.Message{}, fmt.Errorf("create pinned message: %w", err)
-		}
-	default:
-		pinnedMessage = msg.Messages[0]
+	if len(msg.Messages) == 0 {
+		return 0, nil
 	}
 
-	return pinnedMessage, nil
+	return msg.Messages[0].ID, nil
 }
 
-func (c *Client) CreatePinnedMessage(ctx context.Context, chatID int64) (tdlib.Message, error) {
-	d, _ := manager.Marshal(c.PinnedHeader)
-	data := strings.TrimSpace(string(d))
-
-	m, err := c.SendMessage(chatID, 0, 0,
+func (c *Client) createPinnedMessage(ctx context.Context, text string) error {
+	m, err := c.SendMessage(ctx, c.chatID, 0, 0,
 		tdlib.NewMessageSendOptions(true, false, nil),
 		nil,
-		tdlib.NewInputMessageText(tdlib.NewFormattedText(data, nil), true, false))
+		tdlib.NewInputMessageText(tdlib.NewFormattedText(text, nil), true, false))
 	if err != nil {
-		return tdlib.Message{}, fmt.Errorf("send message: %w", err)
+		return fmt.Errorf("send message: %w", retryError(err))
 	}
 
-	m, err = c.TDClient.GetMessage(chatID, m.ID)
+	m, err = c.TDClient.GetMessage(c.chatID, m.ID)
 	if err != nil {
-		return tdlib.Message{}, fmt.Errorf("find new header message: %w", err)
+		return fmt.Errorf("find new header message: %w", err)
 	}
 
 	_, err = c.TDClient.PinChatMessage(m.ChatID, m.ID, true, false)
 	if err != nil {
-		return tdlib.Message{}, fmt.Errorf("pin message: %w", err)
+		return fmt.Errorf("pin message: %w", err)
 	}
 
-	return *m, nil
+	c.setPinnedMessageID(m.ID)
+
+	return nil
+}
+
+func (c *Client) pinnedMessageID() int64 {
+	c.pinnedMu.RLock()
+	defer c.pinnedMu.RUnlock()
+
+	return c.pinnedHeaderMessageID
+}
+
+func (c *Client) setPinnedMessageID(msgID int64) {
+	c.pinnedMu.Lock()
+	c.pinnedHeaderMessageID = msgID
+	c.pinnedMu.Unlock()
 }
 
-// SendHeader is used to update header in the Telegram chat.
-func (c *Client) SendHeader(ctx context.Context) error {
-	headerBytes, err := manager.Marshal(c.PinnedHeader)
+// downloadDocument downloads document attached to the message.
+//
+// It returns downloaded file and the caption of the message.
+func (c *Client) downloadDocument(ctx context.Context, msgID int64, progress func(int)) (*tdlib.File, string, error) {
+	if err := c.EnsureMessagesAreKnown(ctx, msgID); err != nil {
+		return nil, "", err
+	}
+
+	msg, err := c.TDClient.GetMessage(c.chatID, msgID)
 	if err != nil {
-		return fmt.Errorf("marshal header to yaml: %w", err)
+		return nil, "", err
 	}
 
-	msgText := tdlib.NewInputMessageText(tdlib.NewFormattedText(string(headerBytes), nil), true, false)
+	msgDoc, ok := msg.Content.(*tdlib.MessageDocument)
+	if !ok {
+		return nil, "", fmt.Errorf("message is not document: %v", msg.Content)
+	}
 
-	_, err = c.TDClient.EditMessageText(c.chatID, c.pinnedHeaderMessageID, nil, msgText)
+	fileID := msgDoc.Document.Document.ID
+
+	file, err := c.TDClient.GetFile(fileID)
 	if err != nil {
-		return fmt.Errorf("edit header message text: %w", err)
+		return nil, "", fmt.Errorf("get file: %w", err)
 	}
 
-	return nil
-}
+	if !file.Local.IsDownloadingCompleted {
+		if _, err := c.TDClient.CancelDownloadFile(fileID, false); err != nil {
+			return nil, "", err
+		}
 
-func (c *Client) EnsureMessagesAreKnown(ctx context.Context, ids ...int64) error {
-	for _, msgId := range ids {
-		_, err := c.TDClient.GetChatHistory(c.chatID, msgId, 0, 1, true)
-		if err != nil {
-			_, err = c.TDClient.GetChatHistory(c.chatID, msgId, 0, 1, false)
-			if err != nil {
-				return fmt.Errorf("ensure message online: %w", err)
+		watcher, stopWatch := c.watchDownload(fileID, progress)
+		defer stopWatch()
+
+		if _, err = c.TDClient.DownloadFile(fileID, 1, 0, 0, false); err != nil {
+			return nil, "", err
+		}
+
+		select {
+		case file = <-watcher:
+		case <-ctx.Done():
+			if _, err := c.TDClient.CancelDownloadFile(fileID, false); err != nil {
+				log.Printf("cancel download: %s\n", err.Error())
 			}
+
+			return nil, "", ctx.Err()
 		}
 	}
 
-	return nil
+	return file, msgDoc.Caption.Text, nil
 }
 
-func (c *Client) GetFileDataByMsgID(ctx context.Context, msgID int64) (manager.File, error) {
-	if err := c.EnsureMessagesAreKnown(ctx, msgID); err != nil {
-		return manager.File{}, fmt.Errorf("ensure message exists: %w", err)
-	}
-	msg, err := c.TDClient.GetMessage(c.chatID, msgID)
-	if err != nil {
-		return manager.File{}, fmt.Errorf("get message: %w", err)
-	}
+// watchDownload tracks download progress of the file till it is downloaded
+// or returned stop function is called.
+func (c *Client) watchDownload(fileID int32, progress func(int)) (<-chan *tdlib.File, func()) {
+	watcher := make(chan *tdlib.File, 1)
+	var fileUpdate tdlib.UpdateFile
 
-	doc, ok := msg.Content.(*tdlib.MessageDocument)
-	if !ok {
-		return manager.File{}, fmt.Errorf("fetched message %d does not contain document", msgID)
-	}
+	stop := c.addUpdateHandler(func(update tdlib.UpdateMsg) bool {
+		if update.Data["@type"] != string(tdlib.UpdateFileType) {
+			return false
+		}
 
-	var fileHeader manager.File
-	if err := manager.Unmarshal([]byte(doc.Caption.Text), &fileHeader); err != nil {
-		return manager.File{}, fmt.Errorf("unmarshal header: %w", err)
-	}
-	// TODO: decrypt data.
+		if err := json.Unmarshal(update.Raw, &fileUpdate); err != nil {
+			panic(fmt.Sprintf("failed to unmarshal update: %v", err))
+		}
+		if fileUpdate.File.ID != fileID {
+			return false
+		}
+
+		progress(int(100 * (float64(fileUpdate.File.Local.DownloadedSize) / float64(fileUpdate.File.ExpectedSize))))
 
-	return fileHeader, nil
+		if fileUpdate.File.Local.IsDownloadingCompleted {
+			watcher <- fileUpdate.File
+			close(watcher)
+			return true
+		}
+
+		return false
+	})
+
+	return watcher, stop
 }
 
-func (c *Client) DeleteFile(ctx context.Context, filePath string) error {
-	if _, ok := c.PinnedHeader.Files[filePath]; !ok {
-		return fmt.Errorf("file %s not found or is a directory", filePath)
-	}
+// watchUpload tracks upload progress of the matching file till it is uploaded
+// or returned stop function is called. Returned channel is closed on upload.
+func (c *Client) watchUpload(match func(file *tdlib.File) bool, progress func(int)) (<-chan struct{}, func()) {
+	uploaded := make(chan struct{})
+	var updateState tdlib.UpdateFile
 
-	subCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	stop := c.addUpdateHandler(func(update tdlib.UpdateMsg) bool {
+		if update.Data["@type"] != string(tdlib.UpdateFileType) {
+			return false
+		}
 
-	c.AddTask(WithCallback(NewDeleteFile(c, filePath), func(_ tasks.Task) {
-		cancel()
-	}))
+		if err := json.Unmarshal(update.Raw, &updateState); err != nil {
+			log.Printf("unmarshal file update: %s\n", err.Error())
+			return false
+		}
 
-	<-subCtx.Done()
+		if !match(updateState.File) {
+			return false
+		}
 
-	return nil
+		progress(int(100 * (float64(updateState.File.Remote.UploadedSize) / float64(updateState.File.ExpectedSize))))
+
+		if updateState.File.Remote.IsUploadingCompleted {
+			close(uploaded)
+			return true
+		}
+
+		return false
+	})
+
+	return uploaded, stop
 }
@@ -2,35 +2,64 @@ package arman92
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
+	"strings"
 
 	"github.com/Arman92/go-tdlib/v2/tdlib"
-
-	"github.com/ffenix113/teleporter/manager"
 )
 
 // ListenHeaderMessageUpdates is a handler that runs
 // till the application runs.
 //
-// It will update files when they will be coming.
+// It will pass new header text to the header watchers.
 func (c *Client) ListenHeaderMessageUpdates(update tdlib.UpdateMsg) bool {
 	if update.Data["@type"].(string) != string(tdlib.UpdateMessageContentType) ||
 		int64(update.Data["chat_id"].(float64)) != c.chatID ||
-		int64(update.Data["message_id"].(float64)) != c.pinnedHeaderMessageID {
+		int64(update.Data["message_id"].(float64)) != c.pinnedMessageID() {
 		return false
 	}
 
 	var upd tdlib.UpdateMessageContent
 	json.Unmarshal(update.Raw, &upd)
 
-	if err := manager.Unmarshal([]byte(upd.NewContent.(*tdlib.MessageText).Text.Text), &c.PinnedHeader); err != nil {
-		log.Println(fmt.Sprintf("unmarshal pinned message text: %s", err.Error()))
+	text, ok := upd.NewContent.(*tdlib.MessageText)
+	if !ok {
+		return false
+	}
+
+	c.watchMu.Lock()
+	watchers := append([]func(string){}, c.headerWatchers...)
+	c.watchMu.Unlock()
+
+	// Header document may need to be downloaded, which requires
+	// update handlers to be processed, so do not block them here.
+	for _, watcher := range watchers {
+		go watcher(text.Text.Text)
+	}
+
+	return false
+}
 
+// ListenConnectionStateUpdates is a handler that runs
+// till the application runs.
+//
+// It keeps connection state up to date and passes its changes
+// to the state watchers.
+func (c *Client) ListenConnectionStateUpdates(update tdlib.UpdateMsg) bool {
+	if update.Data["@type"].(string) != string(tdlib.UpdateConnectionStateType) {
 		return false
 	}
 
-	c.DownloadRemoteFiles()
+	var updateState tdlib.UpdateConnectionState
+	json.Unmarshal(update.Raw, &updateState)
+
+	connectionState := string(updateState.State.GetConnectionStateEnum())
+
+	c.watchMu.Lock()
+	c.connectionState = strings.TrimPrefix(connectionState, "connectionState")
+	for _, watcher := range c.stateWatchers {
+		watcher(c.connectionState)
+	}
+	c.watchMu.Unlock()
 
 	return false
 }
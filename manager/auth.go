@@ -0,0 +1,26 @@
+package manager
+
+import "context"
+
+// Authorization states of the backend.
+const (
+	// AuthPending means that backend is not ready to accept auth data yet.
+	AuthPending      = "Pending"
+	AuthWaitPhone    = "WaitPhone"
+	AuthWaitCode     = "WaitCode"
+	AuthWaitPassword = "WaitPassword"
+	AuthReady        = "Ready"
+)
+
+// Authorizer is implemented by backends that require user to log in
+// before they can be used.
+type Authorizer interface {
+	// AuthState returns the current authorization state.
+	AuthState() (string, error)
+	SendPhone(phone string) error
+	SendCode(code string) error
+	SendPassword(password string) error
+	// Start finishes initialization of the backend
+	// once authorization is complete.
+	Start(ctx context.Context) error
+}
@@ -0,0 +1,429 @@
+// Package botapi provides storage backend that uses Telegram Bot API.
+//
+// It talks to Bot API over HTTP, so it does not require tdlib and cgo.
+package botapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/manager"
+)
+
+// DefaultURL is the URL of the official Bot API server.
+const DefaultURL = "https://api.telegram.org"
+
+// DefaultPollInterval is how often the header is checked for changes by default.
+const DefaultPollInterval = 10 * time.Second
+
+// Connection states reported to the state watchers.
+const (
+	StateReady      = "Ready"
+	StateConnecting = "Connecting"
+)
+
+// ErrNotSupported is returned for requests that Bot API does not provide.
+var ErrNotSupported = errors.New("not supported by Bot API")
+
+// Error is an error returned by Bot API.
+type Error struct {
+	Code        int
+	Description string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("bot api error %d: %s", e.Code, e.Description)
+}
+
+// Client is a storage backend that keeps files
+// in the Telegram chat using Bot API.
+type Client struct {
+	url   string
+	token string
+	// chatID is the chat_id parameter of requests,
+	// which is either ID or @username of the chat.
+	chatID      string
+	downloadDir string
+	http        *http.Client
+
+	// mu guards fields below.
+	mu sync.Mutex
+	// messages holds known messages with documents, as Bot API
+	// does not allow to get message by ID. They are saved
+	// to messagesPath, if it is set, to be known after restart.
+	messages        map[int64]Message
+	messagesChanged bool
+	messagesPath    string
+	// pinnedID is the ID of the pinned header, zero if it is not written yet.
+	// headerText is the last known text of the header.
+	pinnedID   int64
+	headerText string
+
+	// headerMu makes sure that header is not polled while it is written,
+	// so older header will not be reported as a change.
+	headerMu sync.Mutex
+
+	watchMu        sync.Mutex
+	headerWatchers []func(text string)
+	stateWatchers  []func(state string)
+	state          string
+}
+
+var _ manager.Manager = (*Client)(nil)
+
+// NewClient returns a new client to access Telegram through Bot API.
+//
+// Header is checked for changes made by other clients till context is done.
+func NewClient(ctx context.Context, cnf config.Telegram) (*Client, error) {
+	if cnf.BotToken == "" {
+		return nil, errors.New("bot token is not set")
+	}
+
+	c := &Client{
+		url:         strings.TrimSuffix(cnf.BotAPIURL, "/"),
+		token:       cnf.BotToken,
+		chatID:      cnf.ChatName,
+		downloadDir: cnf.Config.FileDirectory,
+		http:        &http.Client{},
+		messages:    map[int64]Message{},
+	}
+
+	c.messagesPath = messagesPath(cnf.Config.DatabaseDirectory, c.chatID)
+
+	if c.url == "" {
+		c.url = DefaultURL
+	}
+
+	if cnf.ChatID != 0 {
+		c.chatID = strconv.FormatInt(cnf.ChatID, 10)
+	}
+
+	if c.chatID == "" {
+		return nil, errors.New("chat id or chat name is not set")
+	}
+
+	if c.downloadDir == "" {
+		c.downloadDir = os.TempDir()
+	}
+
+	if err := os.MkdirAll(c.downloadDir, 0755); err != nil {
+		return nil, fmt.Errorf("create download dir: %w", err)
+	}
+
+	if err := c.loadMessages(); err != nil {
+		return nil, err
+	}
+
+	if err := c.call(ctx, "getMe", nil, nil); err != nil {
+		return nil, fmt.Errorf("get bot info: %w", err)
+	}
+
+	chat, err := c.getChat(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get chat: %w", err)
+	}
+
+	c.setPinned(chat.PinnedMessage)
+	c.setState(StateReady)
+
+	pollInterval := cnf.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+
+	go c.pollHeader(ctx, pollInterval)
+	go c.pollUpdates(ctx, pollInterval)
+
+	return c, nil
+}
+
+// Message is a message returned by Bot API.
+type Message struct {
+	MessageID int64     `json:"message_id"`
+	Chat      *Chat     `json:"chat,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Caption   string    `json:"caption,omitempty"`
+	Document  *Document `json:"document,omitempty"`
+}
+
+type Document struct {
+	FileID   string `json:"file_id"`
+	FileSize int64  `json:"file_size,omitempty"`
+}
+
+type File struct {
+	FileID   string `json:"file_id"`
+	FileSize int64  `json:"file_size,omitempty"`
+	// FilePath is a path to download file from. Local Bot API server
+	// returns absolute path of the file on its file system instead.
+	FilePath string `json:"file_path,omitempty"`
+}
+
+type Chat struct {
+	ID            int64    `json:"id"`
+	Username      string   `json:"username,omitempty"`
+	PinnedMessage *Message `json:"pinned_message,omitempty"`
+}
+
+type response struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  *struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// call calls Bot API method with form encoded params
+// and decodes its result into result, if it is not nil.
+func (c *Client) call(ctx context.Context, method string, params url.Values, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.methodURL(method), strings.NewReader(params.Encode()))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.do(req, result)
+}
+
+// callWithFile calls Bot API method with params and the local file
+// in the field, reporting upload progress of the file.
+func (c *Client) callWithFile(ctx context.Context, method string, params map[string]string, field, localPath string, progress func(int), result interface{}) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+
+	// File is streamed into the request, so it is never fully loaded into memory.
+	bodyReader, bodyWriter := io.Pipe()
+	form := multipart.NewWriter(bodyWriter)
+
+	go func() {
+		for name, value := range params {
+			if err := form.WriteField(name, value); err != nil {
+				bodyWriter.CloseWithError(err)
+				return
+			}
+		}
+
+		part, err := form.CreateFormFile(field, filepath.Base(localPath))
+		if err != nil {
+			bodyWriter.CloseWithError(err)
+			return
+		}
+
+		if _, err := io.Copy(part, &progressReader{Reader: file, size: stat.Size(), progress: progress}); err != nil {
+			bodyWriter.CloseWithError(err)
+			return
+		}
+
+		bodyWriter.CloseWithError(form.Close())
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.methodURL(method), bodyReader)
+	if err != nil {
+		bodyReader.Close()
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	err = c.do(req, result)
+	// Make sure that writer is stopped if request failed before reading the body.
+	bodyReader.Close()
+
+	return err
+}
+
+func (c *Client) do(req *http.Request, result interface{}) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return c.redact(err)
+	}
+	defer resp.Body.Close()
+
+	var apiResp response
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		// Proxies in front of Bot API answer with HTML when it is unavailable.
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("bot api is unavailable: %s", resp.Status)
+		}
+
+		return fmt.Errorf("decode response: %s: %w", resp.Status, err)
+	}
+
+	if !apiResp.OK {
+		apiErr := &Error{Code: apiResp.ErrorCode, Description: apiResp.Description}
+		if apiResp.Parameters != nil && apiResp.Parameters.RetryAfter > 0 {
+			return &manager.RetryAfterError{After: time.Duration(apiResp.Parameters.RetryAfter) * time.Second, Err: apiErr}
+		}
+
+		switch apiErr.Code {
+		case http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound:
+			return &manager.PermanentError{Err: apiErr}
+		}
+
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(apiResp.Result, result); err != nil {
+		return fmt.Errorf("decode result: %w", err)
+	}
+
+	return nil
+}
+
+// redact removes the token from the URL of failed request,
+// so it does not get into logs.
+func (c *Client) redact(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = strings.ReplaceAll(urlErr.URL, c.token, "<token>")
+	}
+
+	return err
+}
+
+func (c *Client) methodURL(method string) string {
+	return c.url + "/bot" + c.token + "/" + method
+}
+
+func (c *Client) fileURL(filePath string) string {
+	return c.url + "/file/bot" + c.token + "/" + filePath
+}
+
+func (c *Client) getChat(ctx context.Context) (Chat, error) {
+	var chat Chat
+	err := c.call(ctx, "getChat", url.Values{"chat_id": {c.chatID}}, &chat)
+
+	return chat, err
+}
+
+// pollHeader checks pinned header for changes made by other clients,
+// as bots do not receive updates about their own chats messages.
+// Known messages are saved on each check.
+func (c *Client) pollHeader(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := c.saveMessages(); err != nil {
+				log.Printf("save known messages: %s\n", err.Error())
+			}
+
+			return
+		case <-ticker.C:
+		}
+
+		if err := c.saveMessages(); err != nil {
+			log.Printf("save known messages: %s\n", err.Error())
+		}
+
+		c.headerMu.Lock()
+		chat, err := c.getChat(ctx)
+		var text string
+		var changed bool
+		if err == nil {
+			text, changed = c.setPinned(chat.PinnedMessage)
+		}
+		c.headerMu.Unlock()
+
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("poll header: %s\n", err.Error())
+				c.setState(StateConnecting)
+			}
+
+			continue
+		}
+
+		c.setState(StateReady)
+
+		if changed {
+			c.watchMu.Lock()
+			watchers := append([]func(string){}, c.headerWatchers...)
+			c.watchMu.Unlock()
+
+			for _, watcher := range watchers {
+				watcher(text)
+			}
+		}
+	}
+}
+
+// setPinned remembers pinned header message.
+// It reports whether header text was changed.
+func (c *Client) setPinned(msg *Message) (string, bool) {
+	if msg == nil || !strings.Contains(msg.Text, header) {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := c.headerText != msg.Text
+	c.pinnedID = msg.MessageID
+	c.headerText = msg.Text
+
+	return msg.Text, changed
+}
+
+func (c *Client) setState(state string) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+
+	if c.state == state {
+		return
+	}
+
+	c.state = state
+	for _, watcher := range c.stateWatchers {
+		watcher(state)
+	}
+}
+
+type progressReader struct {
+	io.Reader
+	size     int64
+	read     int64
+	progress func(int)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+
+	if r.size > 0 {
+		r.progress(int(100 * r.read / r.size))
+	} else if err == io.EOF {
+		r.progress(100)
+	}
+
+	return n, err
+}
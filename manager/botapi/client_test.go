@@ -0,0 +1,625 @@
+package botapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/manager"
+)
+
+const testToken = "123456:secret-token"
+
+// standIn is a local stand-in for the Bot API server.
+type standIn struct {
+	mu       sync.Mutex
+	nextID   int64
+	messages map[int64]*Message
+	// files holds content of documents by file ID.
+	files    map[string][]byte
+	pinnedID int64
+	// localDir is set to simulate local Bot API server,
+	// which returns absolute paths of files.
+	localDir string
+	// rateLimited methods fail with retry after the number of seconds.
+	rateLimited map[string]int
+	calls       map[string]int
+	// updates are returned by getUpdates.
+	updates []Update
+}
+
+func newStandIn(t *testing.T) (*standIn, *httptest.Server) {
+	s := &standIn{
+		messages:    map[int64]*Message{},
+		files:       map[string][]byte{},
+		rateLimited: map[string]int{},
+		calls:       map[string]int{},
+	}
+
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	return s, server
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Updates are long polled, so other requests must not wait for them.
+	if r.URL.Path == "/bot"+testToken+"/getUpdates" {
+		s.getUpdates(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if filePath := strings.TrimPrefix(r.URL.Path, "/file/bot"+testToken+"/"); filePath != r.URL.Path {
+		content, ok := s.files[strings.TrimPrefix(filePath, "documents/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write(content)
+		return
+	}
+
+	method := strings.TrimPrefix(r.URL.Path, "/bot"+testToken+"/")
+	if method == r.URL.Path {
+		s.fail(w, 401, "Unauthorized")
+		return
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		r.ParseMultipartForm(1 << 20)
+	} else {
+		r.ParseForm()
+	}
+
+	s.calls[method]++
+	if retryAfter, ok := s.rateLimited[method]; ok {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":          false,
+			"error_code":  429,
+			"description": "Too Many Requests: retry after " + strconv.Itoa(retryAfter),
+			"parameters":  map[string]int{"retry_after": retryAfter},
+		})
+		return
+	}
+
+	msgID, _ := strconv.ParseInt(r.FormValue("message_id"), 10, 64)
+
+	switch method {
+	case "getMe":
+		s.ok(w, map[string]interface{}{"id": 1, "is_bot": true})
+	case "getChat":
+		chat := Chat{ID: -100}
+		if msg, ok := s.messages[s.pinnedID]; ok {
+			chat.PinnedMessage = msg
+		}
+		s.ok(w, chat)
+	case "sendMessage":
+		s.ok(w, s.add(&Message{Text: r.FormValue("text")}))
+	case "pinChatMessage":
+		s.pinnedID = msgID
+		s.ok(w, true)
+	case "editMessageText":
+		msg, ok := s.messages[msgID]
+		switch {
+		case !ok:
+			s.fail(w, 400, "Bad Request: message to edit not found")
+		case msg.Text == r.FormValue("text"):
+			s.fail(w, 400, "Bad Request: message is not modified")
+		default:
+			msg.Text = r.FormValue("text")
+			s.ok(w, msg)
+		}
+	case "sendDocument":
+		msg := s.add(&Message{Caption: r.FormValue("caption")})
+		msg.Document = s.addFile(r, msg.MessageID)
+		s.ok(w, msg)
+	case "editMessageMedia":
+		var media struct {
+			Caption string `json:"caption"`
+		}
+		json.Unmarshal([]byte(r.FormValue("media")), &media)
+
+		msg, ok := s.messages[msgID]
+		if !ok {
+			s.fail(w, 400, "Bad Request: message to edit not found")
+			return
+		}
+
+		msg.Caption = media.Caption
+		msg.Document = s.addFile(r, msgID)
+		s.ok(w, msg)
+	case "editMessageCaption":
+		msg, ok := s.messages[msgID]
+		if !ok {
+			s.fail(w, 400, "Bad Request: message to edit not found")
+			return
+		}
+
+		msg.Caption = r.FormValue("caption")
+		s.ok(w, msg)
+	case "forwardMessage":
+		msg, ok := s.messages[msgID]
+		if !ok {
+			s.fail(w, 400, "Bad Request: message to forward not found")
+			return
+		}
+
+		s.ok(w, s.add(&Message{Text: msg.Text, Caption: msg.Caption, Document: msg.Document}))
+	case "deleteMessage":
+		delete(s.messages, msgID)
+		s.ok(w, true)
+	case "deleteMessages":
+		var ids []int64
+		json.Unmarshal([]byte(r.FormValue("message_ids")), &ids)
+		for _, id := range ids {
+			delete(s.messages, id)
+		}
+		s.ok(w, true)
+	case "getFile":
+		fileID := r.FormValue("file_id")
+		content, ok := s.files[fileID]
+		if !ok {
+			s.fail(w, 400, "Bad Request: invalid file_id")
+			return
+		}
+
+		file := File{FileID: fileID, FileSize: int64(len(content)), FilePath: "documents/" + fileID}
+		if s.localDir != "" {
+			file.FilePath = filepath.Join(s.localDir, fileID)
+			os.WriteFile(file.FilePath, content, 0644)
+		}
+		s.ok(w, file)
+	default:
+		s.fail(w, 404, "Not Found: method not found")
+	}
+}
+
+func (s *standIn) getUpdates(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.ParseInt(r.FormValue("offset"), 10, 64)
+
+	s.mu.Lock()
+	var updates []Update
+	for _, update := range s.updates {
+		if update.UpdateID >= offset {
+			updates = append(updates, update)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(updates) == 0 {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	s.ok(w, updates)
+}
+
+// post adds document sent by other user to the chat and to the updates.
+func (s *standIn) post(caption, content string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := s.add(&Message{Caption: caption})
+	fileID := "posted" + strconv.FormatInt(msg.MessageID, 10)
+	s.files[fileID] = []byte(content)
+	msg.Document = &Document{FileID: fileID, FileSize: int64(len(content))}
+
+	s.update(msg, false)
+
+	return msg.MessageID
+}
+
+// edit changes caption of the message and adds the edit to the updates.
+func (s *standIn) edit(msgID int64, caption string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := s.messages[msgID]
+	msg.Caption = caption
+
+	s.update(&Message{MessageID: msgID, Caption: caption, Document: msg.Document}, true)
+}
+
+func (s *standIn) update(msg *Message, edited bool) {
+	post := *msg
+	post.Chat = &Chat{ID: -100}
+
+	update := Update{UpdateID: int64(len(s.updates)) + 1}
+	if edited {
+		update.EditedChannelPost = &post
+	} else {
+		update.ChannelPost = &post
+	}
+
+	s.updates = append(s.updates, update)
+}
+
+func (s *standIn) callsOf(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[method]
+}
+
+func (s *standIn) add(msg *Message) *Message {
+	s.nextID++
+	msg.MessageID = s.nextID
+	s.messages[msg.MessageID] = msg
+
+	return msg
+}
+
+func (s *standIn) addFile(r *http.Request, msgID int64) *Document {
+	file, _, err := r.FormFile("document")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	content, _ := io.ReadAll(file)
+	fileID := "file" + strconv.FormatInt(msgID, 10) + "_" + strconv.Itoa(len(s.files))
+	s.files[fileID] = content
+
+	return &Document{FileID: fileID, FileSize: int64(len(content))}
+}
+
+func (s *standIn) ok(w http.ResponseWriter, result interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func (s *standIn) fail(w http.ResponseWriter, code int, description string) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": code, "description": description})
+}
+
+func (s *standIn) messagesCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.messages)
+}
+
+func newTestClient(t *testing.T, serverURL string) *Client {
+	t.Helper()
+
+	return startTestClient(t, testConfig(t, serverURL))
+}
+
+func testConfig(t *testing.T, serverURL string) config.Telegram {
+	return config.Telegram{
+		ChatID:       -100,
+		BotToken:     testToken,
+		BotAPIURL:    serverURL,
+		PollInterval: 20 * time.Millisecond,
+		Config:       config.TDLib{FileDirectory: t.TempDir()},
+	}
+}
+
+func startTestClient(t *testing.T, cnf config.Telegram) *Client {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cl, err := NewClient(ctx, cnf)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	return cl
+}
+
+func writeTemp(t *testing.T, content string) string {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	return filePath
+}
+
+func readDownloaded(t *testing.T, filePath string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("read downloaded file: %v", err)
+	}
+	os.Remove(filePath)
+
+	return string(content)
+}
+
+func TestPutAndGet(t *testing.T) {
+	_, server := newStandIn(t)
+	cl := newTestClient(t, server.URL)
+
+	var uploaded int
+	msgID, err := cl.Put(context.Background(), writeTemp(t, "content"), "caption", func(progress int) {
+		uploaded = progress
+	})
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if uploaded != 100 {
+		t.Fatalf("upload progress must reach 100, got: %d", uploaded)
+	}
+
+	var downloaded int
+	filePath, caption, err := cl.Get(context.Background(), msgID, func(progress int) {
+		downloaded = progress
+	})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	if content := readDownloaded(t, filePath); content != "content" || caption != "caption" {
+		t.Fatalf("unexpected document: %q with caption %q", content, caption)
+	}
+
+	if downloaded != 100 {
+		t.Fatalf("download progress must reach 100, got: %d", downloaded)
+	}
+}
+
+func TestReplaceAndSetCaption(t *testing.T) {
+	standIn, server := newStandIn(t)
+	cl := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	msgID, err := cl.Put(ctx, writeTemp(t, "old"), "old caption", func(int) {})
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if err := cl.Replace(ctx, msgID, writeTemp(t, "new"), "new caption", func(int) {}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+
+	if err := cl.SetCaption(ctx, msgID, "renamed"); err != nil {
+		t.Fatalf("set caption: %v", err)
+	}
+
+	// Other client does not know the message, so it has to forward it.
+	other := newTestClient(t, server.URL)
+	caption, err := other.Caption(ctx, msgID)
+	if err != nil {
+		t.Fatalf("caption: %v", err)
+	}
+
+	if caption != "renamed" {
+		t.Fatalf("unexpected caption: %q", caption)
+	}
+
+	filePath, _, err := other.Get(ctx, msgID, func(int) {})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	if content := readDownloaded(t, filePath); content != "new" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+
+	if count := standIn.messagesCount(); count != 1 {
+		t.Fatalf("forwarded copy must be deleted: want: 1 message, got: %d", count)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	standIn, server := newStandIn(t)
+	cl := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	var msgIDs []int64
+	for i := 0; i < 3; i++ {
+		msgID, err := cl.Put(ctx, writeTemp(t, "content"), "", func(int) {})
+		if err != nil {
+			t.Fatalf("put: %v", err)
+		}
+
+		msgIDs = append(msgIDs, msgID)
+	}
+
+	if err := cl.Delete(ctx, msgIDs...); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	if count := standIn.messagesCount(); count != 0 {
+		t.Fatalf("messages must be deleted, got: %d", count)
+	}
+
+	if _, err := cl.Caption(ctx, msgIDs[0]); err == nil {
+		t.Fatal("deleted message must not be found")
+	}
+}
+
+func TestHeaderIsWrittenAndWatched(t *testing.T) {
+	_, server := newStandIn(t)
+	first := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	if text, err := first.ReadHeader(ctx); err != nil || text != "" {
+		t.Fatalf("header must not exist yet, got: %q, %v", text, err)
+	}
+
+	initial := `{"Header": "Teleporter"}`
+	if err := first.WriteHeader(ctx, initial); err != nil {
+		t.Fatalf("create header: %v", err)
+	}
+
+	// Not modified header is not an error.
+	if err := first.WriteHeader(ctx, initial); err != nil {
+		t.Fatalf("write same header: %v", err)
+	}
+
+	changes := make(chan string, 1)
+	first.Watch(func(text string) {
+		changes <- text
+	}, nil)
+
+	second := newTestClient(t, server.URL)
+	if text, err := second.ReadHeader(ctx); err != nil || text != initial {
+		t.Fatalf("header must be read by other client, got: %q, %v", text, err)
+	}
+
+	changed := `{"Header": "Teleporter", "Files": {"a": 1}}`
+	if err := second.WriteHeader(ctx, changed); err != nil {
+		t.Fatalf("edit header: %v", err)
+	}
+
+	select {
+	case text := <-changes:
+		if text != changed {
+			t.Fatalf("unexpected header change: %q", text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("header change was not noticed")
+	}
+}
+
+func TestRateLimitIsRetryAfterError(t *testing.T) {
+	standIn, server := newStandIn(t)
+	cl := newTestClient(t, server.URL)
+
+	standIn.mu.Lock()
+	standIn.rateLimited["sendDocument"] = 7
+	standIn.mu.Unlock()
+
+	_, err := cl.Put(context.Background(), writeTemp(t, "content"), "", func(int) {})
+	if after, ok := manager.RetryAfter(err); !ok || after != 7*time.Second {
+		t.Fatalf("want retry after 7s, got: %v, %v", after, err)
+	}
+}
+
+func TestPostedDocumentsAreReadFromUpdates(t *testing.T) {
+	standIn, server := newStandIn(t)
+	cl := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	msgID := standIn.post("posted", "content")
+	waitCaption(t, cl, msgID, "posted")
+
+	standIn.edit(msgID, "edited")
+	waitCaption(t, cl, msgID, "edited")
+
+	if calls := standIn.callsOf("forwardMessage"); calls != 0 {
+		t.Fatalf("documents from updates must not be forwarded, got: %d forwards", calls)
+	}
+
+	filePath, _, err := cl.Get(ctx, msgID, func(int) {})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	if content := readDownloaded(t, filePath); content != "content" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+}
+
+// waitCaption waits till client knows the message with the caption.
+func waitCaption(t *testing.T, cl *Client, msgID int64, caption string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cl.mu.Lock()
+		msg, ok := cl.messages[msgID]
+		cl.mu.Unlock()
+
+		if ok && msg.Caption == caption {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("message %d with caption %q was not received, got: %+v", msgID, caption, msg)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKnownMessagesAreKeptBetweenRuns(t *testing.T) {
+	standIn, server := newStandIn(t)
+	cnf := testConfig(t, server.URL)
+	cnf.Config.DatabaseDirectory = t.TempDir()
+
+	first := startTestClient(t, cnf)
+	msgID, err := first.Put(context.Background(), writeTemp(t, "content"), "caption", func(int) {})
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	// Messages are saved with the next header check.
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := os.Stat(first.messagesPath); err != nil; _, err = os.Stat(first.messagesPath) {
+		if time.Now().After(deadline) {
+			t.Fatalf("known messages were not saved: %v", err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	caption, err := startTestClient(t, cnf).Caption(context.Background(), msgID)
+	if err != nil || caption != "caption" {
+		t.Fatalf("caption: want: %q, got: %q, %v", "caption", caption, err)
+	}
+
+	if calls := standIn.callsOf("forwardMessage"); calls != 0 {
+		t.Fatalf("known message must not be forwarded, got: %d forwards", calls)
+	}
+}
+
+func TestLocalServerFilesAreCopied(t *testing.T) {
+	standIn, server := newStandIn(t)
+	standIn.localDir = t.TempDir()
+	cl := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	msgID, err := cl.Put(ctx, writeTemp(t, "large"), "", func(int) {})
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	filePath, _, err := cl.Get(ctx, msgID, func(int) {})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	if strings.HasPrefix(filePath, standIn.localDir) {
+		t.Fatal("file of local server must be copied")
+	}
+
+	if content := readDownloaded(t, filePath); content != "large" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+}
+
+func TestTokenIsNotInErrors(t *testing.T) {
+	_, server := newStandIn(t)
+	cl := newTestClient(t, server.URL)
+	server.Close()
+
+	_, err := cl.Caption(context.Background(), 1)
+	if err == nil {
+		t.Fatal("request to closed server must fail")
+	}
+
+	if strings.Contains(err.Error(), testToken) {
+		t.Fatalf("error contains the token: %v", err)
+	}
+}
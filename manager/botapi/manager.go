@@ -0,0 +1,280 @@
+package botapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const header = `"Header": "Teleporter"`
+
+// MaxHeaderLength is the max length of the text message.
+// Header that is longer will be sent as a document.
+const MaxHeaderLength = 4096
+
+// deleteBatchSize is the max number of messages deleted with one request.
+const deleteBatchSize = 100
+
+func (c *Client) Put(ctx context.Context, localPath, caption string, progress func(int)) (int64, error) {
+	var msg Message
+	err := c.callWithFile(ctx, "sendDocument", map[string]string{
+		"chat_id":                        c.chatID,
+		"caption":                        caption,
+		"disable_notification":           "true",
+		"disable_content_type_detection": "true",
+	}, "document", localPath, progress, &msg)
+	if err != nil {
+		return 0, fmt.Errorf("send document: %w", err)
+	}
+
+	c.remember(msg)
+
+	return msg.MessageID, nil
+}
+
+func (c *Client) Replace(ctx context.Context, msgID int64, localPath, caption string, progress func(int)) error {
+	media, err := json.Marshal(map[string]interface{}{
+		"type":                           "document",
+		"media":                          "attach://document",
+		"caption":                        caption,
+		"disable_content_type_detection": true,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal media: %w", err)
+	}
+
+	var msg Message
+	err = c.callWithFile(ctx, "editMessageMedia", map[string]string{
+		"chat_id":    c.chatID,
+		"message_id": strconv.FormatInt(msgID, 10),
+		"media":      string(media),
+	}, "document", localPath, progress, &msg)
+	if err != nil {
+		return fmt.Errorf("edit message media: %w", err)
+	}
+
+	c.remember(msg)
+
+	return nil
+}
+
+func (c *Client) SetCaption(ctx context.Context, msgID int64, caption string) error {
+	var msg Message
+	err := c.call(ctx, "editMessageCaption", url.Values{
+		"chat_id":    {c.chatID},
+		"message_id": {strconv.FormatInt(msgID, 10)},
+		"caption":    {caption},
+	}, &msg)
+	if err != nil {
+		return fmt.Errorf("edit message caption: %w", err)
+	}
+
+	c.remember(msg)
+
+	return nil
+}
+
+func (c *Client) Get(ctx context.Context, msgID int64, progress func(int)) (string, string, error) {
+	msg, err := c.message(ctx, msgID)
+	if err != nil {
+		return "", "", err
+	}
+
+	var file File
+	if err := c.call(ctx, "getFile", url.Values{"file_id": {msg.Document.FileID}}, &file); err != nil {
+		return "", "", fmt.Errorf("get file: %w", err)
+	}
+
+	filePath, err := c.download(ctx, file, progress)
+	if err != nil {
+		return "", "", fmt.Errorf("download file: %w", err)
+	}
+
+	return filePath, msg.Caption, nil
+}
+
+func (c *Client) Caption(ctx context.Context, msgID int64) (string, error) {
+	msg, err := c.message(ctx, msgID)
+	if err != nil {
+		return "", err
+	}
+
+	return msg.Caption, nil
+}
+
+func (c *Client) Delete(ctx context.Context, msgIDs ...int64) error {
+	for start := 0; start < len(msgIDs); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(msgIDs) {
+			end = len(msgIDs)
+		}
+
+		ids, err := json.Marshal(msgIDs[start:end])
+		if err != nil {
+			return fmt.Errorf("marshal message ids: %w", err)
+		}
+
+		err = c.call(ctx, "deleteMessages", url.Values{
+			"chat_id":     {c.chatID},
+			"message_ids": {string(ids)},
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("delete messages: %w", err)
+		}
+	}
+
+	c.forget(msgIDs...)
+
+	return nil
+}
+
+// List is not supported, as bots are not allowed to read chat history.
+func (c *Client) List(_ context.Context) ([]int64, error) {
+	return nil, fmt.Errorf("list messages: %w", ErrNotSupported)
+}
+
+func (c *Client) ReadHeader(ctx context.Context) (string, error) {
+	chat, err := c.getChat(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get chat: %w", err)
+	}
+
+	c.setPinned(chat.PinnedMessage)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.headerText, nil
+}
+
+func (c *Client) WriteHeader(ctx context.Context, text string) error {
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	c.mu.Lock()
+	pinnedID := c.pinnedID
+	c.mu.Unlock()
+
+	if pinnedID == 0 {
+		return c.createPinnedMessage(ctx, text)
+	}
+
+	err := c.call(ctx, "editMessageText", url.Values{
+		"chat_id":    {c.chatID},
+		"message_id": {strconv.FormatInt(pinnedID, 10)},
+		"text":       {text},
+	}, nil)
+	// Bot API fails when text is the same, which is fine for the header.
+	if err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		return fmt.Errorf("edit header message text: %w", err)
+	}
+
+	c.mu.Lock()
+	c.headerText = text
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *Client) MaxHeaderLength() int {
+	return MaxHeaderLength
+}
+
+func (c *Client) Watch(onHeader func(text string), onState func(state string)) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+
+	if onHeader != nil {
+		c.headerWatchers = append(c.headerWatchers, onHeader)
+	}
+
+	if onState != nil {
+		c.stateWatchers = append(c.stateWatchers, onState)
+
+		if c.state != "" {
+			onState(c.state)
+		}
+	}
+}
+
+func (c *Client) createPinnedMessage(ctx context.Context, text string) error {
+	var msg Message
+	err := c.call(ctx, "sendMessage", url.Values{
+		"chat_id":              {c.chatID},
+		"text":                 {text},
+		"disable_notification": {"true"},
+	}, &msg)
+	if err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+
+	err = c.call(ctx, "pinChatMessage", url.Values{
+		"chat_id":              {c.chatID},
+		"message_id":           {strconv.FormatInt(msg.MessageID, 10)},
+		"disable_notification": {"true"},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("pin message: %w", err)
+	}
+
+	c.mu.Lock()
+	c.pinnedID = msg.MessageID
+	c.headerText = text
+	c.mu.Unlock()
+
+	return nil
+}
+
+// download downloads the file into the download dir.
+//
+// Local Bot API server stores files on its file system,
+// so they are copied from there.
+func (c *Client) download(ctx context.Context, file File, progress func(int)) (string, error) {
+	var src io.ReadCloser
+	if filepath.IsAbs(file.FilePath) {
+		localFile, err := os.Open(file.FilePath)
+		if err != nil {
+			return "", fmt.Errorf("open local file: %w", err)
+		}
+
+		src = localFile
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.fileURL(file.FilePath), nil)
+		if err != nil {
+			return "", fmt.Errorf("create request: %w", err)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return "", c.redact(err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+
+		src = resp.Body
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(c.downloadDir, "botapi_*")
+	if err != nil {
+		return "", fmt.Errorf("create temp file: %w", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, &progressReader{Reader: src, size: file.FileSize, progress: progress}); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+
+	return dst.Name(), nil
+}
@@ -0,0 +1,247 @@
+package botapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ffenix113/teleporter/manager"
+)
+
+// updatesTimeout is how long getUpdates waits for new updates.
+const updatesTimeout = 30 * time.Second
+
+// Update is an update received from Bot API.
+type Update struct {
+	UpdateID          int64    `json:"update_id"`
+	Message           *Message `json:"message,omitempty"`
+	EditedMessage     *Message `json:"edited_message,omitempty"`
+	ChannelPost       *Message `json:"channel_post,omitempty"`
+	EditedChannelPost *Message `json:"edited_channel_post,omitempty"`
+}
+
+func (u Update) message() *Message {
+	for _, msg := range []*Message{u.Message, u.EditedMessage, u.ChannelPost, u.EditedChannelPost} {
+		if msg != nil {
+			return msg
+		}
+	}
+
+	return nil
+}
+
+// remember adds message with document to the known messages.
+func (c *Client) remember(msg Message) {
+	if msg.Document == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.messages[msg.MessageID] = Message{MessageID: msg.MessageID, Caption: msg.Caption, Document: msg.Document}
+	c.messagesChanged = true
+	c.mu.Unlock()
+}
+
+// forget removes messages from the known messages.
+func (c *Client) forget(msgIDs ...int64) {
+	c.mu.Lock()
+	for _, msgID := range msgIDs {
+		delete(c.messages, msgID)
+	}
+	c.messagesChanged = true
+	c.mu.Unlock()
+}
+
+// message returns message with document.
+//
+// Bot API does not allow to get message by ID, so unknown message
+// is forwarded to the same chat to be read, and the copy is deleted.
+// Messages are remembered when they are sent, read or received
+// in updates, so each message is forwarded at most once.
+func (c *Client) message(ctx context.Context, msgID int64) (Message, error) {
+	c.mu.Lock()
+	msg, ok := c.messages[msgID]
+	c.mu.Unlock()
+
+	if ok {
+		return msg, nil
+	}
+
+	var forwarded Message
+	for {
+		err := c.call(ctx, "forwardMessage", url.Values{
+			"chat_id":              {c.chatID},
+			"from_chat_id":         {c.chatID},
+			"message_id":           {strconv.FormatInt(msgID, 10)},
+			"disable_notification": {"true"},
+		}, &forwarded)
+		if err == nil {
+			break
+		}
+
+		// Messages are read in bulk on start, which quickly hits rate limits.
+		after, ok := manager.RetryAfter(err)
+		if !ok {
+			return Message{}, fmt.Errorf("read message %d: %w", msgID, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return Message{}, fmt.Errorf("read message %d: %w", msgID, ctx.Err())
+		case <-time.After(after):
+		}
+	}
+
+	err := c.call(ctx, "deleteMessage", url.Values{
+		"chat_id":    {c.chatID},
+		"message_id": {strconv.FormatInt(forwarded.MessageID, 10)},
+	}, nil)
+	if err != nil {
+		log.Printf("delete forwarded copy of message %d: %s\n", msgID, err.Error())
+	}
+
+	if forwarded.Document == nil {
+		return Message{}, &manager.PermanentError{Err: fmt.Errorf("message %d does not contain document", msgID)}
+	}
+
+	msg = Message{MessageID: msgID, Caption: forwarded.Caption, Document: forwarded.Document}
+	c.remember(msg)
+
+	return msg, nil
+}
+
+// pollUpdates remembers documents that are sent or edited in the chat
+// by other users, so they do not need to be forwarded to be read.
+//
+// Bot API does not send updates about messages of the bot itself,
+// those are remembered when they are sent.
+func (c *Client) pollUpdates(ctx context.Context, interval time.Duration) {
+	allowed, _ := json.Marshal([]string{"message", "edited_message", "channel_post", "edited_channel_post"})
+
+	var offset int64
+	for ctx.Err() == nil {
+		var updates []Update
+		err := c.call(ctx, "getUpdates", url.Values{
+			"offset":          {strconv.FormatInt(offset, 10)},
+			"timeout":         {strconv.Itoa(int(updatesTimeout.Seconds()))},
+			"allowed_updates": {string(allowed)},
+		}, &updates)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Updates are not available if bot uses webhook
+			// or they are received by other process.
+			var apiErr *Error
+			if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+				log.Printf("stop receiving updates: %s\n", err.Error())
+				return
+			}
+
+			wait := interval
+			if after, ok := manager.RetryAfter(err); ok {
+				wait = after
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+
+			continue
+		}
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+
+			if msg := update.message(); msg != nil && c.isChat(msg.Chat) {
+				c.remember(*msg)
+			}
+		}
+	}
+}
+
+// isChat reports whether chat is the chat of the client.
+func (c *Client) isChat(chat *Chat) bool {
+	return chat != nil && (strconv.FormatInt(chat.ID, 10) == c.chatID ||
+		chat.Username != "" && "@"+chat.Username == c.chatID)
+}
+
+// messagesPath returns path of the file where known messages
+// are kept between runs, or empty string if they are not kept.
+func messagesPath(databaseDir, chatID string) string {
+	if databaseDir == "" {
+		return ""
+	}
+
+	return filepath.Join(databaseDir, "botapi_messages_"+strings.TrimPrefix(chatID, "@")+".json")
+}
+
+// loadMessages reads messages that were known in the previous run.
+func (c *Client) loadMessages() error {
+	if c.messagesPath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.messagesPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("read known messages: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &c.messages); err != nil {
+		return fmt.Errorf("decode known messages: %w", err)
+	}
+
+	return nil
+}
+
+// saveMessages writes known messages if they were changed since last save.
+func (c *Client) saveMessages() error {
+	if c.messagesPath == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	if !c.messagesChanged {
+		c.mu.Unlock()
+		return nil
+	}
+
+	data, err := json.Marshal(c.messages)
+	c.messagesChanged = false
+	c.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("encode known messages: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.messagesPath), 0755); err != nil {
+		return fmt.Errorf("create messages dir: %w", err)
+	}
+
+	// File is replaced at once, so it is never read partially written.
+	tmpPath := c.messagesPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("write known messages: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, c.messagesPath); err != nil {
+		return fmt.Errorf("replace known messages: %w", err)
+	}
+
+	return nil
+}
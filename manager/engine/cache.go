@@ -0,0 +1,304 @@
+package engine
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ffenix113/teleporter/config"
+)
+
+// DefaultCacheSize is the max size of files downloaded on demand.
+const DefaultCacheSize = 1024 * 1024 * 1024 // 1 GB
+
+// Cache keeps files downloaded on demand, removing least recently
+// used ones when total size of the files is larger than max size.
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	// mu guards fields below.
+	mu   sync.Mutex
+	size int64
+	// entries are ordered from least to most recently used.
+	entries *list.List
+	byKey   map[string]*list.Element
+	// loading holds files that are being fetched, so each is fetched once.
+	loading map[string]*cacheLoad
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// cacheLoad is a fetch of the file shared by all callers that wait for it.
+type cacheLoad struct {
+	done chan struct{}
+	err  error
+	// waiters is the number of callers waiting for the fetch, guarded by Cache.mu.
+	// Fetch is cancelled when all of them stop waiting.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// NewCache creates cache in the directory,
+// keeping files that are already in it.
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read cache dir: %w", err)
+	}
+
+	c := &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: list.New(),
+		byKey:   map[string]*list.Element{},
+		loading: map[string]*cacheLoad{},
+	}
+
+	infos := make([]os.FileInfo, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		// Partially fetched files are left if process was killed.
+		if strings.HasSuffix(dirEntry.Name(), ".part") {
+			os.Remove(filepath.Join(dir, dirEntry.Name()))
+			continue
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		infos = append(infos, info)
+	}
+
+	// Modification time is updated on access, so it orders files by last use.
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, info := range infos {
+		c.byKey[info.Name()] = c.entries.PushBack(&cacheEntry{key: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+	c.evict(nil)
+
+	return c, nil
+}
+
+// MaxSize returns the max size of files in the cache.
+func (c *Cache) MaxSize() int64 {
+	return c.maxSize
+}
+
+// Open returns cached file with the key. If file is not cached,
+// it is written by fetch first.
+//
+// Fetch is shared by all callers of the same key and runs on the context
+// owned by the cache, so it is not cancelled when caller that started it
+// stops waiting. Each caller stops waiting when its context is done,
+// and fetch is cancelled when there are no callers left.
+func (c *Cache) Open(ctx context.Context, key string, fetch func(ctx context.Context, w io.Writer) error) (*os.File, error) {
+	for {
+		c.mu.Lock()
+		if el, ok := c.byKey[key]; ok {
+			c.entries.MoveToBack(el)
+			// File is opened before it can be evicted by other call.
+			f, err := os.Open(c.path(key))
+			c.mu.Unlock()
+
+			now := time.Now()
+			os.Chtimes(c.path(key), now, now)
+
+			return f, err
+		}
+
+		load, ok := c.loading[key]
+		if !ok {
+			load = c.startLoad(key, fetch)
+		}
+		load.waiters++
+		c.mu.Unlock()
+
+		select {
+		case <-load.done:
+			c.mu.Lock()
+			load.waiters--
+			c.mu.Unlock()
+
+			if load.err != nil {
+				return nil, load.err
+			}
+		case <-ctx.Done():
+			c.mu.Lock()
+			load.waiters--
+			if load.waiters == 0 {
+				load.cancel()
+				// Next caller starts a new fetch instead of waiting for cancelled one.
+				if c.loading[key] == load {
+					delete(c.loading, key)
+				}
+			}
+			c.mu.Unlock()
+
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// startLoad starts fetch of the file in the background.
+// It must be called with mu held.
+func (c *Cache) startLoad(key string, fetch func(ctx context.Context, w io.Writer) error) *cacheLoad {
+	ctx, cancel := context.WithCancel(context.Background())
+	load := &cacheLoad{done: make(chan struct{}), cancel: cancel}
+	c.loading[key] = load
+
+	go func() {
+		defer cancel()
+
+		err := c.load(key, func(w io.Writer) error {
+			return fetch(ctx, w)
+		})
+
+		c.mu.Lock()
+		if c.loading[key] == load {
+			delete(c.loading, key)
+		}
+		c.mu.Unlock()
+
+		load.err = err
+		close(load.done)
+	}()
+
+	return load
+}
+
+// load fetches the file into the cache.
+func (c *Cache) load(key string, fetch func(w io.Writer) error) error {
+	tmpFile, err := os.CreateTemp(c.dir, "*.part")
+	if err != nil {
+		return fmt.Errorf("create cache file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if err := fetch(tmpFile); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	stat, err := tmpFile.Stat()
+	if err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("stat cache file: %w", err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("close cache file: %w", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), c.path(key)); err != nil {
+		return fmt.Errorf("move cache file: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Cancelled fetch may finish after the new one has added the same file.
+	if _, ok := c.byKey[key]; ok {
+		return nil
+	}
+
+	el := c.entries.PushBack(&cacheEntry{key: key, size: stat.Size()})
+	c.byKey[key] = el
+	c.size += stat.Size()
+	c.evict(el)
+
+	return nil
+}
+
+// evict removes least recently used files till cache fits into max size.
+// Keep element is not removed, even if it is larger than max size.
+func (c *Cache) evict(keep *list.Element) {
+	for el := c.entries.Front(); el != nil && c.size > c.maxSize; {
+		next := el.Next()
+		if el != keep {
+			entry := el.Value.(*cacheEntry)
+			// Files that are still read stay on disk till they are closed.
+			os.Remove(c.path(entry.key))
+			c.entries.Remove(el)
+			delete(c.byKey, entry.key)
+			c.size -= entry.size
+		}
+		el = next
+	}
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+func (c *Client) setupCache(app config.App) error {
+	if app.CacheSizeMB < 0 {
+		return nil
+	}
+
+	maxSize := int64(app.CacheSizeMB) * 1024 * 1024
+	if maxSize == 0 {
+		maxSize = DefaultCacheSize
+	}
+
+	var err error
+	if c.Cache, err = NewCache(filepath.Join(c.TempPath, "cache"), maxSize); err != nil {
+		return fmt.Errorf("create cache: %w", err)
+	}
+
+	return nil
+}
+
+// Cacheable reports whether remote file can be downloaded into the cache.
+func (c *Client) Cacheable(relativePath string) bool {
+	file, ok := c.FindFile(relativePath)
+
+	return ok && c.Cache != nil && file.Size <= c.Cache.MaxSize()
+}
+
+// OpenCached returns remote file from the cache,
+// downloading it if it is not cached yet.
+func (c *Client) OpenCached(ctx context.Context, relativePath string) (*os.File, error) {
+	msgIDs := c.fileMessageIDs(relativePath)
+	if len(msgIDs) == 0 {
+		return nil, fmt.Errorf("file %q is not present in remote chat", relativePath)
+	}
+
+	if c.Cache == nil {
+		return nil, errors.New("cache is disabled")
+	}
+
+	// Changed file gets new hash, so outdated content is never returned.
+	key := strconv.FormatInt(msgIDs[0], 10)
+	if file, ok := c.FindFile(relativePath); ok && file.Hash != "" {
+		key += "_" + file.Hash
+	}
+
+	return c.Cache.Open(ctx, key, func(ctx context.Context, w io.Writer) error {
+		return c.StreamFile(ctx, w, relativePath)
+	})
+}
@@ -0,0 +1,438 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/encryption"
+	"github.com/ffenix113/teleporter/events"
+	"github.com/ffenix113/teleporter/ignore"
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/syncstate"
+	"github.com/ffenix113/teleporter/tasks"
+)
+
+const Teleporter = "Teleporter"
+
+const DefaultPartSize = 1024 * 1024 * 1024 // 1 GB
+
+type Client struct {
+	// Backend stores files and the header.
+	Backend      manager.Manager
+	PinnedHeader manager.PinnedHeader
+	FileTree     *manager.Tree
+	FilesPath    string
+	TaskMonitor  *tasks.Monitor
+	// headerDocumentMessageID is the ID of the message with header document,
+	// if header does not fit into pinned message.
+	headerDocumentMessageID int64
+
+	// headerMu guards PinnedHeader and FileTree.
+	headerMu sync.RWMutex
+	// headerSendMu makes sure that header is not sent concurrently,
+	// so older header will not overwrite the newer one.
+	headerSendMu sync.Mutex
+
+	// connectionState is the last state reported by the backend.
+	// It is guarded by connectionMu, as backend reports it from its own goroutine.
+	connectionState string
+	connectionMu    sync.Mutex
+
+	TempPath string
+	// PartSize is the max size of one document.
+	// Files larger than this will be split in multiple parts.
+	PartSize int64
+	// Cipher is used to encrypt files and headers.
+	// It is nil if encryption is not enabled.
+	Cipher *encryption.Cipher
+	// Events receives changes of tasks and connection state.
+	Events *events.Bus
+	// Ignore decides which files are not synchronized.
+	Ignore *ignore.Matcher
+	// State holds state of files at last synchronization.
+	State *syncstate.DB
+	// Selection decides which remote files are downloaded.
+	Selection *Selection
+	// Cache keeps remote files downloaded on demand.
+	// It is nil if cache is disabled.
+	Cache *Cache
+	// ConflictPolicy specifies how files changed on both sides are resolved.
+	ConflictPolicy string
+	// DeviceName is used in names of conflict copies.
+	DeviceName string
+	// VersionsKeep is the max number of previous versions kept for each file.
+	VersionsKeep int
+	// VersionsRetention is how long previous versions are kept.
+	VersionsRetention time.Duration
+	// TrashRetention is how long deleted files are kept in trash.
+	// Trash is disabled if it is negative.
+	TrashRetention time.Duration
+
+	// oneShot is set if client was created with OneShot option.
+	oneShot bool
+}
+
+// options are settings of the client that are not read from the configuration.
+type options struct {
+	tasks   tasks.Config
+	oneShot bool
+}
+
+// Option changes how the client is created.
+type Option func(opts *options)
+
+// PauseTasks makes client start with execution of tasks paused,
+// so state can be inspected without changing it.
+func PauseTasks() Option {
+	return func(opts *options) {
+		opts.tasks.Paused = true
+	}
+}
+
+// OneShot makes client for a command that runs once: header is not written
+// on start, unfinished tasks from the journal are not restored and changes
+// of the header by other clients are not followed, so client changes
+// only what the command asks for.
+func OneShot() Option {
+	return func(opts *options) {
+		opts.oneShot = true
+	}
+}
+
+// NewClient returns a new client that synchronizes files with the backend.
+//
+// Context must live for as long as application should live.
+func NewClient(ctx context.Context, cnf config.Config, backend manager.Manager, opts ...Option) (*Client, error) {
+	if !strings.HasSuffix(cnf.App.FilesPath, "/") {
+		cnf.App.FilesPath += "/"
+	}
+
+	bus := events.NewBus()
+
+	taskCnf, err := taskConfig(cnf)
+	if err != nil {
+		return nil, err
+	}
+	taskCnf.Events = bus
+
+	o := options{tasks: taskCnf}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c := &Client{
+		Backend:      backend,
+		TaskMonitor:  tasks.NewMonitor(ctx, o.tasks),
+		FilesPath:    cnf.App.FilesPath,
+		TempPath:     cnf.App.TempPath,
+		PinnedHeader: manager.PinnedHeader{Header: Teleporter, Files: map[string]int64{}, Parts: map[string][]int64{}, Versions: map[string][]manager.Version{}},
+		FileTree:     &manager.Tree{},
+		PartSize:     int64(cnf.App.PartSizeMB) * 1024 * 1024,
+		Events:       bus,
+
+		VersionsKeep:      cnf.App.VersionsKeep,
+		VersionsRetention: cnf.App.VersionsRetention,
+		TrashRetention:    cnf.App.TrashRetention,
+
+		oneShot: o.oneShot,
+	}
+
+	if c.TrashRetention == 0 {
+		c.TrashRetention = DefaultTrashRetention
+	}
+
+	if c.PartSize <= 0 {
+		c.PartSize = DefaultPartSize
+	}
+
+	if c.TempPath == "" {
+		c.TempPath = c.tempPath()
+	}
+
+	ignorePatterns := append([]string{}, cnf.App.IgnorePatterns...)
+	// Temp dir is inside of files dir by default.
+	if relativeTempPath := c.RelativePath(c.TempPath); relativeTempPath != c.TempPath {
+		ignorePatterns = append(ignorePatterns, "/"+relativeTempPath+"/")
+	}
+	c.Ignore = ignore.NewMatcher(c.FilesPath, ignorePatterns)
+
+	if err := c.setupSyncState(cnf); err != nil {
+		return nil, err
+	}
+
+	if err := c.setupSelection(cnf); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(c.TempPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(c.TempPath, 0755); err != nil {
+			return nil, fmt.Errorf("create temp files dir: %w", err)
+		}
+	}
+
+	if err := c.setupCache(cnf.App); err != nil {
+		return nil, err
+	}
+
+	log.Println("fetching init information")
+	if err := c.FetchInitInformation(ctx, cnf); err != nil {
+		return nil, fmt.Errorf("fetch init: %w", err)
+	}
+
+	if c.oneShot {
+		backend.Watch(nil, c.connectionStateChanged)
+		return c, nil
+	}
+
+	backend.Watch(c.headerUpdated, c.connectionStateChanged)
+
+	log.Println("restoring unfinished tasks")
+	c.TaskMonitor.Replay(c.taskFactories())
+
+	go c.purgeTrashPeriodically(ctx)
+	go c.pruneVersionsPeriodically(ctx)
+
+	return c, nil
+}
+
+func taskConfig(cnf config.Config) (tasks.Config, error) {
+	app := cnf.App
+
+	retry := tasks.DefaultRetryPolicy
+	if app.RetryMaxAttempts != 0 {
+		retry.MaxAttempts = app.RetryMaxAttempts
+	}
+
+	if app.RetryBaseDelay != 0 {
+		retry.BaseDelay = app.RetryBaseDelay
+	}
+
+	if app.RetryMaxDelay != 0 {
+		retry.MaxDelay = app.RetryMaxDelay
+	}
+
+	journalPath := app.JournalPath
+	if journalPath == "" {
+		journalPath = path.Join(filepath.Dir(cnf.Telegram.Config.DatabaseDirectory), "tasks.jsonl")
+	}
+
+	retention := app.JournalRetention
+	if retention == 0 {
+		retention = tasks.DefaultJournalRetention
+	}
+
+	journal, err := tasks.OpenJournal(journalPath, retention)
+	if err != nil {
+		return tasks.Config{}, fmt.Errorf("open tasks journal: %w", err)
+	}
+
+	return tasks.Config{
+		Workers:      app.Workers,
+		TypeLimits:   app.TaskLimits,
+		Retry:        retry,
+		KeepFinished: app.KeepFinishedTasks,
+		Journal:      journal,
+	}, nil
+}
+
+// taskFactories returns factories to restore tasks from the journal.
+func (c *Client) taskFactories() map[string]tasks.TaskFactory {
+	return map[string]tasks.TaskFactory{
+		"UploadFile": func(record tasks.JournalRecord) (tasks.Task, error) {
+			return NewUploadFile(c, c.AbsPath(record.Name), record.Details), nil
+		},
+		"DownloadFile": func(record tasks.JournalRecord) (tasks.Task, error) {
+			return NewDownloadFile(c, record.Name, record.Details), nil
+		},
+		"DeleteFile": func(record tasks.JournalRecord) (tasks.Task, error) {
+			return NewDeleteFile(c, record.Name), nil
+		},
+		"DeleteDir": func(record tasks.JournalRecord) (tasks.Task, error) {
+			return NewDeleteDir(c, strings.TrimSuffix(record.Name, "/")), nil
+		},
+		"ResolveConflict": func(record tasks.JournalRecord) (tasks.Task, error) {
+			return NewResolveConflict(c, record.Name, c.ConflictPolicy), nil
+		},
+		"PurgeTrash": func(record tasks.JournalRecord) (tasks.Task, error) {
+			return NewPurgeTrash(c, record.Args["all"] == "true"), nil
+		},
+		"PruneVersions": func(record tasks.JournalRecord) (tasks.Task, error) {
+			return NewPruneVersions(c), nil
+		},
+		"MoveFile": func(record tasks.JournalRecord) (tasks.Task, error) {
+			return NewMoveFile(c, record.Source, record.Name), nil
+		},
+		"MoveDir": func(record tasks.JournalRecord) (tasks.Task, error) {
+			return NewMoveDir(c, strings.TrimSuffix(record.Source, "/"), strings.TrimSuffix(record.Name, "/")), nil
+		},
+	}
+}
+
+func (c *Client) AddTask(tsk tasks.Task) {
+	c.TaskMonitor.AddTask(tsk)
+}
+
+func (c *Client) AddPreAddHook(hook tasks.Hook) (remove func()) {
+	return c.TaskMonitor.AddPreAddHook(hook)
+}
+
+func (c *Client) FetchInitInformation(ctx context.Context, cnf config.Config) error {
+	text, err := c.Backend.ReadHeader(ctx)
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+
+	// New header is written on first start.
+	header := c.PinnedHeader
+	created := text == ""
+	if !created {
+		header, err = c.resolveHeader(ctx, text)
+		if err != nil {
+			return fmt.Errorf("read pinned message: %w", err)
+		}
+	}
+
+	if err := c.setupCipher(cnf.App, &header); err != nil {
+		return fmt.Errorf("setup encryption: %w", err)
+	}
+
+	// Plain header will be encrypted if encryption was enabled.
+	shouldSendHeader := created || c.Cipher != nil && header.Encrypted == ""
+
+	if err := c.decryptHeader(&header); err != nil {
+		return err
+	}
+
+	c.setHeader(header)
+
+	c.addFilesToTree()
+
+	// One-shot client writes the header only when command changes it.
+	if shouldSendHeader && !c.oneShot {
+		if err := c.SendHeader(ctx); err != nil {
+			return fmt.Errorf("send header: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) addFilesToTree() {
+	for filePath, msgID := range c.HeaderFiles() {
+		data, err := c.GetFileDataByMsgID(context.TODO(), msgID)
+		if err != nil {
+			c.AddTask(NewStaticTask(filePath, &Common{
+				taskType: "FetchData",
+				status:   tasks.TaskStatusError,
+				details:  fmt.Sprintf("get file header: %s", err.Error()),
+			}))
+			continue
+		}
+
+		data.Name = filepath.Base(data.Path)
+
+		c.setFileInfo(filePath, &data)
+	}
+}
+
+func (c *Client) SynchronizeFiles() error {
+	c.DownloadRemoteFiles()
+
+	err := filepath.WalkDir(c.FilesPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if c.Ignore.IgnoredAbs(path, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if _, exists := c.HeaderFile(c.RelativePath(path)); !exists {
+			c.AddTask(NewUploadFile(c, path))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("walk dir: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) DownloadRemoteFiles() {
+	for relativeFilePath, msgID := range c.HeaderFiles() {
+		if c.Ignore.Ignored(relativeFilePath, false) {
+			continue
+		}
+
+		stat, err := os.Stat(c.AbsPath(relativeFilePath))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				if c.CloudOnly(relativeFilePath, false) {
+					continue
+				}
+
+				c.AddTask(NewDownloadFile(c, relativeFilePath, "file does not exist"))
+				continue
+			}
+
+			c.AddTask(NewStaticTask(relativeFilePath, &Common{
+				taskType: "VerifyLocalFileExist",
+				status:   tasks.TaskStatusError,
+				progress: 100,
+				details:  fmt.Sprintf("local file stat: %s", err.Error()),
+			}))
+
+			continue
+		}
+
+		data, err := c.GetFileDataByMsgID(context.TODO(), msgID)
+		if err != nil {
+			c.AddTask(NewStaticTask(relativeFilePath, &Common{
+				taskType: "VerifyLocalFileExist",
+				status:   tasks.TaskStatusError,
+				progress: 100,
+				details:  fmt.Sprintf("get file header: %s", err.Error()),
+			}))
+			continue
+		}
+
+		// File info may be changed by other client without changing the header.
+		data.Name = filepath.Base(data.Path)
+		c.setFileInfo(relativeFilePath, &data)
+
+		c.syncFile(relativeFilePath, msgID, stat, data)
+	}
+}
+
+func (c *Client) RelativePath(absPath string) string {
+	return strings.TrimPrefix(absPath, c.FilesPath)
+}
+
+func (c *Client) AbsPath(relative string) string {
+	return path.Join(c.FilesPath, relative)
+}
+
+func (c *Client) tempPath() string {
+	return path.Join(filepath.Dir(c.FilesPath), ".tmp")
+}
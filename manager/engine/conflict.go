@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/syncstate"
+)
+
+// Policies to resolve files that were changed both locally and remotely.
+const (
+	ConflictKeepBoth     = "keep-both"
+	ConflictPreferLocal  = "prefer-local"
+	ConflictPreferRemote = "prefer-remote"
+)
+
+func (c *Client) setupSyncState(cnf config.Config) error {
+	c.ConflictPolicy = cnf.App.ConflictPolicy
+	switch c.ConflictPolicy {
+	case "":
+		c.ConflictPolicy = ConflictKeepBoth
+	case ConflictKeepBoth, ConflictPreferLocal, ConflictPreferRemote:
+	default:
+		return fmt.Errorf("unknown conflict policy: %q", c.ConflictPolicy)
+	}
+
+	c.DeviceName = cnf.App.DeviceName
+	if c.DeviceName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("get hostname for device name: %w", err)
+		}
+
+		c.DeviceName = hostname
+	}
+
+	statePath := cnf.App.StatePath
+	if statePath == "" {
+		statePath = path.Join(filepath.Dir(cnf.Telegram.Config.DatabaseDirectory), "state.jsonl")
+	}
+
+	var err error
+	if c.State, err = syncstate.Open(statePath); err != nil {
+		return fmt.Errorf("open sync state: %w", err)
+	}
+
+	return nil
+}
+
+// markSynced records that local and remote files have the same content.
+func (c *Client) markSynced(relativePath string, msgID int64, hash string) {
+	stat, err := os.Stat(c.AbsPath(relativePath))
+	if err != nil {
+		return
+	}
+
+	if hash == "" {
+		if hash, err = fileHash(c.AbsPath(relativePath)); err != nil {
+			return
+		}
+	}
+
+	c.State.Set(syncstate.Entry{
+		Path:      relativePath,
+		Hash:      hash,
+		MessageID: msgID,
+		ModTime:   stat.ModTime(),
+	})
+}
+
+// rebaseOnRemote records current remote file as the last synchronized one,
+// so local file is seen as changed on top of it and is uploaded over it.
+func (c *Client) rebaseOnRemote(relativePath string) {
+	remote, ok := c.FindFile(relativePath)
+	if !ok {
+		return
+	}
+
+	msgID, _ := c.HeaderFile(relativePath)
+	c.State.Set(syncstate.Entry{
+		Path:      relativePath,
+		Hash:      remote.Hash,
+		MessageID: msgID,
+	})
+}
+
+// syncFile adds task to synchronize file that is present both locally and remotely.
+//
+// Direction is decided by the state at last synchronization, so file that
+// was changed on both sides is reported as a conflict. If file was never
+// synchronized, or remote file has no hash - newer file wins.
+func (c *Client) syncFile(relativePath string, msgID int64, stat os.FileInfo, remote manager.File) {
+	absPath := c.AbsPath(relativePath)
+
+	base, ok := c.State.Get(relativePath)
+	if !ok || remote.Hash == "" {
+		// Modification time may differ because of touch or clock skew
+		// between machines, while content stays the same.
+		if !remote.FileUpdatedAt.Equal(stat.ModTime()) && sameContent(absPath, remote.Hash) {
+			c.markSynced(relativePath, msgID, remote.Hash)
+			return
+		}
+
+		switch {
+		case remote.FileUpdatedAt.After(stat.ModTime()):
+			c.AddTask(NewDownloadFile(c, relativePath, fmt.Sprintf("%s > %s", remote.FileUpdatedAt.Format(time.RFC3339Nano), stat.ModTime().Format(time.RFC3339Nano))))
+		case remote.FileUpdatedAt.Before(stat.ModTime()):
+			c.AddTask(NewUploadFile(c, absPath))
+		}
+
+		return
+	}
+
+	remoteChanged := remote.Hash != base.Hash
+	localChanged := !stat.ModTime().Equal(base.ModTime) && !sameContent(absPath, base.Hash)
+
+	switch {
+	case remoteChanged && localChanged:
+		if sameContent(absPath, remote.Hash) {
+			c.markSynced(relativePath, msgID, remote.Hash)
+			return
+		}
+
+		c.AddTask(NewResolveConflict(c, relativePath, c.ConflictPolicy))
+	case remoteChanged:
+		c.AddTask(NewDownloadFile(c, relativePath, "changed remotely"))
+	case localChanged:
+		c.AddTask(NewUploadFile(c, absPath, "changed locally"))
+	}
+}
+
+// conflictPath returns path for the conflicting copy of the file,
+// like "name (conflict from device 2006-01-02 15-04-05).ext".
+func (c *Client) conflictPath(relativePath string, at time.Time) string {
+	ext := path.Ext(relativePath)
+	name := strings.TrimSuffix(relativePath, ext)
+
+	return fmt.Sprintf("%s (conflict from %s %s)%s", name, c.DeviceName, at.Format("2006-01-02 15-04-05"), ext)
+}
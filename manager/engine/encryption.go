@@ -0,0 +1,203 @@
+package engine
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/encryption"
+	"github.com/ffenix113/teleporter/manager"
+)
+
+// setupCipher creates cipher from configuration if encryption is enabled.
+//
+// If key is derived from passphrase and header does not have salt yet -
+// new salt will be set to the header.
+func (c *Client) setupCipher(app config.App, header *manager.PinnedHeader) error {
+	var key []byte
+	var err error
+
+	switch {
+	case app.EncryptionKeyFile != "":
+		key, err = encryption.KeyFromFile(app.EncryptionKeyFile)
+	case app.EncryptionPassphrase != "":
+		if header.Salt == "" {
+			if header.Encrypted != "" {
+				return fmt.Errorf("header is encrypted but has no salt: probably key file should be used")
+			}
+
+			header.Salt = base64.StdEncoding.EncodeToString(encryption.NewSalt())
+		}
+
+		salt, decodeErr := base64.StdEncoding.DecodeString(header.Salt)
+		if decodeErr != nil {
+			return fmt.Errorf("decode header salt: %w", decodeErr)
+		}
+
+		key, err = encryption.KeyFromPassphrase(app.EncryptionPassphrase, salt)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("derive encryption key: %w", err)
+	}
+
+	c.Cipher, err = encryption.NewCipher(key)
+
+	return err
+}
+
+func (c *Client) decodeHeader(ctx context.Context, text string) (manager.PinnedHeader, error) {
+	header, err := c.resolveHeader(ctx, text)
+	if err != nil {
+		return manager.PinnedHeader{}, err
+	}
+
+	if err := c.decryptHeader(&header); err != nil {
+		return manager.PinnedHeader{}, err
+	}
+
+	return header, nil
+}
+
+func (c *Client) decryptHeader(header *manager.PinnedHeader) error {
+	defer func() {
+		if header.Files == nil {
+			header.Files = map[string]int64{}
+		}
+
+		if header.Parts == nil {
+			header.Parts = map[string][]int64{}
+		}
+
+		if header.Versions == nil {
+			header.Versions = map[string][]manager.Version{}
+		}
+
+		if header.Trash == nil {
+			header.Trash = []manager.TrashEntry{}
+		}
+	}()
+
+	if header.Encrypted == "" {
+		return nil
+	}
+
+	if c.Cipher == nil {
+		return fmt.Errorf("header is encrypted: %w", encryption.ErrNoKey)
+	}
+
+	data, err := c.Cipher.OpenString(header.Encrypted)
+	if err != nil {
+		return fmt.Errorf("decrypt header: %w", err)
+	}
+
+	var decrypted manager.PinnedHeader
+	if err := manager.Unmarshal(data, &decrypted); err != nil {
+		return fmt.Errorf("unmarshal decrypted header: %w", err)
+	}
+
+	header.Files = decrypted.Files
+	header.Parts = decrypted.Parts
+	header.Versions = decrypted.Versions
+	header.Trash = decrypted.Trash
+	header.Encrypted = ""
+
+	return nil
+}
+
+func (c *Client) encodeHeader() ([]byte, error) {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	if c.Cipher == nil {
+		return manager.Marshal(c.PinnedHeader)
+	}
+
+	data, err := manager.Marshal(manager.PinnedHeader{
+		Files:    c.PinnedHeader.Files,
+		Parts:    c.PinnedHeader.Parts,
+		Versions: c.PinnedHeader.Versions,
+		Trash:    c.PinnedHeader.Trash,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal header files: %w", err)
+	}
+
+	return manager.Marshal(manager.PinnedHeader{
+		Header:    c.PinnedHeader.Header,
+		Salt:      c.PinnedHeader.Salt,
+		Encrypted: c.Cipher.SealString(data),
+	})
+}
+
+// encodeFileInfo returns file info that should be set as a caption to file message.
+func (c *Client) encodeFileInfo(file manager.File) ([]byte, error) {
+	file.Encrypted = ""
+
+	data, err := manager.Marshal(file)
+	if err != nil || c.Cipher == nil {
+		return data, err
+	}
+
+	return manager.Marshal(manager.File{Encrypted: c.Cipher.SealString(data)})
+}
+
+// decodeFileInfo returns file info from the message caption
+// and whether file content is encrypted.
+func (c *Client) decodeFileInfo(caption string) (manager.File, bool, error) {
+	var file manager.File
+	if err := manager.Unmarshal([]byte(caption), &file); err != nil {
+		return manager.File{}, false, fmt.Errorf("unmarshal file info: %w", err)
+	}
+
+	if file.Encrypted == "" {
+		return file, false, nil
+	}
+
+	if c.Cipher == nil {
+		return manager.File{}, true, fmt.Errorf("file info is encrypted: %w", encryption.ErrNoKey)
+	}
+
+	data, err := c.Cipher.OpenString(file.Encrypted)
+	if err != nil {
+		return manager.File{}, true, fmt.Errorf("decrypt file info: %w", err)
+	}
+
+	file = manager.File{}
+	if err := manager.Unmarshal(data, &file); err != nil {
+		return manager.File{}, true, fmt.Errorf("unmarshal decrypted file info: %w", err)
+	}
+
+	return file, true, nil
+}
+
+// encryptToTemp will encrypt the file into a temporary file
+// and return the path to it. Caller is responsible for removing it.
+func (c *Client) encryptToTemp(filePath string) (string, error) {
+	return c.processToTemp(filePath, c.Cipher.EncryptFile)
+}
+
+// decryptToTemp will decrypt the file into a temporary file
+// and return the path to it. Caller is responsible for removing it.
+func (c *Client) decryptToTemp(filePath string) (string, error) {
+	return c.processToTemp(filePath, c.Cipher.DecryptFile)
+}
+
+func (c *Client) processToTemp(filePath string, process func(dstPath, srcPath string) error) (string, error) {
+	tmpFile, err := os.CreateTemp(c.TempPath, "*.tlp")
+	if err != nil {
+		return "", fmt.Errorf("create temp file: %w", err)
+	}
+	tmpFile.Close()
+
+	if err := process(tmpFile.Name(), filePath); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	return tmpFile.Name(), nil
+}
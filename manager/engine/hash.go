@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// fileHash returns hex encoded SHA-256 of the file content.
+func fileHash(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash file: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sameContent reports whether local file has provided hash.
+// Files without known hash are never considered the same.
+func sameContent(filePath, hash string) bool {
+	if hash == "" {
+		return false
+	}
+
+	localHash, err := fileHash(filePath)
+
+	return err == nil && localHash == hash
+}
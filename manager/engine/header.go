@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/syncstate"
+)
+
+// Methods below guard access to PinnedHeader and FileTree,
+// as they are used concurrently by tasks, web handlers and update handlers.
+
+// HeaderFile returns ID of the message with the file from the header.
+func (c *Client) HeaderFile(relativePath string) (int64, bool) {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	msgID, ok := c.PinnedHeader.Files[relativePath]
+
+	return msgID, ok
+}
+
+// IsChunked reports whether the file is stored in multiple parts.
+func (c *Client) IsChunked(relativePath string) bool {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	_, ok := c.PinnedHeader.Parts[relativePath]
+
+	return ok
+}
+
+// HeaderFiles returns a copy of files from the header.
+func (c *Client) HeaderFiles() map[string]int64 {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	files := make(map[string]int64, len(c.PinnedHeader.Files))
+	for filePath, msgID := range c.PinnedHeader.Files {
+		files[filePath] = msgID
+	}
+
+	return files
+}
+
+// FindFile returns a copy of file info from the file tree.
+func (c *Client) FindFile(relativePath string) (manager.File, bool) {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	file, ok := manager.FindInTree[*manager.File](c.FileTree, relativePath)
+	if !ok {
+		return manager.File{}, false
+	}
+
+	return *file, true
+}
+
+// ListDir returns info about files and directories in the directory.
+func (c *Client) ListDir(relativePath string) ([]*manager.File, bool) {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	tree, ok := manager.FindInTree[*manager.Tree](c.FileTree, relativePath)
+	if !ok {
+		return nil, false
+	}
+
+	return tree.FilesInfo(), true
+}
+
+func (c *Client) setHeader(header manager.PinnedHeader) {
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	c.PinnedHeader = header
+}
+
+// setFile sets messages of the file to the header and file info to the tree.
+func (c *Client) setFile(relativePath string, msgIDs []int64, file *manager.File) {
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	c.PinnedHeader.Files[relativePath] = msgIDs[0]
+	if len(msgIDs) > 1 {
+		c.PinnedHeader.Parts[relativePath] = msgIDs[1:]
+	} else {
+		delete(c.PinnedHeader.Parts, relativePath)
+	}
+
+	c.FileTree.Add(relativePath, &manager.Tree{File: file})
+
+	// File is uploaded from the local file, so both sides are the same.
+	c.State.Set(syncstate.Entry{
+		Path:      relativePath,
+		Hash:      file.Hash,
+		MessageID: msgIDs[0],
+		ModTime:   file.FileUpdatedAt,
+	})
+}
+
+// setFileInfo only updates file info in the tree.
+func (c *Client) setFileInfo(relativePath string, file *manager.File) {
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	c.FileTree.Add(relativePath, &manager.Tree{File: file})
+}
+
+func (c *Client) removeFile(relativePath string) {
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	delete(c.PinnedHeader.Files, relativePath)
+	delete(c.PinnedHeader.Parts, relativePath)
+	delete(c.PinnedHeader.Versions, relativePath)
+	c.FileTree.Delete(relativePath)
+	c.State.Delete(relativePath)
+}
+
+// removeDir removes all files in the directory from header and tree
+// and returns IDs of the messages of removed files and their versions.
+func (c *Client) removeDir(relativeDirPath string) []int64 {
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	var msgIDs []int64
+	for filePath, msgID := range c.PinnedHeader.Files {
+		if !strings.HasPrefix(filePath, relativeDirPath) {
+			continue
+		}
+
+		msgIDs = append(msgIDs, msgID)
+		msgIDs = append(msgIDs, c.PinnedHeader.Parts[filePath]...)
+		msgIDs = append(msgIDs, versionsMessageIDs(c.PinnedHeader.Versions[filePath])...)
+		delete(c.PinnedHeader.Files, filePath)
+		delete(c.PinnedHeader.Parts, filePath)
+		delete(c.PinnedHeader.Versions, filePath)
+	}
+
+	c.FileTree.Delete(strings.TrimSuffix(relativeDirPath, "/"))
+	c.State.DeleteDir(relativeDirPath)
+
+	return msgIDs
+}
+
+// dirFiles returns paths of files in the directory from the header.
+func (c *Client) dirFiles(relativeDirPath string) []string {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	var files []string
+	for filePath := range c.PinnedHeader.Files {
+		if strings.HasPrefix(filePath, relativeDirPath) {
+			files = append(files, filePath)
+		}
+	}
+
+	sort.Strings(files)
+
+	return files
+}
+
+// moveFile moves messages of the file in the header
+// and file info in the tree to the new path.
+func (c *Client) moveFile(oldPath, newPath string, file *manager.File) {
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	c.PinnedHeader.Files[newPath] = c.PinnedHeader.Files[oldPath]
+	if parts, ok := c.PinnedHeader.Parts[oldPath]; ok {
+		c.PinnedHeader.Parts[newPath] = parts
+	} else {
+		delete(c.PinnedHeader.Parts, newPath)
+	}
+
+	if versions, ok := c.PinnedHeader.Versions[oldPath]; ok {
+		c.PinnedHeader.Versions[newPath] = versions
+	} else {
+		delete(c.PinnedHeader.Versions, newPath)
+	}
+
+	delete(c.PinnedHeader.Files, oldPath)
+	delete(c.PinnedHeader.Parts, oldPath)
+	delete(c.PinnedHeader.Versions, oldPath)
+
+	c.FileTree.Delete(oldPath)
+	c.FileTree.Add(newPath, &manager.Tree{File: file})
+	c.State.Move(oldPath, newPath)
+}
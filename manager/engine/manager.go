@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/tasks"
+)
+
+// SendHeader is used to update header in the backend.
+//
+// If header does not fit into one message - it will be uploaded
+// as a document, and pinned message will point to it.
+func (c *Client) SendHeader(ctx context.Context) error {
+	c.headerSendMu.Lock()
+	defer c.headerSendMu.Unlock()
+
+	headerBytes, err := c.encodeHeader()
+	if err != nil {
+		return fmt.Errorf("marshal header: %w", err)
+	}
+
+	oldDocumentID := c.headerDocumentMessageID
+	c.headerDocumentMessageID = 0
+
+	if maxLength := c.Backend.MaxHeaderLength(); maxLength > 0 && len(headerBytes) > maxLength {
+		c.headerDocumentMessageID, err = c.sendHeaderDocument(ctx, headerBytes)
+		if err != nil {
+			c.headerDocumentMessageID = oldDocumentID
+			return fmt.Errorf("send header document: %w", err)
+		}
+
+		headerBytes, err = manager.Marshal(manager.PinnedHeader{
+			Header:   c.PinnedHeader.Header,
+			Salt:     c.PinnedHeader.Salt,
+			Document: c.headerDocumentMessageID,
+		})
+		if err != nil {
+			return fmt.Errorf("marshal header pointer: %w", err)
+		}
+	}
+
+	if err := c.Backend.WriteHeader(ctx, string(headerBytes)); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	if oldDocumentID != 0 && oldDocumentID != c.headerDocumentMessageID {
+		if err := c.Backend.Delete(ctx, oldDocumentID); err != nil {
+			return fmt.Errorf("delete previous header document: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) sendHeaderDocument(ctx context.Context, headerBytes []byte) (int64, error) {
+	headerFile, err := os.CreateTemp(c.TempPath, "header_*.json")
+	if err != nil {
+		return 0, fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(headerFile.Name())
+
+	_, err = headerFile.Write(headerBytes)
+	headerFile.Close()
+	if err != nil {
+		return 0, fmt.Errorf("write header to temp file: %w", err)
+	}
+
+	return c.Backend.Put(ctx, headerFile.Name(), "", func(int) {})
+}
+
+// resolveHeader returns the header from the pinned message text.
+//
+// If pinned message only points to the header document - document
+// will be downloaded and read. Returned header is not decrypted.
+func (c *Client) resolveHeader(ctx context.Context, text string) (manager.PinnedHeader, error) {
+	var header manager.PinnedHeader
+	if err := manager.Unmarshal([]byte(text), &header); err != nil {
+		return manager.PinnedHeader{}, fmt.Errorf("unmarshal header: %w", err)
+	}
+
+	// Document is replaced by SendHeader, which may run concurrently.
+	c.headerSendMu.Lock()
+	c.headerDocumentMessageID = header.Document
+	c.headerSendMu.Unlock()
+
+	if header.Document == 0 {
+		return header, nil
+	}
+
+	filePath, _, err := c.Backend.Get(ctx, header.Document, func(int) {})
+	if err != nil {
+		return manager.PinnedHeader{}, fmt.Errorf("download header document: %w", err)
+	}
+	defer os.Remove(filePath)
+
+	headerBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return manager.PinnedHeader{}, fmt.Errorf("read header document: %w", err)
+	}
+
+	header = manager.PinnedHeader{}
+	if err := manager.Unmarshal(headerBytes, &header); err != nil {
+		return manager.PinnedHeader{}, fmt.Errorf("unmarshal header document: %w", err)
+	}
+
+	return header, nil
+}
+
+func (c *Client) GetFileDataByMsgID(ctx context.Context, msgID int64) (manager.File, error) {
+	caption, err := c.Backend.Caption(ctx, msgID)
+	if err != nil {
+		return manager.File{}, err
+	}
+
+	fileHeader, _, err := c.decodeFileInfo(caption)
+	if err != nil {
+		return manager.File{}, fmt.Errorf("decode header: %w", err)
+	}
+
+	return fileHeader, nil
+}
+
+// PutFile uploads local file and blocks till it is uploaded.
+func (c *Client) PutFile(ctx context.Context, relativePath string) error {
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var taskErr error
+	c.AddTask(WithCallback(NewUploadFile(c, c.AbsPath(relativePath)), func(task tasks.Task) {
+		if task.Status() == tasks.TaskStatusError {
+			taskErr = errors.New(task.Details())
+		}
+		cancel()
+	}))
+
+	<-subCtx.Done()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return taskErr
+}
+
+func (c *Client) DeleteFile(ctx context.Context, filePath string) error {
+	if _, ok := c.HeaderFile(filePath); !ok {
+		return fmt.Errorf("file %s not found or is a directory", filePath)
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var taskErr error
+	c.AddTask(WithCallback(NewDeleteFile(c, filePath), func(task tasks.Task) {
+		if task.Status() == tasks.TaskStatusError {
+			taskErr = errors.New(task.Details())
+		}
+		cancel()
+	}))
+
+	<-subCtx.Done()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return taskErr
+}
+
+// DeleteDir deletes all files in the directory, locally and in the chat,
+// and returns result of deletion of each file.
+func (c *Client) DeleteDir(ctx context.Context, dirPath string) ([]DeleteResult, error) {
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	task := NewDeleteDir(c, dirPath)
+	c.AddTask(WithCallback(task, func(_ tasks.Task) {
+		cancel()
+	}))
+
+	<-subCtx.Done()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return task.Results, nil
+}
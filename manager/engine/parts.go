@@ -0,0 +1,306 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// ErrCorrupted is returned when content of the remote file does not match its hash.
+var ErrCorrupted = errors.New("streamed file is corrupted")
+
+// fileMessageIDs returns IDs of all messages that hold parts of the file.
+func (c *Client) fileMessageIDs(relativePath string) []int64 {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	msgID, ok := c.PinnedHeader.Files[relativePath]
+	if !ok {
+		return nil
+	}
+
+	return append([]int64{msgID}, c.PinnedHeader.Parts[relativePath]...)
+}
+
+func (c *Client) partsCount(size int64) int {
+	if size <= c.PartSize {
+		return 1
+	}
+
+	return int((size + c.PartSize - 1) / c.PartSize)
+}
+
+// writePart will write part of the file with provided index
+// into a temporary file, encrypting it if needed.
+//
+// Caller is responsible for removing returned file.
+func (c *Client) writePart(filePath string, idx int) (string, error) {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer src.Close()
+
+	if _, err := src.Seek(int64(idx)*c.PartSize, io.SeekStart); err != nil {
+		return "", fmt.Errorf("seek to part %d: %w", idx, err)
+	}
+
+	dst, err := os.CreateTemp(c.TempPath, "*.part")
+	if err != nil {
+		return "", fmt.Errorf("create temp file: %w", err)
+	}
+	defer dst.Close()
+
+	partReader := io.LimitReader(src, c.PartSize)
+	if c.Cipher != nil {
+		err = c.Cipher.Encrypt(dst, partReader)
+	} else {
+		_, err = io.Copy(dst, partReader)
+	}
+
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", fmt.Errorf("write part %d: %w", idx, err)
+	}
+
+	return dst.Name(), nil
+}
+
+// copyPart writes content of the downloaded part into dst,
+// decrypting it if needed. It returns the number of written bytes.
+func (c *Client) copyPart(dst io.Writer, partPath string, encrypted bool) (int64, error) {
+	src, err := os.Open(partPath)
+	if err != nil {
+		return 0, fmt.Errorf("open part: %w", err)
+	}
+	defer src.Close()
+
+	if !encrypted {
+		return io.Copy(dst, src)
+	}
+
+	if c.Cipher == nil {
+		return 0, fmt.Errorf("part is encrypted, but encryption is not configured")
+	}
+
+	counter := &countingWriter{Writer: dst}
+	err = c.Cipher.Decrypt(counter, src)
+
+	return counter.written, err
+}
+
+// StreamFile writes content of the remote file into the writer.
+//
+// Parts are downloaded one by one and removed after they are written,
+// so the whole file is never stored on disk. Written content is checked
+// against hash of the file at the end, so on mismatch error writer
+// has already received all of it.
+func (c *Client) StreamFile(ctx context.Context, w io.Writer, relativePath string) error {
+	file, ok := c.FindFile(relativePath)
+	if !ok || file.Hash == "" {
+		return c.StreamRange(ctx, w, relativePath, 0, -1)
+	}
+
+	h := sha256.New()
+	if err := c.StreamRange(ctx, io.MultiWriter(w, h), relativePath, 0, -1); err != nil {
+		return err
+	}
+
+	return verifyHash(h, file.Hash)
+}
+
+// verifyHash checks that hashed content has expected hash.
+func verifyHash(h hash.Hash, want string) error {
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("%w: hash mismatch: want: %s, got: %s", ErrCorrupted, want, got)
+	}
+
+	return nil
+}
+
+// StreamRange is the same as StreamFile, but writes only length bytes
+// starting from the offset, or all bytes till the end if length is negative.
+//
+// Parts before the offset are not downloaded if part size of the file is known.
+// Content is not checked against hash of the file, as only part of it may be read.
+func (c *Client) StreamRange(ctx context.Context, w io.Writer, relativePath string, offset, length int64) error {
+	msgIDs := c.fileMessageIDs(relativePath)
+	if len(msgIDs) == 0 {
+		return fmt.Errorf("file %q is not present in remote chat", relativePath)
+	}
+
+	first := 0
+	if file, ok := c.FindFile(relativePath); ok && file.PartSize > 0 && len(msgIDs) > 1 {
+		first = int(offset / file.PartSize)
+		if first >= len(msgIDs) {
+			first = len(msgIDs) - 1
+		}
+		offset -= int64(first) * file.PartSize
+	}
+
+	rw := &rangeWriter{Writer: w, skip: offset, remain: length}
+	for i := first; i < len(msgIDs) && rw.remain != 0; i++ {
+		partPath, caption, err := c.Backend.Get(ctx, msgIDs[i], func(int) {})
+		if err != nil {
+			return fmt.Errorf("download part %d: %w", i, err)
+		}
+
+		_, encrypted, err := c.decodeFileInfo(caption)
+		if err != nil {
+			os.Remove(partPath)
+			return fmt.Errorf("decode part %d info: %w", i, err)
+		}
+
+		_, err = c.copyPart(rw, partPath, encrypted)
+		os.Remove(partPath)
+		if err != nil {
+			return fmt.Errorf("write part %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// rangeWriter skips first bytes and discards bytes after
+// the remaining length is written. Negative remain is not limited.
+type rangeWriter struct {
+	io.Writer
+	skip   int64
+	remain int64
+}
+
+func (w *rangeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if w.skip >= int64(len(p)) {
+		w.skip -= int64(len(p))
+		return n, nil
+	}
+	p = p[w.skip:]
+	w.skip = 0
+
+	if w.remain >= 0 && int64(len(p)) > w.remain {
+		p = p[:w.remain]
+	}
+
+	written, err := w.Writer.Write(p)
+	if w.remain >= 0 {
+		w.remain -= int64(written)
+	}
+	if err != nil {
+		return written, err
+	}
+
+	return n, nil
+}
+
+// remoteFile reads content of the remote file, streaming it from the offset
+// it was seeked to. It allows to serve ranges of the file without downloading
+// parts that are not requested.
+type remoteFile struct {
+	ctx          context.Context
+	cl           *Client
+	relativePath string
+	size         int64
+	hash         string
+	offset       int64
+	// pipe reads content streamed from the offset, it is nil till first read after seek.
+	pipe *io.PipeReader
+	// hasher hashes content that is read from the start of the file.
+	hasher hash.Hash
+}
+
+// OpenRemote returns reader of the remote file that can seek
+// without downloading the file, e.g. to serve HTTP ranges.
+//
+// Content that is read from the start till the end is checked against
+// hash of the file. On mismatch the last read fails with ErrCorrupted
+// instead of returning the end of the file, so the reader never gets
+// whole corrupted content. Ranges are not checked.
+func (c *Client) OpenRemote(ctx context.Context, relativePath string) (io.ReadSeekCloser, error) {
+	file, ok := c.FindFile(relativePath)
+	if !ok {
+		return nil, fmt.Errorf("file %q is not present in remote chat", relativePath)
+	}
+
+	return &remoteFile{ctx: ctx, cl: c, relativePath: relativePath, size: file.Size, hash: file.Hash}, nil
+}
+
+func (f *remoteFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.pipe == nil {
+		pr, pw := io.Pipe()
+		go func(offset int64) {
+			pw.CloseWithError(f.cl.StreamRange(f.ctx, pw, f.relativePath, offset, f.size-offset))
+		}(f.offset)
+		f.pipe = pr
+
+		f.hasher = nil
+		if f.offset == 0 && f.hash != "" {
+			f.hasher = sha256.New()
+		}
+	}
+
+	n, err := f.pipe.Read(p)
+	if f.hasher != nil {
+		f.hasher.Write(p[:n])
+
+		if f.offset+int64(n) == f.size {
+			if err := verifyHash(f.hasher, f.hash); err != nil {
+				return 0, err
+			}
+		}
+	}
+	f.offset += int64(n)
+
+	return n, err
+}
+
+func (f *remoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("seek to negative offset: %d", offset)
+	}
+
+	if offset != f.offset && f.pipe != nil {
+		f.pipe.Close()
+		f.pipe = nil
+	}
+	f.offset = offset
+
+	return offset, nil
+}
+
+func (f *remoteFile) Close() error {
+	if f.pipe != nil {
+		return f.pipe.Close()
+	}
+
+	return nil
+}
+
+type countingWriter struct {
+	io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.written += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/ignore"
+)
+
+// ErrLocalChanges is returned when local copy of the file
+// is not evicted because it differs from the remote one.
+var ErrLocalChanges = errors.New("local file has changes that are not uploaded")
+
+// Selection decides which remote files are downloaded to this device.
+//
+// Directories switched between synced and cloud only override
+// include and exclude patterns for all files in them.
+//
+// Nil Selection is valid: all files are synced.
+type Selection struct {
+	include ignore.Patterns
+	exclude ignore.Patterns
+
+	filePath string
+	mu       sync.RWMutex
+	// dirs holds switched directories, true if directory is synced.
+	dirs map[string]bool
+}
+
+// OpenSelection reads switched directories from the file.
+func OpenSelection(filePath string, include, exclude []string) (*Selection, error) {
+	s := &Selection{
+		include:  ignore.NewPatterns(include),
+		exclude:  ignore.NewPatterns(exclude),
+		filePath: filePath,
+		dirs:     map[string]bool{},
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return s, nil
+		}
+
+		return nil, fmt.Errorf("read selection: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.dirs); err != nil {
+		return nil, fmt.Errorf("decode selection: %w", err)
+	}
+
+	return s, nil
+}
+
+// Synced reports whether remote file or directory is downloaded to this device.
+func (s *Selection) Synced(relativePath string, isDir bool) bool {
+	if s == nil {
+		return true
+	}
+
+	relativePath = strings.Trim(relativePath, "/")
+
+	s.mu.RLock()
+	// Closest switched directory wins.
+	for dir := relativePath; ; dir = parentDir(dir) {
+		if synced, ok := s.dirs[dir]; ok && (isDir || dir != relativePath) {
+			s.mu.RUnlock()
+			return synced
+		}
+
+		if dir == "" {
+			break
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(s.include) != 0 && relativePath != "" && !s.include.Match(relativePath, isDir) {
+		// Directory is synced if it may contain included files.
+		return isDir
+	}
+
+	return !s.exclude.Match(relativePath, isDir)
+}
+
+// Dirs returns switched directories, true if directory is synced.
+func (s *Selection) Dirs() map[string]bool {
+	dirs := map[string]bool{}
+	if s == nil {
+		return dirs
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for dir, synced := range s.dirs {
+		dirs[dir] = synced
+	}
+
+	return dirs
+}
+
+// Set switches the directory with all directories in it
+// between synced and cloud only.
+func (s *Selection) Set(relativeDirPath string, synced bool) error {
+	if s == nil {
+		return errors.New("selection is not enabled")
+	}
+
+	relativeDirPath = strings.Trim(relativeDirPath, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for dir := range s.dirs {
+		if relativeDirPath == "" || strings.HasPrefix(dir, relativeDirPath+"/") {
+			delete(s.dirs, dir)
+		}
+	}
+	s.dirs[relativeDirPath] = synced
+
+	return s.save()
+}
+
+// save writes switched directories into the file, replacing it.
+func (s *Selection) save() error {
+	data, err := json.Marshal(s.dirs)
+	if err != nil {
+		return fmt.Errorf("encode selection: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.filePath), 0755); err != nil {
+		return fmt.Errorf("create selection dir: %w", err)
+	}
+
+	tmpPath := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("write selection: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("replace selection: %w", err)
+	}
+
+	return nil
+}
+
+// parentDir returns parent of the path, "" for top level paths.
+func parentDir(relativePath string) string {
+	if dir := path.Dir(relativePath); dir != "." {
+		return dir
+	}
+
+	return ""
+}
+
+func (c *Client) setupSelection(cnf config.Config) error {
+	selectionPath := cnf.App.SelectionPath
+	if selectionPath == "" {
+		selectionPath = path.Join(filepath.Dir(cnf.Telegram.Config.DatabaseDirectory), "selection.json")
+	}
+
+	var err error
+	if c.Selection, err = OpenSelection(selectionPath, cnf.App.SyncInclude, cnf.App.SyncExclude); err != nil {
+		return fmt.Errorf("open selection: %w", err)
+	}
+
+	return nil
+}
+
+// CloudOnly reports whether remote file or directory is not downloaded to this device.
+func (c *Client) CloudOnly(relativePath string, isDir bool) bool {
+	return !c.Selection.Synced(relativePath, isDir)
+}
+
+// EvictResult is the result of removal of the local copy of the file.
+type EvictResult struct {
+	Path  string
+	Error string `json:",omitempty"`
+}
+
+// SetSynced switches the directory between synced and cloud only.
+//
+// Files of synced directory are downloaded. Local copies of files
+// in cloud only directory are removed, unless they have changes
+// that are not uploaded yet. Result of removal of each file is returned.
+func (c *Client) SetSynced(relativeDirPath string, synced bool) ([]EvictResult, error) {
+	relativeDirPath = strings.Trim(relativeDirPath, "/")
+
+	if err := c.Selection.Set(relativeDirPath, synced); err != nil {
+		return nil, err
+	}
+
+	if synced {
+		c.DownloadRemoteFiles()
+		return nil, nil
+	}
+
+	prefix := relativeDirPath
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var results []EvictResult
+	for _, relativePath := range c.dirFiles(prefix) {
+		if _, err := os.Stat(c.AbsPath(relativePath)); err != nil {
+			continue
+		}
+
+		result := EvictResult{Path: relativePath}
+		if err := c.evictFile(relativePath); err != nil {
+			result.Error = err.Error()
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// evictFile removes local copy of the file if it has the same content as the remote one.
+func (c *Client) evictFile(relativePath string) error {
+	file, ok := c.FindFile(relativePath)
+	if !ok || !sameContent(c.AbsPath(relativePath), file.Hash) {
+		return ErrLocalChanges
+	}
+
+	if err := os.Remove(c.AbsPath(relativePath)); err != nil {
+		return err
+	}
+
+	c.State.Delete(relativePath)
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/ffenix113/teleporter/tasks"
+)
+
+type Callback struct {
+	tasks.Task
+	done func(task tasks.Task)
+}
+
+// WithCallback will execute callback when task is finished,
+// which is when it is done, cancelled or failed without further retries.
+func WithCallback(task tasks.Task, callback func(task tasks.Task)) Callback {
+	return Callback{
+		Task: task,
+		done: callback,
+	}
+}
+
+func (c Callback) Finished() {
+	c.done(c.Task)
+}
+
+func (c Callback) Retry(at time.Time) bool {
+	retryable, ok := c.Task.(tasks.Retryable)
+
+	return ok && retryable.Retry(at)
+}
+
+func (c Callback) RetryAfter() (time.Duration, bool) {
+	if retryable, ok := c.Task.(tasks.Retryable); ok {
+		return retryable.RetryAfter()
+	}
+
+	return 0, false
+}
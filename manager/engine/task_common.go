@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ffenix113/teleporter/encryption"
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/tasks"
+)
+
+// permanentErrors are errors of the client that will not go away
+// if the task is run again.
+var permanentErrors = []error{
+	encryption.ErrNoKey,
+	ErrVersionNotFound,
+	ErrTrashEntryNotFound,
+	ErrPathExists,
+}
+
+type Common struct {
+	Client   *Client
+	taskType string
+
+	// mu guards fields below, as they are changed by the worker
+	// while monitor reads them through accessors.
+	mu        sync.Mutex
+	status    tasks.TaskStatus
+	progress  int
+	details   string
+	err       error
+	attempts  int
+	nextRetry time.Time
+}
+
+func NewCommon(cl *Client, taskType string, status tasks.TaskStatus, details string) *Common {
+	return &Common{
+		Client:   cl,
+		taskType: taskType,
+		status:   status,
+		details:  details,
+		progress: 100,
+	}
+}
+
+func getCaller() string {
+	_, file, line, ok := runtime.Caller(2)
+	if !ok {
+		return "<noLine>"
+	}
+
+	return file + ":" + strconv.Itoa(line)
+}
+
+// SetInProgress marks the start of the next attempt to run the task.
+func (c *Common) SetInProgress() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.attempts++
+	c.progress = 0
+	c.err = nil
+	c.nextRetry = time.Time{}
+	c.status = tasks.TaskStatusInProgress
+}
+
+func (c *Common) SetError(err error) {
+	caller := getCaller()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.progress = 100
+	c.status = tasks.TaskStatusError
+	c.err = err
+	c.details = caller + ": " + err.Error()
+}
+
+func (c *Common) SetDone() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.progress = 100
+	c.status = tasks.TaskStatusDone
+}
+
+func (c *Common) Type() string {
+	return c.taskType
+}
+
+func (c *Common) Progress() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.progress
+}
+
+func (c *Common) Status() tasks.TaskStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status
+}
+
+func (c *Common) Details() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.details
+}
+
+// lastError returns error of the last attempt to run the task, if it failed.
+func (c *Common) lastError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+func (c *Common) Attempts() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.attempts
+}
+
+func (c *Common) NextRetry() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.nextRetry
+}
+
+// Retry schedules the task to run again, unless it failed
+// with error that will not go away on retry.
+func (c *Common) Retry(at time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !isTransient(c.err) {
+		return false
+	}
+
+	c.status = tasks.TaskStatusNew
+	c.nextRetry = at
+
+	return true
+}
+
+// RetryAfter returns the delay that backend demanded,
+// if task failed because of rate limiting.
+func (c *Common) RetryAfter() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return manager.RetryAfter(c.err)
+}
+
+// setProgress sets progress of the running task in percents.
+func (c *Common) setProgress(progress int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.progress = progress
+}
+
+// setDetails sets details of the task that are shown to the user.
+func (c *Common) setDetails(details string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.details = details
+}
+
+// isTransient reports whether task that failed with err may succeed if it is run again.
+func isTransient(err error) bool {
+	for _, permanent := range permanentErrors {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+
+	return manager.IsTransient(err)
+}
+
+func detailsOrEmpty(strs ...string) string {
+	if len(strs) == 0 {
+		return ""
+	}
+
+	return strs[0]
+}
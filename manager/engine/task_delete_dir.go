@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// deleteBatchSize is the max number of messages deleted by one request.
+const deleteBatchSize = 100
+
+// DeleteResult is the result of deletion of one file.
+type DeleteResult struct {
+	Path  string
+	Error string `json:",omitempty"`
+}
+
+type DeleteDir struct {
+	*Common
+	RelativeDirPath string
+	// Results holds result for each file in the directory after task is done.
+	Results []DeleteResult
+}
+
+func NewDeleteDir(cl *Client, dirPath string) *DeleteDir {
+	return &DeleteDir{
+		Common: &Common{
+			Client:   cl,
+			taskType: "DeleteDir",
+		},
+		// Add slash to signify that it is a directory.
+		RelativeDirPath: strings.TrimSuffix(cl.RelativePath(dirPath), "/") + "/",
+	}
+}
+
+func (d *DeleteDir) Name() string {
+	return d.RelativeDirPath
+}
+
+func (d *DeleteDir) Run(ctx context.Context) {
+	d.SetInProgress()
+
+	if d.RelativeDirPath == "/" {
+		d.SetError(fmt.Errorf("root directory can not be deleted"))
+		return
+	}
+
+	// Remove local files first, same as DeleteFile does.
+	if err := os.RemoveAll(d.Client.AbsPath(d.RelativeDirPath)); err != nil {
+		d.SetError(fmt.Errorf("remove local dir: %w", err))
+		return
+	}
+
+	files := d.Client.dirFiles(d.RelativeDirPath)
+	d.Results = make([]DeleteResult, 0, len(files))
+
+	var failed int
+	var batchFiles []string
+	var batch []int64
+	flush := func() {
+		if len(batchFiles) == 0 {
+			return
+		}
+
+		var err error
+		if !d.Client.trashEnabled() {
+			err = d.deleteMessages(ctx, batch)
+		}
+
+		for _, filePath := range batchFiles {
+			result := DeleteResult{Path: filePath}
+			switch {
+			case err != nil:
+				result.Error = err.Error()
+				failed++
+			case d.Client.trashEnabled():
+				d.Client.trashFile(filePath)
+			default:
+				d.Client.removeFile(filePath)
+			}
+
+			d.Results = append(d.Results, result)
+		}
+
+		d.setProgress(len(d.Results) * 100 / len(files))
+		batchFiles, batch = batchFiles[:0], batch[:0]
+	}
+
+	for _, filePath := range files {
+		msgIDs := append(d.Client.fileMessageIDs(filePath), d.Client.fileVersionsMessageIDs(filePath)...)
+		if len(batch)+len(msgIDs) > deleteBatchSize {
+			flush()
+		}
+
+		batchFiles = append(batchFiles, filePath)
+		batch = append(batch, msgIDs...)
+	}
+	flush()
+
+	// Remove directory itself, as it has no files in header anymore.
+	if failed == 0 {
+		d.Client.removeDir(d.RelativeDirPath)
+	}
+
+	if err := d.Client.SendHeader(ctx); err != nil {
+		d.SetError(err)
+		return
+	}
+
+	if failed != 0 {
+		d.SetError(fmt.Errorf("failed to delete %d of %d files", failed, len(files)))
+		return
+	}
+
+	d.setDetails(fmt.Sprintf("deleted %d files", len(files)))
+	d.SetDone()
+}
+
+// deleteMessages deletes messages by requests of at most deleteBatchSize of them,
+// as one file can have more messages than that.
+func (d *DeleteDir) deleteMessages(ctx context.Context, msgIDs []int64) error {
+	for len(msgIDs) > 0 {
+		n := deleteBatchSize
+		if len(msgIDs) < n {
+			n = len(msgIDs)
+		}
+
+		if err := d.Client.Backend.Delete(ctx, msgIDs[:n]...); err != nil {
+			return err
+		}
+
+		msgIDs = msgIDs[n:]
+	}
+
+	return nil
+}
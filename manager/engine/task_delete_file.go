@@ -1,4 +1,4 @@
-package arman92
+package engine
 
 import (
 	"context"
@@ -6,8 +6,6 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
-
-	"github.com/ffenix113/teleporter/tasks"
 )
 
 type DeleteFile struct {
@@ -30,14 +28,16 @@ func (f *DeleteFile) Name() string {
 }
 
 func (f *DeleteFile) Run(ctx context.Context) {
-	f.status = tasks.TaskStatusInProgress
+	f.SetInProgress()
 
-	msgID, ok := f.Client.PinnedHeader.Files[f.RelativePath]
-	if !ok {
+	msgIDs := f.Client.fileMessageIDs(f.RelativePath)
+	if len(msgIDs) == 0 {
 		f.SetError(fmt.Errorf("file is not present in header: %q", f.RelativePath))
 		return
 	}
 
+	msgIDs = append(msgIDs, f.Client.fileVersionsMessageIDs(f.RelativePath)...)
+
 	// Remove physical file fist so if this fails - we will be able to re-fetch file later.
 	absFilePath := f.Client.AbsPath(f.RelativePath)
 	_, err := os.Stat(absFilePath)
@@ -48,17 +48,22 @@ func (f *DeleteFile) Run(ctx context.Context) {
 		}
 	}
 
-	// It is safer to have deleted file and entry left in header
-	// than the other way around. If header entry will be missing for a file
-	// files will be leaking.
-	_, err = f.Client.TDClient.DeleteMessages(f.Client.chatID, []int64{msgID}, true)
-	if err != nil {
-		f.SetError(err)
-		return
+	if f.Client.trashEnabled() {
+		// Messages will be deleted when trash retention ends.
+		f.Client.trashFile(f.RelativePath)
+	} else {
+		// It is safer to have deleted file and entry left in header
+		// than the other way around. If header entry will be missing for a file
+		// files will be leaking.
+		err = f.Client.Backend.Delete(ctx, msgIDs...)
+		if err != nil {
+			f.SetError(err)
+			return
+		}
+
+		f.Client.removeFile(f.RelativePath)
 	}
 
-	delete(f.Client.PinnedHeader.Files, f.RelativePath)
-	f.Client.FileTree.Delete(f.RelativePath)
 	if err := f.Client.SendHeader(ctx); err != nil {
 		f.SetError(err)
 		return
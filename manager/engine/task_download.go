@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/tasks"
+)
+
+type DownloadFile struct {
+	*Common
+	RelativePath string
+	// version is the ID of previous version of the file to download.
+	// Latest version is downloaded if it is zero.
+	version int64
+}
+
+// NewDownloadFile will return a download file task.
+//
+// filePath can be absolute or relative.
+func NewDownloadFile(cl *Client, filePath string, details ...string) *DownloadFile {
+	return &DownloadFile{
+		Common: &Common{
+			Client:   cl,
+			taskType: "DownloadFile",
+			details:  detailsOrEmpty(details...),
+		},
+		RelativePath: cl.RelativePath(filePath),
+	}
+}
+
+// NewRestoreVersion will return a task that replaces local file
+// with its previous version and uploads it as the latest version.
+func NewRestoreVersion(cl *Client, filePath string, versionID int64) *DownloadFile {
+	return &DownloadFile{
+		Common: &Common{
+			Client:   cl,
+			taskType: "RestoreVersion",
+			details:  fmt.Sprintf("restore version %d", versionID),
+		},
+		RelativePath: cl.RelativePath(filePath),
+		version:      versionID,
+	}
+}
+
+func (f *DownloadFile) Name() string {
+	return f.RelativePath
+}
+
+func (f *DownloadFile) Run(ctx context.Context) {
+	f.SetInProgress()
+
+	msgIDs := f.Client.fileMessageIDs(f.RelativePath)
+	if f.version != 0 {
+		msgIDs = f.Client.versionMessageIDs(f.RelativePath, f.version)
+	}
+
+	if len(msgIDs) == 0 {
+		f.SetError(fmt.Errorf("file %q is not present in remote chat", f.RelativePath))
+		return
+	}
+
+	if remote, ok := f.Client.FindFile(f.RelativePath); ok && f.version == 0 && sameContent(f.Client.AbsPath(f.RelativePath), remote.Hash) {
+		f.Client.markSynced(f.RelativePath, msgIDs[0], remote.Hash)
+		f.setDetails("content is not changed")
+		f.SetDone()
+		return
+	}
+
+	var filePath string
+	var fileInfo manager.File
+	var err error
+	if len(msgIDs) == 1 {
+		filePath, fileInfo, err = f.downloadSingle(ctx, msgIDs[0])
+	} else {
+		filePath, fileInfo, err = f.downloadParts(ctx, msgIDs)
+	}
+
+	if err != nil {
+		f.SetError(err)
+		return
+	}
+
+	// Files uploaded before hashes were introduced can not be verified.
+	if fileInfo.Hash != "" {
+		hash, err := fileHash(filePath)
+		if err == nil && hash != fileInfo.Hash {
+			err = fmt.Errorf("downloaded file is corrupted: hash mismatch: want: %s, got: %s", fileInfo.Hash, hash)
+		}
+
+		if err != nil {
+			os.Remove(filePath)
+			f.SetError(err)
+			return
+		}
+	}
+
+	if err := os.MkdirAll(path.Dir(f.Client.AbsPath(f.RelativePath)), os.ModeDir|0755); err != nil {
+		f.SetError(err)
+		return
+	}
+
+	if err := os.Rename(filePath, f.Client.AbsPath(f.RelativePath)); err != nil {
+		f.SetError(fmt.Errorf("move file: %w", err))
+		return
+	}
+
+	// Keep modification time of the original file, so it will not look newer than remote.
+	// Restored version is a new change, so it keeps current time.
+	if !fileInfo.FileUpdatedAt.IsZero() && f.version == 0 {
+		if err := os.Chtimes(f.Client.AbsPath(f.RelativePath), time.Now(), fileInfo.FileUpdatedAt); err != nil {
+			f.SetError(fmt.Errorf("set modification time: %w", err))
+			return
+		}
+	}
+
+	if f.version == 0 {
+		f.Client.markSynced(f.RelativePath, msgIDs[0], fileInfo.Hash)
+		f.SetDone()
+		return
+	}
+
+	// Local file now differs from the remote one. It is uploaded by the same task,
+	// so task is finished only after the header points to the restored content.
+	upload := NewUploadFile(f.Client, f.Client.AbsPath(f.RelativePath), "restored version")
+	upload.Run(ctx)
+	if upload.Status() == tasks.TaskStatusError {
+		f.SetError(fmt.Errorf("upload restored version: %w", upload.lastError()))
+		return
+	}
+
+	f.SetDone()
+}
+
+// Retry prepares task to be run again. Restore of the version is not retried,
+// as its result is reported once to the caller that requested it.
+func (f *DownloadFile) Retry(at time.Time) bool {
+	if f.version != 0 {
+		return false
+	}
+
+	return f.Common.Retry(at)
+}
+
+func (f *DownloadFile) downloadSingle(ctx context.Context, msgID int64) (string, manager.File, error) {
+	filePath, caption, err := f.Client.Backend.Get(ctx, msgID, func(progress int) {
+		f.setProgress(progress)
+	})
+	if err != nil {
+		return "", manager.File{}, err
+	}
+
+	fileInfo, encrypted, err := f.Client.decodeFileInfo(caption)
+	if err != nil {
+		return "", manager.File{}, err
+	}
+
+	if !encrypted {
+		return filePath, fileInfo, nil
+	}
+
+	decryptedPath, err := f.Client.decryptToTemp(filePath)
+	if err != nil {
+		return "", manager.File{}, fmt.Errorf("decrypt file: %w", err)
+	}
+
+	os.Remove(filePath)
+
+	return decryptedPath, fileInfo, nil
+}
+
+// downloadParts downloads all parts of the file and
+// reassembles them into a temporary file.
+func (f *DownloadFile) downloadParts(ctx context.Context, msgIDs []int64) (string, manager.File, error) {
+	assembled, err := os.CreateTemp(f.Client.TempPath, "*.tlp")
+	if err != nil {
+		return "", manager.File{}, fmt.Errorf("create temp file: %w", err)
+	}
+	defer assembled.Close()
+
+	var fileInfo manager.File
+	var written int64
+	for i, msgID := range msgIDs {
+		partPath, caption, err := f.Client.Backend.Get(ctx, msgID, func(progress int) {
+			f.setProgress((i*100 + progress) / len(msgIDs))
+		})
+		if err != nil {
+			os.Remove(assembled.Name())
+			return "", manager.File{}, fmt.Errorf("download part %d: %w", i, err)
+		}
+
+		partInfo, encrypted, err := f.Client.decodeFileInfo(caption)
+		if err != nil {
+			os.Remove(partPath)
+			os.Remove(assembled.Name())
+			return "", manager.File{}, fmt.Errorf("decode part %d info: %w", i, err)
+		}
+
+		if i == 0 {
+			fileInfo = partInfo
+		} else if partInfo.Part != i {
+			err = fmt.Errorf("part %d has wrong index: %d", i, partInfo.Part)
+		}
+
+		var n int64
+		if err == nil {
+			n, err = f.Client.copyPart(assembled, partPath, encrypted)
+		}
+		os.Remove(partPath)
+
+		if err == nil && i < len(msgIDs)-1 && n != fileInfo.PartSize {
+			err = fmt.Errorf("part %d has wrong size: want: %d, got: %d", i, fileInfo.PartSize, n)
+		}
+
+		if err != nil {
+			os.Remove(assembled.Name())
+			return "", manager.File{}, err
+		}
+
+		written += n
+	}
+
+	if written != fileInfo.Size {
+		os.Remove(assembled.Name())
+		return "", manager.File{}, fmt.Errorf("reassembled file has wrong size: want: %d, got: %d", fileInfo.Size, written)
+	}
+
+	return assembled.Name(), fileInfo, nil
+}
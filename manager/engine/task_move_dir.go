@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// MoveDir moves all uploaded files in the directory to the new directory.
+type MoveDir struct {
+	*Common
+	OldRelativeDirPath string
+	RelativeDirPath    string
+}
+
+func NewMoveDir(cl *Client, oldDirPath, dirPath string) *MoveDir {
+	// Add slash to signify that it is a directory.
+	oldRelativeDirPath := cl.RelativePath(oldDirPath) + "/"
+
+	return &MoveDir{
+		Common: &Common{
+			Client:   cl,
+			taskType: "MoveDir",
+			details:  "moved from " + oldRelativeDirPath,
+		},
+		OldRelativeDirPath: oldRelativeDirPath,
+		RelativeDirPath:    cl.RelativePath(dirPath) + "/",
+	}
+}
+
+func (d *MoveDir) Name() string {
+	return d.RelativeDirPath
+}
+
+func (d *MoveDir) Source() string {
+	return d.OldRelativeDirPath
+}
+
+func (d *MoveDir) Run(ctx context.Context) {
+	d.SetInProgress()
+
+	files := d.Client.dirFiles(d.OldRelativeDirPath)
+
+	var replacedMsgIDs []int64
+	for i, oldPath := range files {
+		newPath := d.RelativeDirPath + strings.TrimPrefix(oldPath, d.OldRelativeDirPath)
+		var replaced []int64
+		if !d.Client.trashEnabled() {
+			replaced = append(d.Client.fileMessageIDs(newPath), d.Client.fileVersionsMessageIDs(newPath)...)
+		}
+
+		file, err := d.Client.renameMessages(ctx, oldPath, newPath)
+		if err != nil {
+			// Save files that were already moved, next attempt will move the rest.
+			if i != 0 {
+				if err := d.Client.SendHeader(ctx); err != nil {
+					log.Printf("send header of partially moved dir: %s\n", err.Error())
+				}
+			}
+
+			d.SetError(fmt.Errorf("move %q: %w", oldPath, err))
+			return
+		}
+
+		// Replaced file is kept in trash, same as deleted one.
+		if d.Client.trashEnabled() {
+			d.Client.trashFile(newPath)
+		}
+		d.Client.moveFile(oldPath, newPath, &file)
+		replacedMsgIDs = append(replacedMsgIDs, replaced...)
+		d.setProgress((i + 1) * 100 / len(files))
+	}
+
+	// Only empty directories are left in the tree now.
+	d.Client.removeDir(d.OldRelativeDirPath)
+
+	if err := d.Client.SendHeader(ctx); err != nil {
+		d.SetError(err)
+		return
+	}
+
+	if len(replacedMsgIDs) != 0 {
+		if err := d.Client.Backend.Delete(ctx, replacedMsgIDs...); err != nil {
+			d.SetError(fmt.Errorf("delete replaced files: %w", err))
+			return
+		}
+	}
+
+	d.SetDone()
+}
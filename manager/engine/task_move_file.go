@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/ffenix113/teleporter/manager"
+)
+
+// MoveFile moves already uploaded file to the new path.
+//
+// Only captions of the file messages and the header are updated,
+// content of the file is not uploaded again.
+type MoveFile struct {
+	*Common
+	OldRelativePath string
+	RelativePath    string
+}
+
+func NewMoveFile(cl *Client, oldFilePath, filePath string) *MoveFile {
+	oldRelativePath := cl.RelativePath(oldFilePath)
+
+	return &MoveFile{
+		Common: &Common{
+			Client:   cl,
+			taskType: "MoveFile",
+			details:  "moved from " + oldRelativePath,
+		},
+		OldRelativePath: oldRelativePath,
+		RelativePath:    cl.RelativePath(filePath),
+	}
+}
+
+func (f *MoveFile) Name() string {
+	return f.RelativePath
+}
+
+func (f *MoveFile) Source() string {
+	return f.OldRelativePath
+}
+
+func (f *MoveFile) Run(ctx context.Context) {
+	f.SetInProgress()
+
+	if _, ok := f.Client.HeaderFile(f.OldRelativePath); !ok {
+		if _, ok := f.Client.HeaderFile(f.RelativePath); ok {
+			// File was moved by previous attempt, but header was not sent.
+			if err := f.Client.SendHeader(ctx); err != nil {
+				f.SetError(err)
+				return
+			}
+
+			f.SetDone()
+			return
+		}
+
+		// Nothing to move, so file will be uploaded from scratch.
+		f.Client.AddTask(NewUploadFile(f.Client, f.Client.AbsPath(f.RelativePath), "moved file was not uploaded"))
+		f.SetDone()
+		return
+	}
+
+	var replacedMsgIDs []int64
+	if !f.Client.trashEnabled() {
+		replacedMsgIDs = append(f.Client.fileMessageIDs(f.RelativePath), f.Client.fileVersionsMessageIDs(f.RelativePath)...)
+	}
+
+	file, err := f.Client.renameMessages(ctx, f.OldRelativePath, f.RelativePath)
+	if err != nil {
+		f.SetError(err)
+		return
+	}
+
+	// Replaced file is kept in trash, same as deleted one.
+	if f.Client.trashEnabled() {
+		f.Client.trashFile(f.RelativePath)
+	}
+	f.Client.moveFile(f.OldRelativePath, f.RelativePath, &file)
+	if err := f.Client.SendHeader(ctx); err != nil {
+		f.SetError(err)
+		return
+	}
+
+	if len(replacedMsgIDs) != 0 {
+		if err := f.Client.Backend.Delete(ctx, replacedMsgIDs...); err != nil {
+			f.SetError(fmt.Errorf("delete replaced file: %w", err))
+			return
+		}
+	}
+
+	f.SetDone()
+}
+
+// renameMessages rewrites captions of the file messages,
+// so they point to the new path. It returns updated file info.
+func (c *Client) renameMessages(ctx context.Context, oldPath, newPath string) (manager.File, error) {
+	msgIDs := c.fileMessageIDs(oldPath)
+	if len(msgIDs) == 0 {
+		return manager.File{}, fmt.Errorf("file is not present in header: %q", oldPath)
+	}
+
+	file, ok := c.FindFile(oldPath)
+	if !ok {
+		var err error
+		if file, err = c.GetFileDataByMsgID(ctx, msgIDs[0]); err != nil {
+			return manager.File{}, err
+		}
+	}
+
+	file.Name = path.Base(newPath)
+	file.Path = newPath
+
+	for i, msgID := range msgIDs {
+		partInfo := manager.File{Path: newPath, Part: i}
+		if i == 0 {
+			partInfo = file
+		}
+
+		d, err := c.encodeFileInfo(partInfo)
+		if err != nil {
+			return manager.File{}, err
+		}
+
+		if err := c.Backend.SetCaption(ctx, msgID, string(d)); err != nil {
+			return manager.File{}, fmt.Errorf("edit caption of part %d: %w", i, err)
+		}
+	}
+
+	return file, nil
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// PruneVersions deletes messages of versions
+// that are kept for longer than retention period.
+type PruneVersions struct {
+	*Common
+}
+
+func NewPruneVersions(cl *Client) *PruneVersions {
+	return &PruneVersions{
+		Common: &Common{
+			Client:   cl,
+			taskType: "PruneVersions",
+		},
+	}
+}
+
+func (p *PruneVersions) Name() string {
+	return "versions"
+}
+
+func (p *PruneVersions) Run(ctx context.Context) {
+	p.SetInProgress()
+
+	msgIDs := p.Client.pruneVersions()
+	if len(msgIDs) == 0 {
+		p.SetDone()
+		return
+	}
+
+	// Header must not point to messages that are deleted.
+	if err := p.Client.SendHeader(ctx); err != nil {
+		p.SetError(err)
+		return
+	}
+
+	if err := p.Client.Backend.Delete(ctx, msgIDs...); err != nil {
+		p.SetError(fmt.Errorf("delete expired versions: %w", err))
+		return
+	}
+
+	p.setDetails(fmt.Sprintf("deleted %d messages", len(msgIDs)))
+	p.SetDone()
+}
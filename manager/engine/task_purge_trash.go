@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PurgeTrash deletes messages of files that are in trash
+// for longer than retention period, or of all files in trash.
+type PurgeTrash struct {
+	*Common
+	all bool
+}
+
+func NewPurgeTrash(cl *Client, all bool) *PurgeTrash {
+	return &PurgeTrash{
+		Common: &Common{
+			Client:   cl,
+			taskType: "PurgeTrash",
+		},
+		all: all,
+	}
+}
+
+func (p *PurgeTrash) Name() string {
+	return "trash"
+}
+
+// Args keeps emptying of the whole trash when task is restored from the journal.
+func (p *PurgeTrash) Args() map[string]string {
+	if !p.all {
+		return nil
+	}
+
+	return map[string]string{"all": "true"}
+}
+
+func (p *PurgeTrash) Run(ctx context.Context) {
+	p.SetInProgress()
+
+	before := time.Now().Add(-p.Client.TrashRetention)
+	if p.all {
+		before = time.Now()
+	}
+
+	expired := p.Client.expiredTrash(before)
+	for i, entry := range expired {
+		msgIDs := append([]int64{entry.ID}, entry.Parts...)
+		msgIDs = append(msgIDs, versionsMessageIDs(entry.Versions)...)
+
+		if err := p.Client.Backend.Delete(ctx, msgIDs...); err != nil {
+			if i != 0 {
+				if err := p.Client.SendHeader(ctx); err != nil {
+					p.SetError(fmt.Errorf("send header: %w", err))
+					return
+				}
+			}
+
+			p.SetError(fmt.Errorf("delete %q: %w", entry.Path, err))
+			return
+		}
+
+		p.Client.takeTrash(entry.ID)
+		p.setProgress((i + 1) * 100 / len(expired))
+	}
+
+	if len(expired) != 0 {
+		if err := p.Client.SendHeader(ctx); err != nil {
+			p.SetError(err)
+			return
+		}
+	}
+
+	p.setDetails(fmt.Sprintf("purged %d files", len(expired)))
+	p.SetDone()
+}
@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ResolveConflict resolves file that was changed both locally
+// and remotely since the last synchronization.
+type ResolveConflict struct {
+	*Common
+	RelativePath string
+	Policy       string
+}
+
+func NewResolveConflict(cl *Client, filePath string, policy string) *ResolveConflict {
+	return &ResolveConflict{
+		Common: &Common{
+			Client:   cl,
+			taskType: "ResolveConflict",
+			details:  policy,
+		},
+		RelativePath: cl.RelativePath(filePath),
+		Policy:       policy,
+	}
+}
+
+func (r *ResolveConflict) Name() string {
+	return r.RelativePath
+}
+
+func (r *ResolveConflict) Run(_ context.Context) {
+	r.SetInProgress()
+
+	absPath := r.Client.AbsPath(r.RelativePath)
+
+	switch r.Policy {
+	case ConflictPreferLocal:
+		r.Client.rebaseOnRemote(r.RelativePath)
+		r.Client.AddTask(NewUploadFile(r.Client, absPath, "conflict resolved: prefer local"))
+		r.setDetails("local file will be uploaded")
+	case ConflictPreferRemote:
+		r.Client.AddTask(NewDownloadFile(r.Client, r.RelativePath, "conflict resolved: prefer remote"))
+		r.setDetails("remote file will be downloaded")
+	case ConflictKeepBoth:
+		conflictPath := r.Client.conflictPath(r.RelativePath, time.Now())
+		// Copy instead of rename, so copy is not detected as moved file.
+		if err := copyFile(r.Client.AbsPath(conflictPath), absPath); err != nil {
+			r.SetError(fmt.Errorf("keep local copy: %w", err))
+			return
+		}
+
+		r.Client.AddTask(NewUploadFile(r.Client, r.Client.AbsPath(conflictPath), "conflict copy"))
+		r.Client.AddTask(NewDownloadFile(r.Client, r.RelativePath, "conflict resolved: keep both"))
+		r.setDetails("local file is kept as " + conflictPath)
+	default:
+		r.SetError(fmt.Errorf("unknown conflict policy: %q", r.Policy))
+		return
+	}
+
+	r.SetDone()
+}
+
+// copyFile copies file content and modification time.
+func copyFile(dstPath, srcPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, stat.Mode())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dstPath)
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(dstPath, time.Now(), stat.ModTime())
+}
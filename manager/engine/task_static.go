@@ -1,6 +1,9 @@
-package arman92
+package engine
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type StaticTask struct {
 	*Common
@@ -19,3 +22,8 @@ func (t StaticTask) Name() string {
 }
 
 func (StaticTask) Run(_ context.Context) {}
+
+// Retry will not retry static task, as it only holds the result.
+func (StaticTask) Retry(_ time.Time) bool {
+	return false
+}
@@ -0,0 +1,284 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ffenix113/teleporter/manager"
+)
+
+type UploadFile struct {
+	*Common
+	RelativePath  string
+	FileUpdatedAt time.Time
+}
+
+func NewUploadFile(cl *Client, filePath string, description ...string) *UploadFile {
+	task := &UploadFile{
+		Common: &Common{
+			Client:   cl,
+			taskType: "UploadFile",
+			details:  detailsOrEmpty(description...),
+		},
+		RelativePath: cl.RelativePath(filePath),
+	}
+
+	// File may be already removed, which will be reported when task will run.
+	if stat, err := os.Stat(filePath); err == nil {
+		task.FileUpdatedAt = stat.ModTime()
+	}
+
+	return task
+}
+
+func (f *UploadFile) Name() string {
+	return f.RelativePath
+}
+
+func (f *UploadFile) Run(ctx context.Context) {
+	f.SetInProgress()
+
+	filePath := f.Client.AbsPath(f.RelativePath)
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		f.SetError(err)
+		return
+	}
+
+	hash, err := fileHash(filePath)
+	if err != nil {
+		f.SetError(err)
+		return
+	}
+
+	if msgID, ok := f.Client.HeaderFile(f.RelativePath); ok {
+		// File info may be changed by other client without changing the header.
+		remote, err := f.Client.GetFileDataByMsgID(ctx, msgID)
+		if err != nil {
+			f.SetError(fmt.Errorf("get remote file info: %w", err))
+			return
+		}
+		remote.Name = filepath.Base(remote.Path)
+		f.Client.setFileInfo(f.RelativePath, &remote)
+
+		if remote.Hash == hash {
+			f.Client.markSynced(f.RelativePath, msgID, hash)
+			f.setDetails("content is not changed")
+			f.SetDone()
+			return
+		}
+
+		// File changed remotely since last synchronization would be overwritten.
+		if base, ok := f.Client.State.Get(f.RelativePath); ok && remote.Hash != "" && remote.Hash != base.Hash {
+			f.Client.AddTask(NewResolveConflict(f.Client, f.RelativePath, f.Client.ConflictPolicy))
+			f.setDetails("changed remotely, conflict will be resolved")
+			f.SetDone()
+			return
+		}
+	}
+
+	_, exists := f.Client.HeaderFile(f.RelativePath)
+	// With versioning, changed file is uploaded as new messages,
+	// so previous version is kept in the chat.
+	if f.Client.IsChunked(f.RelativePath) || f.Client.partsCount(stat.Size()) > 1 || exists && f.Client.versioning() {
+		f.UploadParts(ctx, stat, hash)
+		return
+	}
+
+	if _, ok := f.Client.HeaderFile(f.RelativePath); ok {
+		f.UpdateFile(ctx, stat, hash)
+		return
+	}
+
+	uploadPath, cleanup, err := f.uploadPath(filePath)
+	if err != nil {
+		f.SetError(err)
+		return
+	}
+	defer cleanup()
+
+	fileInfo := manager.File{
+		Name:          filepath.Base(f.RelativePath),
+		Path:          f.RelativePath,
+		Size:          stat.Size(),
+		UploadedAt:    time.Now(),
+		FileUpdatedAt: stat.ModTime(),
+		Hash:          hash,
+	}
+
+	d, err := f.Client.encodeFileInfo(fileInfo)
+	if err != nil {
+		f.SetError(err)
+		return
+	}
+
+	msgID, err := f.Client.Backend.Put(ctx, uploadPath, string(d), f.watchUpload(0, 1))
+	if err != nil {
+		f.SetError(fmt.Errorf("upload file: %w", err))
+		return
+	}
+
+	f.Client.setFile(f.RelativePath, []int64{msgID}, &fileInfo)
+	if err := f.Client.SendHeader(ctx); err != nil {
+		f.SetError(err)
+		return
+	}
+	f.Client.markSynced(f.RelativePath, msgID, hash)
+
+	f.SetDone()
+}
+
+// UpdateFile replaces content of the file uploaded in a single message.
+// Stat is the state of the local file, taken when the task started.
+func (f *UploadFile) UpdateFile(ctx context.Context, stat os.FileInfo, hash string) {
+	msgID, ok := f.Client.HeaderFile(f.RelativePath)
+	if !ok {
+		f.SetError(fmt.Errorf("file not present in the header: %q", f.RelativePath))
+		return
+	}
+
+	file, ok := f.Client.FindFile(f.RelativePath)
+	if !ok {
+		f.SetError(fmt.Errorf("file not present in the file tree: %q", f.RelativePath))
+		return
+	}
+
+	filePath := f.Client.AbsPath(f.RelativePath)
+	file.Size = stat.Size()
+	file.FileUpdatedAt = stat.ModTime()
+	file.Hash = hash
+
+	d, err := f.Client.encodeFileInfo(file)
+	if err != nil {
+		f.SetError(err)
+		return
+	}
+
+	uploadPath, cleanup, err := f.uploadPath(filePath)
+	if err != nil {
+		f.SetError(err)
+		return
+	}
+	defer cleanup()
+
+	err = f.Client.Backend.Replace(ctx, msgID, uploadPath, string(d), f.watchUpload(0, 1))
+	if err != nil {
+		f.SetError(fmt.Errorf("upload file: %w", err))
+		return
+	}
+	// Header does not need to be updated after update of a file.
+	f.Client.setFileInfo(f.RelativePath, &file)
+	f.Client.markSynced(f.RelativePath, msgID, hash)
+	f.SetDone()
+}
+
+// uploadPath returns path of the file that should be uploaded.
+//
+// If encryption is enabled - file will be encrypted into temporary file,
+// which will be removed by returned cleanup function.
+func (f *UploadFile) uploadPath(filePath string) (string, func(), error) {
+	if f.Client.Cipher == nil {
+		return filePath, func() {}, nil
+	}
+
+	encryptedPath, err := f.Client.encryptToTemp(filePath)
+	if err != nil {
+		return "", nil, fmt.Errorf("encrypt file: %w", err)
+	}
+
+	return encryptedPath, func() { os.Remove(encryptedPath) }, nil
+}
+
+// UploadParts uploads file as a set of parts, each in its own message.
+//
+// It is also used to replace already uploaded file, in which case
+// old messages are removed after header is updated, or kept
+// as a previous version if versioning is enabled.
+func (f *UploadFile) UploadParts(ctx context.Context, stat os.FileInfo, hash string) {
+	filePath := f.Client.AbsPath(f.RelativePath)
+	partsCount := f.Client.partsCount(stat.Size())
+
+	fileInfo := manager.File{
+		Name:          filepath.Base(f.RelativePath),
+		Path:          f.RelativePath,
+		Size:          stat.Size(),
+		UploadedAt:    time.Now(),
+		FileUpdatedAt: stat.ModTime(),
+		Hash:          hash,
+	}
+	if partsCount > 1 {
+		fileInfo.PartSize = f.Client.PartSize
+	}
+
+	msgIDs := make([]int64, 0, partsCount)
+	for i := 0; i < partsCount; i++ {
+		partInfo := manager.File{Path: f.RelativePath, Part: i}
+		if i == 0 {
+			partInfo = fileInfo
+		}
+
+		msgID, err := f.uploadPart(ctx, filePath, partInfo, partsCount)
+		if err != nil {
+			// Context may be already cancelled, but uploaded parts should be removed anyway.
+			f.Client.Backend.Delete(context.Background(), msgIDs...)
+			f.SetError(fmt.Errorf("upload part %d: %w", i, err))
+			return
+		}
+
+		msgIDs = append(msgIDs, msgID)
+	}
+
+	oldMsgIDs := f.Client.fileMessageIDs(f.RelativePath)
+	oldFile, _ := f.Client.FindFile(f.RelativePath)
+
+	f.Client.setFile(f.RelativePath, msgIDs, &fileInfo)
+	if len(oldMsgIDs) != 0 && f.Client.versioning() {
+		oldMsgIDs = f.Client.addVersion(f.RelativePath, manager.Version{
+			ID:         oldMsgIDs[0],
+			Parts:      oldMsgIDs[1:],
+			Size:       oldFile.Size,
+			UploadedAt: oldFile.UploadedAt,
+		})
+	}
+
+	if err := f.Client.SendHeader(ctx); err != nil {
+		f.SetError(err)
+		return
+	}
+	f.Client.markSynced(f.RelativePath, msgIDs[0], hash)
+
+	if len(oldMsgIDs) != 0 {
+		if err := f.Client.Backend.Delete(ctx, oldMsgIDs...); err != nil {
+			f.SetError(fmt.Errorf("delete previous version: %w", err))
+			return
+		}
+	}
+
+	f.SetDone()
+}
+
+func (f *UploadFile) uploadPart(ctx context.Context, filePath string, partInfo manager.File, partsCount int) (int64, error) {
+	d, err := f.Client.encodeFileInfo(partInfo)
+	if err != nil {
+		return 0, err
+	}
+
+	partPath, err := f.Client.writePart(filePath, partInfo.Part)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(partPath)
+
+	return f.Client.Backend.Put(ctx, partPath, string(d), f.watchUpload(partInfo.Part, partsCount))
+}
+
+// watchUpload returns a function that tracks upload progress
+// of the part with provided index out of partsCount parts.
+func (f *UploadFile) watchUpload(part, partsCount int) func(int) {
+	return func(partProgress int) {
+		f.setProgress((part*100 + partProgress) / partsCount)
+	}
+}
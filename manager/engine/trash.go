@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/tasks"
+)
+
+const (
+	DefaultTrashRetention = 30 * 24 * time.Hour
+	// trashPurgeInterval is how often trash is checked for expired files.
+	trashPurgeInterval = time.Hour
+)
+
+var (
+	ErrTrashEntryNotFound = errors.New("trash entry not found")
+	ErrPathExists         = errors.New("file with the same path exists")
+)
+
+// trashEnabled reports whether deleted files are moved to trash.
+func (c *Client) trashEnabled() bool {
+	return c.TrashRetention > 0
+}
+
+// Trash returns a copy of deleted files in trash.
+func (c *Client) Trash() []manager.TrashEntry {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	return append([]manager.TrashEntry{}, c.PinnedHeader.Trash...)
+}
+
+// trashFile moves file from the header to trash.
+func (c *Client) trashFile(relativePath string) {
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	msgID, ok := c.PinnedHeader.Files[relativePath]
+	if !ok {
+		return
+	}
+
+	entry := manager.TrashEntry{
+		Path:      relativePath,
+		ID:        msgID,
+		Parts:     c.PinnedHeader.Parts[relativePath],
+		Versions:  c.PinnedHeader.Versions[relativePath],
+		DeletedAt: time.Now(),
+	}
+
+	if file, ok := manager.FindInTree[*manager.File](c.FileTree, relativePath); ok {
+		entry.Size = file.Size
+	}
+
+	c.PinnedHeader.Trash = append(c.PinnedHeader.Trash, entry)
+
+	delete(c.PinnedHeader.Files, relativePath)
+	delete(c.PinnedHeader.Parts, relativePath)
+	delete(c.PinnedHeader.Versions, relativePath)
+	c.FileTree.Delete(relativePath)
+	c.State.Delete(relativePath)
+}
+
+// expiredTrash returns entries that were deleted before provided time.
+func (c *Client) expiredTrash(before time.Time) []manager.TrashEntry {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	var expired []manager.TrashEntry
+	for _, entry := range c.PinnedHeader.Trash {
+		if entry.DeletedAt.Before(before) {
+			expired = append(expired, entry)
+		}
+	}
+
+	return expired
+}
+
+// takeTrash removes entry from trash and returns it.
+func (c *Client) takeTrash(id int64) (manager.TrashEntry, bool) {
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	for i, entry := range c.PinnedHeader.Trash {
+		if entry.ID == id {
+			c.PinnedHeader.Trash = append(c.PinnedHeader.Trash[:i], c.PinnedHeader.Trash[i+1:]...)
+			return entry, true
+		}
+	}
+
+	return manager.TrashEntry{}, false
+}
+
+// restoreTrash moves entry from trash back to the header.
+func (c *Client) restoreTrash(id int64) (manager.TrashEntry, error) {
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	for i, entry := range c.PinnedHeader.Trash {
+		if entry.ID != id {
+			continue
+		}
+
+		if _, ok := c.PinnedHeader.Files[entry.Path]; ok {
+			return manager.TrashEntry{}, ErrPathExists
+		}
+
+		c.PinnedHeader.Trash = append(c.PinnedHeader.Trash[:i], c.PinnedHeader.Trash[i+1:]...)
+
+		c.PinnedHeader.Files[entry.Path] = entry.ID
+		if len(entry.Parts) != 0 {
+			c.PinnedHeader.Parts[entry.Path] = entry.Parts
+		}
+
+		if len(entry.Versions) != 0 {
+			c.PinnedHeader.Versions[entry.Path] = entry.Versions
+		}
+
+		return entry, nil
+	}
+
+	return manager.TrashEntry{}, ErrTrashEntryNotFound
+}
+
+// RestoreFromTrash returns deleted file to its original path
+// and downloads it.
+func (c *Client) RestoreFromTrash(ctx context.Context, id int64) error {
+	entry, err := c.restoreTrash(id)
+	if err != nil {
+		return err
+	}
+
+	if file, err := c.GetFileDataByMsgID(ctx, entry.ID); err == nil {
+		file.Name = filepath.Base(file.Path)
+		c.setFileInfo(entry.Path, &file)
+	} else {
+		log.Printf("get restored file info: %s\n", err.Error())
+	}
+
+	if err := c.SendHeader(ctx); err != nil {
+		return fmt.Errorf("send header: %w", err)
+	}
+
+	c.AddTask(NewDownloadFile(c, entry.Path, "restored from trash"))
+
+	return nil
+}
+
+// EmptyTrash deletes messages of all files in trash.
+func (c *Client) EmptyTrash(ctx context.Context) error {
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var taskErr error
+	c.AddTask(WithCallback(NewPurgeTrash(c, true), func(task tasks.Task) {
+		if task.Status() == tasks.TaskStatusError {
+			taskErr = fmt.Errorf("empty trash: %s", task.Details())
+		}
+		cancel()
+	}))
+
+	<-subCtx.Done()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return taskErr
+}
+
+// purgeTrashPeriodically adds task to delete expired files from trash.
+func (c *Client) purgeTrashPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if len(c.expiredTrash(time.Now().Add(-c.TrashRetention))) != 0 {
+			c.AddTask(NewPurgeTrash(c, false))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/ffenix113/teleporter/events"
+)
+
+// headerUpdated is called by the backend when header
+// was changed by other client.
+//
+// It will update files when they will be coming.
+func (c *Client) headerUpdated(text string) {
+	header, err := c.decodeHeader(context.Background(), text)
+	if err != nil {
+		log.Println(fmt.Sprintf("decode pinned message text: %s", err.Error()))
+
+		return
+	}
+
+	c.setHeader(header)
+
+	c.DownloadRemoteFiles()
+}
+
+// connectionStateChanged is called by the backend when connection state changes.
+//
+// It keeps connection state up to date and publishes its changes.
+func (c *Client) connectionStateChanged(state string) {
+	c.connectionMu.Lock()
+	c.connectionState = state
+	c.connectionMu.Unlock()
+
+	c.Events.Publish(events.ConnectionState, map[string]string{"State": state})
+}
+
+// ConnectionState returns the last connection state reported by the backend.
+func (c *Client) ConnectionState() string {
+	c.connectionMu.Lock()
+	defer c.connectionMu.Unlock()
+
+	return c.connectionState
+}
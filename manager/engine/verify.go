@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Results of file verification.
+const (
+	VerifyOK = "ok"
+	// VerifyMissing means that file is in the header, but not on disk.
+	VerifyMissing = "missing"
+	// VerifyModified means that local file differs from the uploaded one.
+	VerifyModified = "modified"
+	// VerifyNotUploaded means that local file is not in the header.
+	VerifyNotUploaded = "not uploaded"
+	// VerifyCorrupted means that remote content does not match its hash.
+	VerifyCorrupted = "corrupted"
+	// VerifyUnknown means that hash of the remote file is not known.
+	VerifyUnknown = "unknown"
+	// VerifyCloudOnly means that file is not downloaded to this device.
+	VerifyCloudOnly = "cloud only"
+)
+
+type VerifyResult struct {
+	Path    string
+	Status  string
+	Details string `json:",omitempty"`
+}
+
+// Verify compares local files with hashes of the files in the header.
+//
+// If remote is true, remote files are downloaded to check
+// that their content matches the hashes too.
+func (c *Client) Verify(ctx context.Context, remote bool) ([]VerifyResult, error) {
+	headerFiles := c.HeaderFiles()
+
+	paths := make([]string, 0, len(headerFiles))
+	for relativePath := range headerFiles {
+		paths = append(paths, relativePath)
+	}
+	sort.Strings(paths)
+
+	results := make([]VerifyResult, 0, len(paths))
+	for _, relativePath := range paths {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		results = append(results, c.verifyFile(ctx, relativePath, remote))
+	}
+
+	err := filepath.WalkDir(c.FilesPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if c.Ignore.IgnoredAbs(path, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if relativePath := c.RelativePath(path); headerFiles[relativePath] == 0 {
+			results = append(results, VerifyResult{Path: relativePath, Status: VerifyNotUploaded})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return results, fmt.Errorf("walk dir: %w", err)
+	}
+
+	return results, nil
+}
+
+func (c *Client) verifyFile(ctx context.Context, relativePath string, remote bool) VerifyResult {
+	result := VerifyResult{Path: relativePath, Status: VerifyOK}
+
+	file, ok := c.FindFile(relativePath)
+	if !ok || file.Hash == "" {
+		result.Status = VerifyUnknown
+		return result
+	}
+
+	if remote {
+		hash := sha256.New()
+		if err := c.StreamFile(ctx, hash, relativePath); err != nil {
+			result.Status, result.Details = VerifyCorrupted, err.Error()
+			return result
+		}
+
+		if hex.EncodeToString(hash.Sum(nil)) != file.Hash {
+			result.Status, result.Details = VerifyCorrupted, "remote content does not match hash"
+			return result
+		}
+	}
+
+	// Ignored files are not expected to be on disk.
+	if c.Ignore.Ignored(relativePath, false) {
+		return result
+	}
+
+	localHash, err := fileHash(c.AbsPath(relativePath))
+	switch {
+	case errors.Is(err, os.ErrNotExist) && c.CloudOnly(relativePath, false):
+		result.Status = VerifyCloudOnly
+	case errors.Is(err, os.ErrNotExist):
+		result.Status = VerifyMissing
+	case err != nil:
+		result.Status, result.Details = VerifyModified, err.Error()
+	case localHash != file.Hash:
+		result.Status = VerifyModified
+	}
+
+	return result
+}
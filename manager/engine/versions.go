@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/tasks"
+)
+
+var ErrVersionNotFound = errors.New("version not found")
+
+// versionsPruneInterval is how often versions are checked for expired ones.
+const versionsPruneInterval = time.Hour
+
+// versioning reports whether previous versions of changed files are kept.
+func (c *Client) versioning() bool {
+	return c.VersionsKeep > 0 || c.VersionsRetention > 0
+}
+
+// FileVersions returns a copy of previous versions of the file, oldest first.
+func (c *Client) FileVersions(relativePath string) ([]manager.Version, bool) {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	if _, ok := c.PinnedHeader.Files[relativePath]; !ok {
+		return nil, false
+	}
+
+	return append([]manager.Version{}, c.PinnedHeader.Versions[relativePath]...), true
+}
+
+// versionMessageIDs returns IDs of all messages of the version.
+func (c *Client) versionMessageIDs(relativePath string, versionID int64) []int64 {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	for _, version := range c.PinnedHeader.Versions[relativePath] {
+		if version.ID == versionID {
+			return append([]int64{version.ID}, version.Parts...)
+		}
+	}
+
+	return nil
+}
+
+// addVersion adds previous version of the file and removes versions
+// that are out of limits. It returns IDs of messages of removed versions.
+func (c *Client) addVersion(relativePath string, version manager.Version) []int64 {
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	// Versions with unknown upload time are counted from now.
+	if version.UploadedAt.IsZero() {
+		version.UploadedAt = time.Now()
+	}
+
+	versions := append(c.PinnedHeader.Versions[relativePath], version)
+
+	var removed []manager.Version
+	if c.VersionsKeep > 0 && len(versions) > c.VersionsKeep {
+		removed = versions[:len(versions)-c.VersionsKeep]
+		versions = versions[len(versions)-c.VersionsKeep:]
+	}
+
+	if c.VersionsRetention > 0 {
+		expired := expiredVersions(versions, time.Now().Add(-c.VersionsRetention))
+		removed = append(removed, versions[:expired]...)
+		versions = versions[expired:]
+	}
+
+	c.PinnedHeader.Versions[relativePath] = versions
+
+	return versionsMessageIDs(removed)
+}
+
+// expiredVersions returns how many of the oldest versions were uploaded before threshold.
+func expiredVersions(versions []manager.Version, threshold time.Time) int {
+	expired := 0
+	for expired < len(versions) && versions[expired].UploadedAt.Before(threshold) {
+		expired++
+	}
+
+	return expired
+}
+
+// hasExpiredVersions reports whether any file has versions older than retention.
+func (c *Client) hasExpiredVersions() bool {
+	if c.VersionsRetention <= 0 {
+		return false
+	}
+
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	threshold := time.Now().Add(-c.VersionsRetention)
+	for _, versions := range c.PinnedHeader.Versions {
+		if expiredVersions(versions, threshold) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pruneVersions removes versions older than retention from the header
+// and returns IDs of their messages.
+func (c *Client) pruneVersions() []int64 {
+	if c.VersionsRetention <= 0 {
+		return nil
+	}
+
+	c.headerMu.Lock()
+	defer c.headerMu.Unlock()
+
+	threshold := time.Now().Add(-c.VersionsRetention)
+
+	var removed []manager.Version
+	for relativePath, versions := range c.PinnedHeader.Versions {
+		expired := expiredVersions(versions, threshold)
+		if expired == 0 {
+			continue
+		}
+
+		removed = append(removed, versions[:expired]...)
+		if expired == len(versions) {
+			delete(c.PinnedHeader.Versions, relativePath)
+		} else {
+			c.PinnedHeader.Versions[relativePath] = versions[expired:]
+		}
+	}
+
+	return versionsMessageIDs(removed)
+}
+
+// pruneVersionsPeriodically adds task to delete versions older than retention,
+// so they are removed even if files are not changed anymore.
+func (c *Client) pruneVersionsPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(versionsPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if c.hasExpiredVersions() {
+			c.AddTask(NewPruneVersions(c))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func versionsMessageIDs(versions []manager.Version) []int64 {
+	var msgIDs []int64
+	for _, version := range versions {
+		msgIDs = append(msgIDs, version.ID)
+		msgIDs = append(msgIDs, version.Parts...)
+	}
+
+	return msgIDs
+}
+
+// RestoreVersion replaces local file with its previous version,
+// which is then uploaded as the latest version. It returns after
+// both steps are done, with error of the step that failed.
+func (c *Client) RestoreVersion(ctx context.Context, relativePath string, versionID int64) error {
+	if len(c.versionMessageIDs(relativePath, versionID)) == 0 {
+		return ErrVersionNotFound
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var taskErr error
+	c.AddTask(WithCallback(NewRestoreVersion(c, relativePath, versionID), func(task tasks.Task) {
+		if task.Status() == tasks.TaskStatusError {
+			taskErr = fmt.Errorf("restore version: %s", task.Details())
+		}
+		cancel()
+	}))
+
+	<-subCtx.Done()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return taskErr
+}
+
+// fileVersionsMessageIDs returns IDs of messages of all previous versions of the file.
+func (c *Client) fileVersionsMessageIDs(relativePath string) []int64 {
+	c.headerMu.RLock()
+	defer c.headerMu.RUnlock()
+
+	return versionsMessageIDs(c.PinnedHeader.Versions[relativePath])
+}
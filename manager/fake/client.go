@@ -0,0 +1,248 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/ffenix113/teleporter/manager"
+)
+
+// progressSteps is the number of progress updates
+// sent during upload or download of a document.
+const progressSteps = 4
+
+// Client is a storage backend connected to the chat.
+type Client struct {
+	chat *Chat
+
+	mu             sync.Mutex
+	onHeader       []func(text string)
+	onState        []func(state string)
+	state          string
+	pendingHeaders []string
+	notifying      bool
+}
+
+var _ manager.Manager = (*Client)(nil)
+
+// SetState changes connection state of the client
+// and notifies state watchers.
+func (c *Client) SetState(state string) {
+	c.mu.Lock()
+	c.state = state
+	watchers := append([]func(string){}, c.onState...)
+	c.mu.Unlock()
+
+	for _, watcher := range watchers {
+		watcher(state)
+	}
+}
+
+func (c *Client) Put(ctx context.Context, localPath, caption string, progress func(int)) (int64, error) {
+	document, err := c.upload(ctx, localPath, progress)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.chat.send(document, caption)
+}
+
+func (c *Client) Replace(ctx context.Context, msgID int64, localPath, caption string, progress func(int)) error {
+	document, err := c.upload(ctx, localPath, progress)
+	if err != nil {
+		return err
+	}
+
+	return c.chat.editDocument(msgID, func(msg *Message) {
+		msg.Document = document
+		msg.Caption = caption
+	})
+}
+
+func (c *Client) SetCaption(ctx context.Context, msgID int64, caption string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.chat.editDocument(msgID, func(msg *Message) {
+		msg.Caption = caption
+	})
+}
+
+func (c *Client) Get(ctx context.Context, msgID int64, progress func(int)) (string, string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", err
+	}
+
+	msg, ok := c.chat.Message(msgID)
+	if !ok || msg.Document == nil {
+		return "", "", fmt.Errorf("document %d: %w", msgID, ErrMessageNotFound)
+	}
+
+	file, err := os.CreateTemp(c.chat.server.dir, "download_*")
+	if err != nil {
+		return "", "", fmt.Errorf("create download file: %w", err)
+	}
+	defer file.Close()
+
+	for step := 1; step <= progressSteps; step++ {
+		from, to := len(msg.Document)*(step-1)/progressSteps, len(msg.Document)*step/progressSteps
+		if _, err := file.Write(msg.Document[from:to]); err != nil {
+			os.Remove(file.Name())
+			return "", "", fmt.Errorf("write download file: %w", err)
+		}
+
+		progress(100 * step / progressSteps)
+	}
+
+	return file.Name(), msg.Caption, nil
+}
+
+func (c *Client) Caption(ctx context.Context, msgID int64) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	msg, ok := c.chat.Message(msgID)
+	if !ok || msg.Document == nil {
+		return "", fmt.Errorf("document %d: %w", msgID, ErrMessageNotFound)
+	}
+
+	return msg.Caption, nil
+}
+
+func (c *Client) Delete(_ context.Context, msgIDs ...int64) error {
+	if len(msgIDs) > MaxDeleteMessages {
+		return &manager.PermanentError{Err: fmt.Errorf("too many messages to delete: %d", len(msgIDs))}
+	}
+
+	c.chat.mu.Lock()
+	defer c.chat.mu.Unlock()
+
+	// Telegram silently ignores messages that do not exist.
+	for _, msgID := range msgIDs {
+		delete(c.chat.messages, msgID)
+	}
+
+	return nil
+}
+
+func (c *Client) List(ctx context.Context) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.chat.mu.Lock()
+	defer c.chat.mu.Unlock()
+
+	var ids []int64
+	for id, msg := range c.chat.messages {
+		if msg.Document != nil {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids, nil
+}
+
+func (c *Client) ReadHeader(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return c.chat.Header(), nil
+}
+
+func (c *Client) WriteHeader(ctx context.Context, text string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.chat.writeHeader(c, text)
+
+	return nil
+}
+
+func (c *Client) MaxHeaderLength() int {
+	return c.chat.server.MaxHeaderLength
+}
+
+func (c *Client) Watch(onHeader func(text string), onState func(state string)) {
+	c.mu.Lock()
+	if onHeader != nil {
+		c.onHeader = append(c.onHeader, onHeader)
+	}
+
+	if onState != nil {
+		c.onState = append(c.onState, onState)
+	}
+	state := c.state
+	c.mu.Unlock()
+
+	if onState != nil {
+		onState(state)
+	}
+}
+
+// upload reads local file, reporting progress same as Telegram
+// reports progress of the upload.
+func (c *Client) upload(ctx context.Context, localPath string, progress func(int)) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	document, err := os.ReadFile(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("read local file: %w", err)
+	}
+
+	for step := 1; step <= progressSteps; step++ {
+		progress(100 * step / progressSteps)
+	}
+
+	return document, nil
+}
+
+// headerChanged notifies header watchers about the new header.
+//
+// Watchers are called outside of the caller goroutine,
+// same as handlers of tdlib updates, but in order of changes.
+func (c *Client) headerChanged(text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pendingHeaders = append(c.pendingHeaders, text)
+	if c.notifying {
+		return
+	}
+
+	c.notifying = true
+	go c.notifyHeaders()
+}
+
+func (c *Client) notifyHeaders() {
+	for {
+		c.mu.Lock()
+		if len(c.pendingHeaders) == 0 {
+			c.notifying = false
+			c.mu.Unlock()
+			return
+		}
+
+		text := c.pendingHeaders[0]
+		c.pendingHeaders = c.pendingHeaders[1:]
+		watchers := append([]func(string){}, c.onHeader...)
+		c.mu.Unlock()
+
+		for _, watcher := range watchers {
+			watcher(text)
+		}
+	}
+}
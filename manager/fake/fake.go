@@ -0,0 +1,212 @@
+// Package fake provides in-memory storage backend,
+// which simulates Telegram chats without network.
+//
+// It is meant to be used in tests.
+package fake
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ffenix113/teleporter/manager"
+)
+
+// DefaultMaxHeaderLength is the same as the max length of Telegram text message.
+const DefaultMaxHeaderLength = 4096
+
+// MaxDeleteMessages is the max number of messages that Telegram deletes by one request.
+const MaxDeleteMessages = 100
+
+// ErrMessageNotFound is returned when message does not exist in the chat.
+var ErrMessageNotFound error = &manager.PermanentError{Err: errors.New("message not found")}
+
+// Server holds chats, which are shared between clients.
+type Server struct {
+	// MaxHeaderLength is returned by clients as the max length of the header.
+	MaxHeaderLength int
+
+	// dir is where downloaded documents are stored.
+	dir    string
+	mu     sync.Mutex
+	chats  map[int64]*Chat
+	nextID int64
+}
+
+// NewServer returns a server, which stores downloaded documents in dir.
+func NewServer(dir string) *Server {
+	return &Server{
+		MaxHeaderLength: DefaultMaxHeaderLength,
+		dir:             dir,
+		chats:           map[int64]*Chat{},
+	}
+}
+
+// Chat returns the chat with provided ID, creating it if it does not exist.
+func (s *Server) Chat(chatID int64) *Chat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.chats[chatID]
+	if !ok {
+		chat = &Chat{ID: chatID, server: s, messages: map[int64]*Message{}}
+		s.chats[chatID] = chat
+	}
+
+	return chat
+}
+
+func (s *Server) newMessageID() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+
+	return s.nextID
+}
+
+// Message is a message in the chat.
+type Message struct {
+	ID int64
+	// Text is set for text messages.
+	Text string
+	// Document is set for messages with documents.
+	Document []byte
+	Caption  string
+}
+
+// Chat is a chat in which files and the header are stored.
+type Chat struct {
+	ID     int64
+	server *Server
+
+	mu       sync.Mutex
+	messages map[int64]*Message
+	pinnedID int64
+	clients  []*Client
+	// sendErrors are returned by next sends of documents.
+	sendErrors []error
+}
+
+// Connect returns a new client connected to the chat.
+func (c *Chat) Connect() *Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cl := &Client{chat: c, state: "Ready"}
+	c.clients = append(c.clients, cl)
+
+	return cl
+}
+
+// FailSend makes next sends of documents fail with provided errors,
+// one error per send.
+func (c *Chat) FailSend(errs ...error) {
+	c.mu.Lock()
+	c.sendErrors = append(c.sendErrors, errs...)
+	c.mu.Unlock()
+}
+
+// Messages returns copies of all messages in the chat, ordered by ID.
+func (c *Chat) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msgs := make([]Message, 0, len(c.messages))
+	for _, msg := range c.messages {
+		msgs = append(msgs, *msg)
+	}
+
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].ID < msgs[j].ID
+	})
+
+	return msgs
+}
+
+// Message returns a copy of the message.
+func (c *Chat) Message(msgID int64) (Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg, ok := c.messages[msgID]
+	if !ok {
+		return Message{}, false
+	}
+
+	return *msg, true
+}
+
+// Header returns text of the pinned message.
+func (c *Chat) Header() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if msg, ok := c.messages[c.pinnedID]; ok {
+		return msg.Text
+	}
+
+	return ""
+}
+
+// SetHeader replaces text of the pinned message, as other device would do.
+// All connected clients are notified about the change.
+func (c *Chat) SetHeader(text string) {
+	c.writeHeader(nil, text)
+}
+
+func (c *Chat) writeHeader(author *Client, text string) {
+	c.mu.Lock()
+	msg, ok := c.messages[c.pinnedID]
+	if !ok {
+		msg = &Message{ID: c.server.newMessageID()}
+		c.messages[msg.ID] = msg
+		c.pinnedID = msg.ID
+	}
+	msg.Text = text
+
+	clients := append([]*Client{}, c.clients...)
+	c.mu.Unlock()
+
+	for _, cl := range clients {
+		if cl != author {
+			cl.headerChanged(text)
+		}
+	}
+}
+
+func (c *Chat) send(document []byte, caption string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.sendErrors) != 0 {
+		err := c.sendErrors[0]
+		c.sendErrors = c.sendErrors[1:]
+
+		return 0, fmt.Errorf("send failed: %w", err)
+	}
+
+	if document == nil {
+		document = []byte{}
+	}
+
+	msg := &Message{ID: c.server.newMessageID(), Document: document, Caption: caption}
+	c.messages[msg.ID] = msg
+
+	return msg.ID, nil
+}
+
+func (c *Chat) editDocument(msgID int64, edit func(msg *Message)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg, ok := c.messages[msgID]
+	if !ok || msg.Document == nil {
+		return fmt.Errorf("document %d: %w", msgID, ErrMessageNotFound)
+	}
+
+	edit(msg)
+
+	return nil
+}
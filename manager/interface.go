@@ -2,6 +2,10 @@ package manager
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
 	"time"
 )
 
@@ -17,18 +21,165 @@ type File struct {
 	FileUpdatedAt time.Time `json:",omitempty"`
 
 	IsDir bool `json:",omitempty"`
+	// PartSize is set for files that are split into multiple parts.
+	// Each part except the last one has exactly this size.
+	PartSize int64 `json:",omitempty"`
+	// Part specifies index of the file part stored in the message.
+	Part int `json:",omitempty"`
+	// Hash is hex encoded SHA-256 of the file content, before encryption.
+	Hash string `json:",omitempty"`
 	// Encrypted is a base64 of encrypted fields above.
 	Encrypted string `json:",omitempty"`
 }
 
+// Version is a previous revision of the file, which is kept in the chat.
+type Version struct {
+	// ID is the ID of the message with the first part of the version.
+	ID int64
+	// Parts holds message IDs of additional parts, same as PinnedHeader.Parts.
+	Parts      []int64 `json:",omitempty"`
+	Size       int64
+	UploadedAt time.Time
+}
+
+// TrashEntry is a deleted file, which messages are kept till trash retention ends.
+type TrashEntry struct {
+	Path string
+	// ID is the ID of the message with the first part of the file.
+	ID        int64
+	Parts     []int64   `json:",omitempty"`
+	Versions  []Version `json:",omitempty"`
+	Size      int64     `json:",omitempty"`
+	DeletedAt time.Time
+}
+
 type PinnedHeader struct {
-	Header string           // Constant value to be able to search for this message
-	Files  map[string]int64 `json:",omitempty"` // Map filepath -> messageID
-	// Encrypted is a base64 of encrypted fields above.
+	Header string // Constant value to be able to search for this message
+	// Salt is used to derive encryption key from passphrase.
+	// It is not encrypted, so other clients are able to derive the same key.
+	Salt  string           `json:",omitempty"`
+	Files map[string]int64 `json:",omitempty"` // Map filepath -> messageID
+	// Parts holds message IDs of additional parts for files
+	// that are larger than the part size. First part is in Files.
+	Parts map[string][]int64 `json:",omitempty"` // Map filepath -> messageIDs
+	// Versions holds previous versions of the files, oldest first.
+	// It is used only when versioning is enabled.
+	Versions map[string][]Version `json:",omitempty"`
+	// Trash holds deleted files, which messages are not deleted yet.
+	Trash []TrashEntry `json:",omitempty"`
+	// Document is set when the header does not fit into one message.
+	// It is an ID of the message with document that holds the full header,
+	// while pinned message holds only this pointer.
+	Document int64 `json:",omitempty"`
+	// Encrypted is a base64 of encrypted Files, Parts, Versions and Trash.
 	Encrypted string `json:",omitempty"`
 }
 
+// Manager is a storage backend, which keeps files and the header.
+//
+// Files are stored as documents with captions in messages,
+// which are identified by IDs assigned by the backend.
 type Manager interface {
-	ListAllFiles() []*File
-	GetFile(ctx context.Context, path string) ([]byte, error)
+	// Put uploads local file as a new message with provided caption
+	// and returns ID of the message.
+	Put(ctx context.Context, localPath, caption string, progress func(int)) (int64, error)
+	// Replace replaces document and caption of the message.
+	Replace(ctx context.Context, msgID int64, localPath, caption string, progress func(int)) error
+	// SetCaption replaces caption of the message.
+	SetCaption(ctx context.Context, msgID int64, caption string) error
+	// Get downloads document of the message and returns path
+	// of the downloaded file and the caption.
+	// Caller is responsible for removing returned file.
+	Get(ctx context.Context, msgID int64, progress func(int)) (string, string, error)
+	// Caption returns caption of the message without downloading the document.
+	Caption(ctx context.Context, msgID int64) (string, error)
+	// Delete deletes messages.
+	Delete(ctx context.Context, msgIDs ...int64) error
+	// List returns IDs of all messages with documents.
+	List(ctx context.Context) ([]int64, error)
+	// ReadHeader returns text of the header.
+	// It returns empty string if header was not written yet.
+	ReadHeader(ctx context.Context) (string, error)
+	// WriteHeader replaces text of the header.
+	WriteHeader(ctx context.Context, text string) error
+	// MaxHeaderLength is the max length of the header text.
+	// Longer header must be stored as a document.
+	MaxHeaderLength() int
+	// Watch calls onHeader when header is changed by other client,
+	// and onState when connection state changes, starting with the current one.
+	Watch(onHeader func(text string), onState func(state string))
+}
+
+// RetryAfterError is returned by Manager when request was rate limited.
+type RetryAfterError struct {
+	After time.Duration
+	Err   error
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter returns the delay that backend demanded
+// before the next request, if err holds one.
+func RetryAfter(err error) (time.Duration, bool) {
+	var retryErr *RetryAfterError
+	if !errors.As(err, &retryErr) {
+		return 0, false
+	}
+
+	return retryErr.After, true
+}
+
+// PermanentError is returned by Manager when request will fail
+// if it is repeated, like when message does not exist or request is malformed.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// IsTransient reports whether request that failed with err
+// may succeed if it is repeated later.
+//
+// Errors are transient unless they are permanent errors of the backend,
+// missing files or data that can not be decoded.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if _, ok := RetryAfter(err); ok {
+		return true
+	}
+
+	var (
+		permanentErr *PermanentError
+		syntaxErr    *json.SyntaxError
+		typeErr      *json.UnmarshalTypeError
+		base64Err    base64.CorruptInputError
+	)
+
+	return !errors.As(err, &permanentErr) &&
+		!errors.As(err, &syntaxErr) &&
+		!errors.As(err, &typeErr) &&
+		!errors.As(err, &base64Err) &&
+		!errors.Is(err, fs.ErrNotExist)
+}
+
+// Session is implemented by backends that can access
+// other chats through the same session.
+type Session interface {
+	// ForChat returns backend for the chat, found by name if it is set.
+	ForChat(ctx context.Context, chatName string, chatID int64) (Manager, error)
 }
@@ -0,0 +1,218 @@
+// Package syncstate keeps the state of files at the time of last synchronization,
+// which is used to tell which side has changed the file since then.
+package syncstate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is the state of the file when it was last synchronized.
+type Entry struct {
+	Path string
+	// Hash is the hash of the content that is the same locally and remotely.
+	Hash string `json:",omitempty"`
+	// MessageID is the ID of the first message of the file.
+	MessageID int64 `json:",omitempty"`
+	// ModTime is the modification time of the local file.
+	ModTime time.Time
+	// Deleted is set when file is removed from the state.
+	Deleted bool `json:",omitempty"`
+}
+
+// DB is an append-only file with entries, same as tasks journal.
+// Only last entry of the path is relevant.
+//
+// Nil DB is valid: it does not store anything.
+type DB struct {
+	file    *os.File
+	enc     *json.Encoder
+	entries map[string]Entry
+	mu      sync.Mutex
+}
+
+// Open opens or creates state file, compacting it.
+func Open(statePath string) (*DB, error) {
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return nil, fmt.Errorf("create state dir: %w", err)
+	}
+
+	entries, err := readEntries(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpPath := statePath + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("create compacted state: %w", err)
+	}
+
+	enc := json.NewEncoder(tmpFile)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			tmpFile.Close()
+			return nil, fmt.Errorf("write compacted state: %w", err)
+		}
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return nil, fmt.Errorf("close compacted state: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, statePath); err != nil {
+		return nil, fmt.Errorf("replace state: %w", err)
+	}
+
+	db := &DB{entries: entries}
+
+	db.file, err = os.OpenFile(statePath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open state: %w", err)
+	}
+
+	db.enc = json.NewEncoder(db.file)
+
+	return db, nil
+}
+
+func readEntries(statePath string) (map[string]Entry, error) {
+	entries := map[string]Entry{}
+
+	f, err := os.Open(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+
+		return nil, fmt.Errorf("open state: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Last line may be partially written if process was killed.
+			log.Printf("skip broken state entry: %s\n", err.Error())
+			continue
+		}
+
+		if entry.Deleted {
+			delete(entries, entry.Path)
+			continue
+		}
+
+		entries[entry.Path] = entry
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Get returns the state of the file at last synchronization.
+func (db *DB) Get(relativePath string) (Entry, bool) {
+	if db == nil {
+		return Entry{}, false
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	entry, ok := db.entries[relativePath]
+
+	return entry, ok
+}
+
+// Set records that file is synchronized.
+func (db *DB) Set(entry Entry) {
+	if db == nil {
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.entries[entry.Path] = entry
+	db.write(entry)
+}
+
+// Delete removes the file from the state.
+func (db *DB) Delete(relativePath string) {
+	if db == nil {
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.entries[relativePath]; !ok {
+		return
+	}
+
+	delete(db.entries, relativePath)
+	db.write(Entry{Path: relativePath, Deleted: true})
+}
+
+// DeleteDir removes all files in the directory from the state.
+func (db *DB) DeleteDir(relativeDirPath string) {
+	if db == nil {
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for filePath := range db.entries {
+		if strings.HasPrefix(filePath, relativeDirPath) {
+			delete(db.entries, filePath)
+			db.write(Entry{Path: filePath, Deleted: true})
+		}
+	}
+}
+
+// Move moves the state of the file to the new path.
+func (db *DB) Move(oldPath, newPath string) {
+	if db == nil {
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	entry, ok := db.entries[oldPath]
+	if !ok {
+		return
+	}
+
+	delete(db.entries, oldPath)
+	db.write(Entry{Path: oldPath, Deleted: true})
+
+	entry.Path = newPath
+	db.entries[newPath] = entry
+	db.write(entry)
+}
+
+func (db *DB) write(entry Entry) {
+	if err := db.enc.Encode(entry); err != nil {
+		log.Printf("write state entry: %s\n", err.Error())
+	}
+}
+
+func (db *DB) Close() error {
+	if db == nil {
+		return nil
+	}
+
+	return db.file.Close()
+}
@@ -0,0 +1,168 @@
+package tasks
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// entry holds the task with the data that monitor needs to track it.
+type entry struct {
+	Task
+	// id is the sequence number of the task,
+	// which is the same as in the journal if journal is used.
+	id uint64
+
+	mu         sync.Mutex
+	cancel     context.CancelFunc
+	cancelled  bool
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+
+	// Last published state of the running task.
+	// Guarded by Monitor.tasksMu.
+	lastProgress int
+	lastStatus   string
+	// retired is set when task will not be run anymore.
+	// Guarded by Monitor.tasksMu.
+	retired bool
+}
+
+// Info is a snapshot of the task state.
+type Info struct {
+	ID         uint64
+	Type       string
+	Name       string
+	Status     string
+	Progress   int
+	Details    string     `json:",omitempty"`
+	Attempts   int        `json:",omitempty"`
+	NextRetry  *time.Time `json:",omitempty"`
+	CreatedAt  time.Time
+	StartedAt  *time.Time `json:",omitempty"`
+	FinishedAt *time.Time `json:",omitempty"`
+}
+
+func (e *entry) ID() uint64 {
+	return e.id
+}
+
+func (e *entry) Info() Info {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return Info{
+		ID:         e.id,
+		Type:       e.Type(),
+		Name:       e.Name(),
+		Status:     e.statusLocked().String(),
+		Progress:   e.Progress(),
+		Details:    e.Details(),
+		Attempts:   e.Attempts(),
+		NextRetry:  timeOrNil(e.NextRetry()),
+		CreatedAt:  e.createdAt,
+		StartedAt:  timeOrNil(e.startedAt),
+		FinishedAt: timeOrNil(e.finishedAt),
+	}
+}
+
+// record returns journal record with current state of the task.
+func (e *entry) record() JournalRecord {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	record := JournalRecord{
+		Seq:     e.id,
+		Type:    e.Type(),
+		Name:    e.Name(),
+		Details: e.Details(),
+		Status:  e.statusLocked(),
+		Created: e.createdAt,
+		Time:    time.Now(),
+	}
+
+	if sourced, ok := e.Task.(SourcedTask); ok {
+		record.Source = sourced.Source()
+	}
+
+	if withArgs, ok := e.Task.(ArgsTask); ok {
+		record.Args = withArgs.Args()
+	}
+
+	return record
+}
+
+// Status reports task as cancelled if it was cancelled,
+// regardless of the status that task has set itself.
+func (e *entry) Status() TaskStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.statusLocked()
+}
+
+func (e *entry) statusLocked() TaskStatus {
+	if e.cancelled {
+		return TaskStatusCancelled
+	}
+
+	return e.Task.Status()
+}
+
+// Cancellable reports whether task can still be cancelled.
+func (e *entry) Cancellable() bool {
+	status := e.Status()
+
+	return status == TaskStatusNew || status == TaskStatusInProgress
+}
+
+func (e *entry) isCancelled() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.cancelled
+}
+
+// start returns context for the task run, which is cancelled
+// when the task is cancelled.
+func (e *entry) start(ctx context.Context) (context.Context, context.CancelFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	e.cancel = cancel
+	e.startedAt = time.Now()
+	e.finishedAt = time.Time{}
+	// Task could be cancelled after it was picked to run.
+	if e.cancelled {
+		cancel()
+	}
+
+	return taskCtx, cancel
+}
+
+func (e *entry) done() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.finishedAt = time.Now()
+}
+
+func (e *entry) doCancel() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.cancelled = true
+	if e.cancel != nil {
+		e.cancel()
+	}
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
@@ -0,0 +1,239 @@
+package tasks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const DefaultJournalRetention = 24 * time.Hour
+
+// JournalRecord is a state of the task at some point in time.
+type JournalRecord struct {
+	Seq     uint64
+	Type    string
+	Name    string
+	Details string `json:",omitempty"`
+	Source  string `json:",omitempty"`
+	// Args hold task parameters that are not part of its name.
+	Args    map[string]string `json:",omitempty"`
+	Status  TaskStatus
+	Created time.Time
+	Time    time.Time
+}
+
+func (r JournalRecord) finished() bool {
+	return r.Status == TaskStatusDone || r.Status == TaskStatusError || r.Status == TaskStatusCancelled
+}
+
+// SourcedTask is implemented by tasks that operate on two paths,
+// like moves. Name of such task is the destination path.
+type SourcedTask interface {
+	Source() string
+}
+
+// ArgsTask is implemented by tasks that need more
+// than name and source to be restored from the journal.
+type ArgsTask interface {
+	Args() map[string]string
+}
+
+// TaskFactory recreates task from its journal record.
+type TaskFactory func(record JournalRecord) (Task, error)
+
+// journalCompactRecords is the number of records written
+// after which the journal is compacted.
+const journalCompactRecords = 1000
+
+// Journal is an append-only file with states of the tasks,
+// so unfinished tasks can be restored after restart.
+//
+// Each line of the file is a JSON encoded JournalRecord,
+// only last record of the task is relevant.
+type Journal struct {
+	path       string
+	retention  time.Duration
+	file       *os.File
+	enc        *json.Encoder
+	seq        uint64
+	unfinished []JournalRecord
+	// written is the number of records written since last compaction.
+	written int
+	mu      sync.Mutex
+}
+
+// OpenJournal opens or creates journal file.
+//
+// Records of tasks that were finished more than retention ago are removed,
+// when journal is opened and after every journalCompactRecords writes.
+func OpenJournal(journalPath string, retention time.Duration) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(journalPath), 0755); err != nil {
+		return nil, fmt.Errorf("create journal dir: %w", err)
+	}
+
+	j := &Journal{path: journalPath, retention: retention}
+
+	records, err := j.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		if record.Seq > j.seq {
+			j.seq = record.Seq
+		}
+
+		if !record.finished() {
+			j.unfinished = append(j.unfinished, record)
+		}
+	}
+
+	return j, nil
+}
+
+// compact rewrites the journal with only latest records of the tasks,
+// removing tasks finished more than retention ago, and reopens it.
+// Latest records of all tasks are returned.
+func (j *Journal) compact() ([]JournalRecord, error) {
+	records, err := readJournal(j.path)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpPath := j.path + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("create compacted journal: %w", err)
+	}
+
+	threshold := time.Now().Add(-j.retention)
+	enc := json.NewEncoder(tmpFile)
+	for _, record := range records {
+		if record.finished() && !record.Time.After(threshold) {
+			continue
+		}
+
+		if err := enc.Encode(record); err != nil {
+			tmpFile.Close()
+			return nil, fmt.Errorf("write compacted journal: %w", err)
+		}
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return nil, fmt.Errorf("close compacted journal: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return nil, fmt.Errorf("replace journal: %w", err)
+	}
+
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+
+	if j.file != nil {
+		j.file.Close()
+	}
+
+	j.file = file
+	j.enc = json.NewEncoder(file)
+	j.written = 0
+
+	return records, nil
+}
+
+// readJournal returns the latest record of each task in order of task addition.
+func readJournal(journalPath string) ([]JournalRecord, error) {
+	f, err := os.Open(journalPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	defer f.Close()
+
+	var order []uint64
+	latest := map[uint64]JournalRecord{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record JournalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// Last line may be partially written if process was killed.
+			log.Printf("skip broken journal record: %s\n", err.Error())
+			continue
+		}
+
+		prev, ok := latest[record.Seq]
+		if !ok {
+			order = append(order, record.Seq)
+		}
+
+		// Records are written without holding lock of the monitor,
+		// so older state of the task may be written after newer one.
+		if !ok || !record.Time.Before(prev.Time) {
+			latest[record.Seq] = record
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+
+	records := make([]JournalRecord, 0, len(order))
+	for _, seq := range order {
+		records = append(records, latest[seq])
+	}
+
+	return records, nil
+}
+
+// Unfinished returns records of tasks that were not finished
+// when journal was opened.
+func (j *Journal) Unfinished() []JournalRecord {
+	return j.unfinished
+}
+
+// NextSeq returns sequence number for the new task.
+func (j *Journal) NextSeq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.seq++
+
+	return j.seq
+}
+
+// Write records current state of the task.
+func (j *Journal) Write(record JournalRecord) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.enc.Encode(record); err != nil {
+		log.Printf("write journal record: %s\n", err.Error())
+		return
+	}
+
+	j.written++
+	if j.written < journalCompactRecords {
+		return
+	}
+
+	if _, err := j.compact(); err != nil {
+		log.Printf("compact journal: %s\n", err.Error())
+		// Compaction is retried after next batch of records.
+		j.written = 0
+	}
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,63 @@
+package tasks
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy specifies how failed tasks are restarted.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of times task will be run.
+	// Value of 1 or less disables retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry.
+	// Each next retry doubles the delay.
+	BaseDelay time.Duration
+	// MaxDelay limits the delay between retries.
+	MaxDelay time.Duration
+	// Jitter is a fraction of the delay that is randomly added or subtracted,
+	// so tasks that failed together will not be retried together.
+	Jitter float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   5 * time.Second,
+	MaxDelay:    10 * time.Minute,
+	Jitter:      0.2,
+}
+
+// Retryable is implemented by tasks that can be restarted after failure.
+type Retryable interface {
+	// Retry prepares task to be run again after provided time.
+	// It returns false if task can not be retried.
+	Retry(at time.Time) bool
+	// RetryAfter returns delay demanded by the failure, if any.
+	// For example Telegram will ask to wait before next request.
+	RetryAfter() (time.Duration, bool)
+}
+
+// Finisher is implemented by tasks that need to know when they are finished:
+// done, cancelled or failed without further retries.
+type Finisher interface {
+	Finished()
+}
+
+// Delay returns delay before the next run of the task
+// that already was run provided number of times.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+
+	return delay
+}
@@ -2,9 +2,14 @@ package tasks
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/ffenix113/teleporter/events"
 )
 
 const (
@@ -12,6 +17,12 @@ const (
 	TaskStatusInProgress
 	TaskStatusDone
 	TaskStatusError
+	TaskStatusCancelled
+)
+
+var (
+	ErrTaskNotFound      = errors.New("task not found")
+	ErrTaskNotCancelable = errors.New("task is already finished")
 )
 
 type TaskStatus int
@@ -26,6 +37,8 @@ func (s TaskStatus) String() string {
 		return "done"
 	case TaskStatusError:
 		return "error"
+	case TaskStatusCancelled:
+		return "cancelled"
 	default:
 		return fmt.Sprintf("unknown(%d)", s)
 	}
@@ -38,19 +51,96 @@ type Task interface {
 	Progress() int
 	Status() TaskStatus
 	Details() string
+	// Attempts returns how many times task was started.
+	Attempts() int
+	// NextRetry returns time after which failed task will be run again.
+	// It is zero if task is not scheduled for retry.
+	NextRetry() time.Time
 }
 
+// Hook is called for each added task. It can replace the task
+// and reports whether it is finished and must not be called anymore.
 type Hook func(task Task) (Task, bool, error)
 
+const DefaultWorkers = 4
+
+// DefaultKeepFinished is how many finished tasks are listed by default.
+const DefaultKeepFinished = 1000
+
+type Config struct {
+	// Workers is the number of concurrently executed tasks.
+	Workers int
+	// TypeLimits limits how many tasks of the same type can be executed concurrently.
+	// Types that are not present are limited only by number of workers.
+	TypeLimits map[string]int
+	// Retry specifies how failed tasks are restarted.
+	Retry RetryPolicy
+	// KeepFinished is how many finished tasks are kept to be listed,
+	// older ones are forgotten. Defaults to DefaultKeepFinished.
+	KeepFinished int
+	// Journal is used to persist tasks. Can be nil.
+	Journal *Journal
+	// Events receives changes of the tasks. Can be nil.
+	Events *events.Bus
+	// Paused makes monitor start without executing tasks till it is resumed.
+	Paused bool
+}
+
+// progressInterval is how often progress of running tasks is published.
+const progressInterval = 500 * time.Millisecond
+
 type Monitor struct {
-	preAddHook []Hook
+	preAddHooks []preAddHook
+	lastHookID  uint64
 
-	tasks   []Task
+	// tasks holds all unfinished tasks and up to keepFinished finished ones.
+	tasks []*entry
+	// pending holds tasks that were not started yet, in order of addition.
+	pending []*entry
+	// runningTypes holds number of running tasks per task type.
+	runningTypes map[string]int
+	// runningNames holds names of running tasks, so only one
+	// task at a time is running for the same file.
+	runningNames map[string]struct{}
+	running      map[*entry]struct{}
+	// lastID is the ID of the last added task, if journal is not used.
+	lastID  uint64
+	paused  bool
 	tasksMu sync.Mutex
+
+	workers      int
+	typeLimits   map[string]int
+	retry        RetryPolicy
+	keepFinished int
+	journal      *Journal
+	events       *events.Bus
+	wakeup       chan struct{}
 }
 
-func NewMonitor(ctx context.Context) *Monitor {
-	m := &Monitor{}
+// NewMonitor creates monitor that executes tasks concurrently
+// and restarts failed tasks.
+func NewMonitor(ctx context.Context, cnf Config) *Monitor {
+	if cnf.Workers <= 0 {
+		cnf.Workers = DefaultWorkers
+	}
+
+	if cnf.KeepFinished <= 0 {
+		cnf.KeepFinished = DefaultKeepFinished
+	}
+
+	m := &Monitor{
+		runningTypes: map[string]int{},
+		runningNames: map[string]struct{}{},
+		running:      map[*entry]struct{}{},
+		workers:      cnf.Workers,
+		typeLimits:   cnf.TypeLimits,
+		retry:        cnf.Retry,
+		keepFinished: cnf.KeepFinished,
+		journal:      cnf.Journal,
+		events:       cnf.Events,
+		paused:       cnf.Paused,
+		wakeup:       make(chan struct{}, 1),
+	}
 
 	go m.Run(ctx)
 
@@ -61,63 +151,497 @@ func (m *Monitor) AddTask(task Task) {
 	m.tasksMu.Lock()
 	var err error
 	var finished bool
-	for i, h := range m.preAddHook {
-		task, finished, err = h(task)
+	hooks := make([]preAddHook, 0, len(m.preAddHooks))
+	for _, h := range m.preAddHooks {
+		task, finished, err = h.hook(task)
 		if err != nil {
 			panic(fmt.Errorf("pre-add hook: %w", err))
 		}
 
-		if finished {
-			// FIXME: panics on 'i' here
-			m.preAddHook = append(m.preAddHook[:i], m.preAddHook[i+1:]...)
+		if !finished {
+			hooks = append(hooks, h)
 		}
 	}
+	m.preAddHooks = hooks
+
+	e := &entry{Task: task, createdAt: time.Now()}
+	if m.journal != nil {
+		e.id = m.journal.NextSeq()
+	} else {
+		m.lastID++
+		e.id = m.lastID
+	}
+	// Record is written after unlock, so journal I/O does not block other calls.
+	record := e.record()
 
-	m.tasks = append(m.tasks, task)
+	m.tasks = append(m.tasks, e)
+	m.pending = append(m.pending, e)
 	m.tasksMu.Unlock()
+
+	m.write(record)
+
+	m.publish(events.TaskCreated, e)
+	m.notify()
+}
+
+// Replay adds unfinished tasks from the journal.
+//
+// Tasks are recreated by the factory registered for the task type,
+// tasks without factory are skipped.
+func (m *Monitor) Replay(factories map[string]TaskFactory) {
+	if m.journal == nil {
+		return
+	}
+
+	for _, record := range m.journal.Unfinished() {
+		factory, ok := factories[record.Type]
+		if !ok {
+			log.Printf("no factory to replay task %s %q\n", record.Type, record.Name)
+			continue
+		}
+
+		task, err := factory(record)
+		if err != nil {
+			log.Printf("replay task %s %q: %s\n", record.Type, record.Name, err.Error())
+			continue
+		}
+
+		m.tasksMu.Lock()
+		e := &entry{Task: task, id: record.Seq, createdAt: record.Created}
+		m.tasks = append(m.tasks, e)
+		m.pending = append(m.pending, e)
+		m.tasksMu.Unlock()
+
+		m.publish(events.TaskCreated, e)
+	}
+
+	m.notify()
+}
+
+// Unfinished returns records of tasks that were not finished
+// when journal was opened, whether they were replayed or not.
+func (m *Monitor) Unfinished() []JournalRecord {
+	if m.journal == nil {
+		return nil
+	}
+
+	return m.journal.Unfinished()
 }
 
-func (m *Monitor) AddPreAddHook(hook Hook) {
+type preAddHook struct {
+	id   uint64
+	hook Hook
+}
+
+// AddPreAddHook adds hook that is called for each added task till it is finished.
+// Returned function removes the hook, if it is not finished yet.
+func (m *Monitor) AddPreAddHook(hook Hook) (remove func()) {
 	m.tasksMu.Lock()
 	defer m.tasksMu.Unlock()
 
-	m.preAddHook = append(m.preAddHook, hook)
+	m.lastHookID++
+	id := m.lastHookID
+	m.preAddHooks = append(m.preAddHooks, preAddHook{id: id, hook: hook})
+
+	return func() {
+		m.tasksMu.Lock()
+		defer m.tasksMu.Unlock()
+
+		hooks := make([]preAddHook, 0, len(m.preAddHooks))
+		for _, h := range m.preAddHooks {
+			if h.id != id {
+				hooks = append(hooks, h)
+			}
+		}
+		m.preAddHooks = hooks
+	}
 }
 
+// Run starts workers and blocks till context is done.
 func (m *Monitor) Run(ctx context.Context) {
-	var taskIdx int
-	var task Task
+	var wg sync.WaitGroup
+	wg.Add(m.workers + 1)
 
+	go func() {
+		defer wg.Done()
+		m.watchProgress(ctx)
+	}()
+
+	for i := 0; i < m.workers; i++ {
+		go func() {
+			defer wg.Done()
+			m.work(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (m *Monitor) work(ctx context.Context) {
 	for ctx.Err() == nil {
+		e, ok := m.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+			case <-m.wakeup:
+			case <-time.After(500 * time.Millisecond):
+			}
+			continue
+		}
+
+		taskCtx, cancel := e.start(ctx)
+		e.Run(taskCtx)
+		cancel()
+		e.done()
+
+		// Retry is scheduled before task is finished,
+		// so waiting for tasks does not see it as idle in between.
+		retried := e.Status() == TaskStatusError && m.scheduleRetry(e)
+
+		m.finish(e)
+
+		m.record(e)
+		m.publish(events.TaskStatus, e)
+
+		if !retried {
+			m.retire(e)
+			finished(e)
+		}
+	}
+}
+
+// watchProgress publishes changes of progress and status of running tasks.
+func (m *Monitor) watchProgress(ctx context.Context) {
+	if m.events == nil {
+		return
+	}
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
 		m.tasksMu.Lock()
-		if taskIdx == len(m.tasks) {
-			m.tasksMu.Unlock()
-			time.Sleep(500 * time.Millisecond)
+		for e := range m.running {
+			info := e.Info()
+			if info.Status != e.lastStatus {
+				e.lastStatus = info.Status
+				m.events.Publish(events.TaskStatus, info)
+			}
+
+			if info.Progress != e.lastProgress {
+				e.lastProgress = info.Progress
+				m.events.Publish(events.TaskProgress, info)
+			}
+		}
+		m.tasksMu.Unlock()
+	}
+}
+
+func (m *Monitor) publish(eventType string, e *entry) {
+	if m.events != nil {
+		m.events.Publish(eventType, e.Info())
+	}
+}
+
+// record writes current state of the task to the journal.
+func (m *Monitor) record(e *entry) {
+	if m.journal != nil {
+		m.journal.Write(e.record())
+	}
+}
+
+// write writes the record to the journal.
+func (m *Monitor) write(record JournalRecord) {
+	if m.journal != nil {
+		m.journal.Write(record)
+	}
+}
+
+// Cancel cancels the task with provided ID.
+//
+// Pending task will not be started, while running task
+// will have its context cancelled.
+func (m *Monitor) Cancel(id uint64) error {
+	m.tasksMu.Lock()
+
+	for _, e := range m.tasks {
+		if e.id != id {
 			continue
 		}
 
-		task = m.tasks[taskIdx]
+		if !e.Cancellable() {
+			m.tasksMu.Unlock()
+			return ErrTaskNotCancelable
+		}
+
+		e.doCancel()
+
+		// Running task is recorded and finished by the worker when it returns.
+		var record *JournalRecord
+		for i, pending := range m.pending {
+			if pending == e {
+				m.pending = append(m.pending[:i], m.pending[i+1:]...)
+				r := e.record()
+				record = &r
+				break
+			}
+		}
+		m.tasksMu.Unlock()
+
+		m.publish(events.TaskStatus, e)
+
+		if record != nil {
+			m.write(*record)
+			m.retire(e)
+			finished(e)
+		}
+
+		return nil
+	}
+	m.tasksMu.Unlock()
+
+	return ErrTaskNotFound
+}
+
+// Pause stops starting new tasks. Running tasks will continue.
+func (m *Monitor) Pause() {
+	m.tasksMu.Lock()
+	defer m.tasksMu.Unlock()
+
+	m.paused = true
+}
+
+func (m *Monitor) Resume() {
+	m.tasksMu.Lock()
+	m.paused = false
+	m.tasksMu.Unlock()
+
+	m.notify()
+}
+
+func (m *Monitor) Paused() bool {
+	m.tasksMu.Lock()
+	defer m.tasksMu.Unlock()
+
+	return m.paused
+}
+
+// Wait blocks till there are no pending or running tasks,
+// including failed tasks waiting for retry.
+func (m *Monitor) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		m.tasksMu.Lock()
+		idle := len(m.pending) == 0 && len(m.running) == 0
 		m.tasksMu.Unlock()
 
-		task.Run(ctx)
+		if idle {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// next returns first pending task that is allowed to run.
+func (m *Monitor) next() (*entry, bool) {
+	m.tasksMu.Lock()
+	defer m.tasksMu.Unlock()
+
+	if m.paused {
+		return nil, false
+	}
+
+	now := time.Now()
+	for i, task := range m.pending {
+		if task.NextRetry().After(now) {
+			continue
+		}
+
+		if limit, ok := m.typeLimits[task.Type()]; ok && m.runningTypes[task.Type()] >= limit {
+			continue
+		}
+
+		if _, ok := m.runningNames[task.Name()]; ok {
+			continue
+		}
+
+		m.pending = append(m.pending[:i], m.pending[i+1:]...)
+		m.runningTypes[task.Type()]++
+		m.runningNames[task.Name()] = struct{}{}
+		m.running[task] = struct{}{}
+
+		return task, true
+	}
+
+	return nil, false
+}
+
+func (m *Monitor) finish(task *entry) {
+	m.tasksMu.Lock()
+	m.runningTypes[task.Type()]--
+	delete(m.runningNames, task.Name())
+	delete(m.running, task)
+	m.tasksMu.Unlock()
+
+	m.notify()
+}
+
+// scheduleRetry will return failed task to pending tasks
+// if it can be retried according to the retry policy.
+// It reports whether task will be retried.
+func (m *Monitor) scheduleRetry(task *entry) bool {
+	retryable, ok := task.Task.(Retryable)
+	if !ok || task.isCancelled() || task.Attempts() >= m.retry.MaxAttempts {
+		return false
+	}
+
+	delay := m.retry.Delay(task.Attempts())
+	if demanded, ok := retryable.RetryAfter(); ok {
+		delay = demanded
+	}
+
+	if !retryable.Retry(time.Now().Add(delay)) {
+		return false
+	}
+
+	m.tasksMu.Lock()
+	m.pending = append(m.pending, task)
+	m.tasksMu.Unlock()
+
+	return true
+}
+
+// retire marks the task as finished and forgets the oldest
+// finished tasks, so no more than keepFinished of them are kept.
+func (m *Monitor) retire(task *entry) {
+	m.tasksMu.Lock()
+	defer m.tasksMu.Unlock()
+
+	task.retired = true
+
+	retired := 0
+	for _, e := range m.tasks {
+		if e.retired {
+			retired++
+		}
+	}
+
+	excess := retired - m.keepFinished
+	if excess <= 0 {
+		return
+	}
+
+	kept := make([]*entry, 0, len(m.tasks)-excess)
+	for _, e := range m.tasks {
+		if e.retired && excess > 0 {
+			excess--
+			continue
+		}
+
+		kept = append(kept, e)
+	}
+	m.tasks = kept
+}
 
-		taskIdx++
-		// TODO: maybe restart if task failed
-		time.Sleep(2 * time.Second)
+// finished notifies the task that it will not be run anymore.
+func finished(task *entry) {
+	if finisher, ok := task.Task.(Finisher); ok {
+		finisher.Finished()
+	}
+}
+
+// notify wakes up one of the idle workers.
+func (m *Monitor) notify() {
+	select {
+	case m.wakeup <- struct{}{}:
+	default:
 	}
 }
 
 func (m *Monitor) List(offset, limit int) []Task {
-	tasks := m.tasks
+	m.tasksMu.Lock()
+	defer m.tasksMu.Unlock()
 
-	if offset >= len(tasks) {
+	if offset >= len(m.tasks) {
 		return nil
 	}
 
-	start := tasks[offset:]
+	start := m.tasks[offset:]
 	if limit > len(start) {
 		limit = len(start)
 	}
 
-	return start[:limit]
+	tasks := make([]Task, 0, limit)
+	for _, e := range start[:limit] {
+		tasks = append(tasks, e)
+	}
+
+	return tasks
+}
+
+// Filter is used to select tasks. Empty fields match any task.
+type Filter struct {
+	Status string
+	Type   string
+	// Path matches tasks which name starts with it.
+	Path string
+}
+
+func (f Filter) match(info Info) bool {
+	return (f.Status == "" || f.Status == info.Status) &&
+		(f.Type == "" || f.Type == info.Type) &&
+		strings.HasPrefix(info.Name, f.Path)
+}
+
+// Find returns info of the tasks that match the filter.
+func (m *Monitor) Find(filter Filter, offset, limit int) []Info {
+	m.tasksMu.Lock()
+	defer m.tasksMu.Unlock()
+
+	infos := []Info{}
+	for _, e := range m.tasks {
+		if len(infos) == limit {
+			break
+		}
+
+		info := e.Info()
+		if !filter.match(info) {
+			continue
+		}
+
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+func (m *Monitor) Get(id uint64) (Info, bool) {
+	m.tasksMu.Lock()
+	defer m.tasksMu.Unlock()
+
+	for _, e := range m.tasks {
+		if e.id == id {
+			return e.Info(), true
+		}
+	}
+
+	return Info{}, false
 }
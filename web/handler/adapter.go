@@ -12,6 +12,8 @@ import (
 // from the hanlder.
 var ErrDone = errors.New("done")
 var ErrNotFound = errors.New("not found")
+var ErrBadRequest = errors.New("bad request")
+var ErrConflict = errors.New("conflict")
 
 // NoResponse is the special type that means
 // that there will be no response from the handler.
@@ -30,6 +32,12 @@ func Wrap[T any](handler func(w http.ResponseWriter, r *http.Request) (T, error)
 			case errors.Is(err, ErrNotFound):
 				http.NotFound(w, r)
 				return
+			case errors.Is(err, ErrBadRequest):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			case errors.Is(err, ErrConflict):
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
 			}
 
 			log.Printf("handler failed: %s\n", err.Error())
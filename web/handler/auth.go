@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ffenix113/teleporter/auth"
+)
+
+type AuthHandler struct {
+	flow *auth.Flow
+}
+
+func NewAuthHandler(flow *auth.Flow) *AuthHandler {
+	return &AuthHandler{flow: flow}
+}
+
+func (h AuthHandler) State(_ http.ResponseWriter, _ *http.Request) (auth.State, error) {
+	return h.flow.State(), nil
+}
+
+// Phone sends phone number from "phone" form field.
+// Auth data is not accepted in query, so it does not get into logs.
+func (h AuthHandler) Phone(_ http.ResponseWriter, r *http.Request) (auth.State, error) {
+	return h.send(r, "phone", h.flow.SendPhone)
+}
+
+func (h AuthHandler) Code(_ http.ResponseWriter, r *http.Request) (auth.State, error) {
+	return h.send(r, "code", h.flow.SendCode)
+}
+
+func (h AuthHandler) Password(_ http.ResponseWriter, r *http.Request) (auth.State, error) {
+	return h.send(r, "password", h.flow.SendPassword)
+}
+
+func (h AuthHandler) send(r *http.Request, field string, send func(string) (auth.State, error)) (auth.State, error) {
+	value := r.PostFormValue(field)
+	if value == "" {
+		return auth.State{}, fmt.Errorf("%w: %s is required", ErrBadRequest, field)
+	}
+
+	state, err := send(value)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnexpectedState) {
+			return state, fmt.Errorf("%w: %s", ErrConflict, err.Error())
+		}
+
+		return state, fmt.Errorf("%w: %s", ErrBadRequest, err.Error())
+	}
+
+	return state, nil
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/ffenix113/teleporter/auth"
+	"github.com/ffenix113/teleporter/auth/authtest"
+	"github.com/ffenix113/teleporter/config"
+	"github.com/ffenix113/teleporter/manager"
+)
+
+func TestAuthHandler(t *testing.T) {
+	t.Setenv("TELEGRAM_PHONE", "")
+	t.Setenv("TELEGRAM_PASSWORD", "")
+
+	flow, err := auth.NewFlow(authtest.NewAuthorizer(""), config.Telegram{})
+	if err != nil {
+		t.Fatalf("new flow: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Flow refreshes the state and starts the backend once it is authorized.
+	runErr := make(chan error, 1)
+	go func() { runErr <- flow.Run(ctx) }()
+
+	h := NewAuthHandler(flow)
+	r := chi.NewRouter()
+	r.Get("/auth/state", Wrap(h.State))
+	r.Post("/auth/phone", Wrap(h.Phone))
+	r.Post("/auth/code", Wrap(h.Code))
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// Wait for the flow to learn that phone is requested.
+	for flow.State().State != manager.AuthWaitPhone {
+		select {
+		case err := <-runErr:
+			t.Fatalf("run: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		form  url.Values
+		code  int
+		state string
+	}{
+		{name: "missing field", path: "/auth/phone", form: url.Values{}, code: http.StatusBadRequest},
+		{name: "code before phone", path: "/auth/code", form: url.Values{"code": {"12345"}}, code: http.StatusConflict},
+		{name: "wrong phone", path: "/auth/phone", form: url.Values{"phone": {"+200"}}, code: http.StatusBadRequest},
+		{name: "phone", path: "/auth/phone", form: url.Values{"phone": {"+100"}}, code: http.StatusOK, state: manager.AuthWaitCode},
+		{name: "wrong code", path: "/auth/code", form: url.Values{"code": {"00000"}}, code: http.StatusBadRequest},
+		{name: "code", path: "/auth/code", form: url.Values{"code": {"12345"}}, code: http.StatusOK, state: manager.AuthReady},
+	}
+
+	for _, tt := range tests {
+		resp, err := http.Post(srv.URL+tt.path, "application/x-www-form-urlencoded", strings.NewReader(tt.form.Encode()))
+		if err != nil {
+			t.Fatalf("%s: post: %v", tt.name, err)
+		}
+
+		var state auth.State
+		if tt.code == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&state)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.code || err != nil {
+			t.Fatalf("%s: want: %d, got: %d, %v", tt.name, tt.code, resp.StatusCode, err)
+		}
+
+		if tt.code == http.StatusOK && state.State != tt.state {
+			t.Fatalf("%s: state: want: %s, got: %s", tt.name, tt.state, state.State)
+		}
+	}
+
+	if err := <-runErr; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	resp, err := http.Get(srv.URL + "/auth/state")
+	if err != nil {
+		t.Fatalf("get state: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var state auth.State
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil || state.State != manager.AuthReady {
+		t.Fatalf("state must be ready: %+v, %v", state, err)
+	}
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// keepAliveInterval is how often comment is sent to idle event stream,
+// so proxies do not close it and closed clients are noticed.
+const keepAliveInterval = 15 * time.Second
+
+// Events streams task and connection changes as Server-Sent Events.
+//
+// Each event is sent with its type as event name
+// and JSON encoded data.
+func (h Handler) Events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := h.cl.Events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+
+			flusher.Flush()
+		case event := <-events:
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				log.Printf("marshal event %s: %s\n", event.Type, err.Error())
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+
+			flusher.Flush()
+		}
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"path"
@@ -15,7 +17,7 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"github.com/ffenix113/teleporter/manager"
-	"github.com/ffenix113/teleporter/manager/arman92"
+	"github.com/ffenix113/teleporter/manager/engine"
 	"github.com/ffenix113/teleporter/tasks"
 )
 
@@ -23,71 +25,204 @@ const MaxDownloadSize = 60 * 1024 * 1024 // 60 MB
 const MaxUploadSize = 10 * 1024 * 1024   // 10 MB
 
 type Handler struct {
-	cl *arman92.Client
+	cl *engine.Client
+	// maxDownloadSize limits size of not chunked files served from files dir.
+	// Negative value disables the limit.
+	maxDownloadSize int64
 }
 
-func NewHandler(cl *arman92.Client) *Handler {
-	return &Handler{cl: cl}
+// NewHandler returns handler of the client routes.
+// Default max download size is used if it is zero.
+func NewHandler(cl *engine.Client, maxDownloadSize int64) *Handler {
+	if maxDownloadSize == 0 {
+		maxDownloadSize = MaxDownloadSize
+	}
+
+	return &Handler{cl: cl, maxDownloadSize: maxDownloadSize}
+}
+
+// FileEntry is the file info with its state on this device.
+type FileEntry struct {
+	*manager.File
+	// CloudOnly is set if file is not downloaded to this device.
+	CloudOnly bool `json:",omitempty"`
+	// Local is set if local copy of the file exists.
+	Local bool `json:",omitempty"`
 }
 
-func (h Handler) FileList(_ http.ResponseWriter, r *http.Request) ([]*manager.File, error) {
+func (h Handler) FileList(_ http.ResponseWriter, r *http.Request) ([]FileEntry, error) {
 	pathKey := strings.TrimSuffix(chi.URLParam(r, "*"), "/")
 
-	tree, ok := manager.FindInTree[*manager.Tree](h.cl.FileTree, pathKey)
+	files, ok := h.cl.ListDir(pathKey)
 	if !ok {
 		return nil, ErrNotFound
 	}
 
-	return tree.FilesInfo(), nil
+	// Path of the file itself is requested.
+	if len(files) == 1 && !files[0].IsDir {
+		if _, isFile := h.cl.FindFile(pathKey); isFile {
+			pathKey = path.Dir(pathKey)
+		}
+	}
+
+	entries := make([]FileEntry, 0, len(files))
+	for _, file := range files {
+		relativePath := path.Join(pathKey, file.Name)
+
+		entry := FileEntry{File: file, CloudOnly: h.cl.CloudOnly(relativePath, file.IsDir)}
+		if !file.IsDir {
+			_, err := os.Stat(h.cl.AbsPath(relativePath))
+			entry.Local = err == nil
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
 }
 
-func (h Handler) PathDelete(_ http.ResponseWriter, r *http.Request) (NoResponse, error) {
+// PathDelete deletes file or directory with all files in it.
+// It responds with result of deletion of each file.
+func (h Handler) PathDelete(_ http.ResponseWriter, r *http.Request) ([]engine.DeleteResult, error) {
 	pathKey := strings.TrimSuffix(chi.URLParam(r, "*"), "/")
 
-	v, ok := manager.FindInTree[*manager.Tree](h.cl.FileTree, pathKey)
-	if !ok {
+	if _, ok := h.cl.ListDir(pathKey); !ok {
 		return nil, ErrNotFound
 	}
-	_ = v
 
+	if _, ok := h.cl.FindFile(pathKey); !ok {
+		if pathKey == "" {
+			return nil, fmt.Errorf("%w: root directory can not be deleted", ErrBadRequest)
+		}
+
+		results, err := h.cl.DeleteDir(r.Context(), pathKey)
+		if err != nil {
+			return nil, fmt.Errorf("delete dir with path %q error: %w", pathKey, err)
+		}
+
+		return results, nil
+	}
+
+	result := engine.DeleteResult{Path: pathKey}
 	if err := h.cl.DeleteFile(r.Context(), pathKey); err != nil {
-		return nil, fmt.Errorf("delete file with path %q error: %w", pathKey, err)
+		result.Error = err.Error()
 	}
 
-	return nil, nil
+	return []engine.DeleteResult{result}, nil
 }
 
+// FileDownload serves content of the file, supporting range
+// and conditional requests.
+//
+// File is served from disk if it is downloaded. Otherwise it is fetched
+// into the cache, or streamed from the chat if it does not fit into the cache.
 func (h Handler) FileDownload(w http.ResponseWriter, r *http.Request) {
 	pathKey := strings.TrimSuffix(chi.URLParam(r, "*"), "/")
 
-	cachedFile, ok := manager.FindInTree[*manager.File](h.cl.FileTree, pathKey)
+	cachedFile, ok := h.cl.FindFile(pathKey)
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	if cachedFile.Size > MaxDownloadSize {
-		log.Printf("file is larger then limit: %d > %d", cachedFile.Size, MaxDownloadSize)
-		w.WriteHeader(http.StatusRequestEntityTooLarge)
-		return
-	}
-
-	w.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(pathKey))
-	w.Header().Set("Content-Type", "application/octet-stream")
+	// Hash identifies the content only if it is the uploaded one.
+	etag, modTime := cachedFile.Hash, cachedFile.FileUpdatedAt
 
+	var content io.ReadSeeker
 	dFile, err := os.Open(h.cl.AbsPath(pathKey))
-	if err != nil {
+	switch {
+	case err == nil:
+		defer dFile.Close()
+		content = dFile
+
+		stat, err := dFile.Stat()
+		if err != nil {
+			log.Printf("stat file: %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Chunked files are served part by part when they are not downloaded,
+		// so they are not limited.
+		if h.maxDownloadSize >= 0 && stat.Size() > h.maxDownloadSize && !h.cl.IsChunked(pathKey) {
+			log.Printf("file is larger then limit: %d > %d", stat.Size(), h.maxDownloadSize)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if !stat.ModTime().Equal(cachedFile.FileUpdatedAt) {
+			etag, modTime = "", stat.ModTime()
+		}
+	case !errors.Is(err, fs.ErrNotExist):
 		log.Printf("open file: %s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
+	case h.cl.Cacheable(pathKey):
+		cached, err := h.cl.OpenCached(r.Context(), pathKey)
+		if err != nil {
+			log.Printf("open cached file: %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer cached.Close()
+
+		content = cached
+	default:
+		remote, err := h.cl.OpenRemote(r.Context(), pathKey)
+		if err != nil {
+			log.Printf("open remote file: %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer remote.Close()
+
+		streamed := &erroredReader{ReadSeeker: remote}
+		// Status is already sent when streaming fails, so the response
+		// is aborted for the client not to take partial content as complete.
+		defer func() {
+			if streamed.err != nil {
+				log.Printf("stream remote file: %s", streamed.err.Error())
+				panic(http.ErrAbortHandler)
+			}
+		}()
+
+		content = streamed
 	}
-	defer dFile.Close()
 
-	if _, err := io.Copy(w, dFile); err != nil {
-		log.Printf("copy file on download: %s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	w.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(pathKey))
+	w.Header().Set("Content-Type", contentType(pathKey))
+	if etag != "" {
+		w.Header().Set("ETag", `"`+etag+`"`)
+	}
+
+	http.ServeContent(w, r, "", modTime, content)
+}
+
+// erroredReader remembers the error of the read, other than io.EOF.
+type erroredReader struct {
+	io.ReadSeeker
+	err error
+}
+
+func (r *erroredReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.err = err
+	}
+
+	return n, err
+}
+
+// contentType returns type of media file by its extension, so browsers
+// can play and seek it. Other files are sent as binary, which is
+// not compressed by middleware, as it would break ranges.
+func contentType(filePath string) string {
+	typ := mime.TypeByExtension(path.Ext(filePath))
+	if strings.HasPrefix(typ, "video/") || strings.HasPrefix(typ, "audio/") {
+		return typ
 	}
+
+	return "application/octet-stream"
 }
 
 func (h Handler) FileUpload(w http.ResponseWriter, r *http.Request) (NoResponse, error) {
@@ -106,6 +241,11 @@ func (h Handler) FileUpload(w http.ResponseWriter, r *http.Request) (NoResponse,
 		return nil, fmt.Errorf("file is larger then limit: %d > %d", header.Size, MaxUploadSize)
 	}
 
+	// Ignored file will not be uploaded, so there is no reason to wait for it.
+	if h.cl.Ignore.Ignored(path.Join(pathKey, header.Filename), false) {
+		return nil, fmt.Errorf("%w: path is ignored: %q", ErrBadRequest, path.Join(pathKey, header.Filename))
+	}
+
 	f, err := os.CreateTemp(h.cl.TempPath, "*_"+header.Filename)
 	if err != nil {
 		return nil, fmt.Errorf("create temp file: %w", err)
@@ -129,18 +269,20 @@ func (h Handler) FileUpload(w http.ResponseWriter, r *http.Request) (NoResponse,
 	defer cancel()
 
 	// Wait for file upload and wait for its finish
-	h.cl.AddPreAddHook(func(task tasks.Task) (tasks.Task, bool, error) {
+	removeHook := h.cl.AddPreAddHook(func(task tasks.Task) (tasks.Task, bool, error) {
 		filePath := path.Join(pathKey, header.Filename)
 
-		uploadTask, isUpload := task.(*arman92.UploadFile)
+		uploadTask, isUpload := task.(*engine.UploadFile)
 		if !isUpload || uploadTask.RelativePath != filePath {
 			return task, false, nil
 		}
 
-		return arman92.WithCallback(task, func(task tasks.Task) {
+		return engine.WithCallback(task, func(task tasks.Task) {
 			cancel()
 		}), true, nil
 	})
+	// Hook is not left behind if request is done before upload task is added.
+	defer removeHook()
 	<-c.Done()
 
 	return nil, nil
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/ffenix113/teleporter/manager/engine"
+)
+
+// SelectionList returns directories switched between synced and cloud only,
+// true if directory is synced.
+func (h Handler) SelectionList(_ http.ResponseWriter, _ *http.Request) (map[string]bool, error) {
+	return h.cl.Selection.Dirs(), nil
+}
+
+// DirSync makes files of the directory to be downloaded to this device.
+func (h Handler) DirSync(_ http.ResponseWriter, r *http.Request) (NoResponse, error) {
+	pathKey := strings.TrimSuffix(chi.URLParam(r, "*"), "/")
+
+	if err := h.checkDir(pathKey); err != nil {
+		return nil, err
+	}
+
+	if _, err := h.cl.SetSynced(pathKey, true); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// DirCloudOnly removes local copies of files in the directory,
+// keeping them only in the chat. It responds with result
+// of removal of each local file.
+func (h Handler) DirCloudOnly(_ http.ResponseWriter, r *http.Request) ([]engine.EvictResult, error) {
+	pathKey := strings.TrimSuffix(chi.URLParam(r, "*"), "/")
+
+	if err := h.checkDir(pathKey); err != nil {
+		return nil, err
+	}
+
+	return h.cl.SetSynced(pathKey, false)
+}
+
+func (h Handler) checkDir(pathKey string) error {
+	if _, ok := h.cl.ListDir(pathKey); !ok {
+		return ErrNotFound
+	}
+
+	if _, ok := h.cl.FindFile(pathKey); ok {
+		return fmt.Errorf("%w: path is not a directory: %q", ErrBadRequest, pathKey)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/ffenix113/teleporter/tasks"
+)
+
+const DefaultTasksLimit = 100
+
+func (h Handler) TaskList(_ http.ResponseWriter, r *http.Request) ([]tasks.Info, error) {
+	query := r.URL.Query()
+
+	offset, err := intQuery(query.Get("offset"), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := limitQuery(query.Get("limit"))
+	if err != nil {
+		return nil, err
+	}
+
+	return h.cl.TaskMonitor.Find(tasks.Filter{
+		Status: query.Get("status"),
+		Type:   query.Get("type"),
+		Path:   query.Get("path"),
+	}, offset, limit), nil
+}
+
+func (h Handler) TaskGet(_ http.ResponseWriter, r *http.Request) (tasks.Info, error) {
+	id, err := taskID(r)
+	if err != nil {
+		return tasks.Info{}, err
+	}
+
+	info, ok := h.cl.TaskMonitor.Get(id)
+	if !ok {
+		return tasks.Info{}, ErrNotFound
+	}
+
+	return info, nil
+}
+
+func (h Handler) TaskCancel(_ http.ResponseWriter, r *http.Request) (NoResponse, error) {
+	id, err := taskID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.cl.TaskMonitor.Cancel(id); err != nil {
+		switch {
+		case errors.Is(err, tasks.ErrTaskNotFound):
+			return nil, ErrNotFound
+		case errors.Is(err, tasks.ErrTaskNotCancelable):
+			return nil, fmt.Errorf("%w: %s", ErrConflict, err.Error())
+		}
+
+		return nil, fmt.Errorf("cancel task %d: %w", id, err)
+	}
+
+	return nil, nil
+}
+
+func (h Handler) TasksPause(_ http.ResponseWriter, _ *http.Request) (NoResponse, error) {
+	h.cl.TaskMonitor.Pause()
+
+	return nil, nil
+}
+
+func (h Handler) TasksResume(_ http.ResponseWriter, _ *http.Request) (NoResponse, error) {
+	h.cl.TaskMonitor.Resume()
+
+	return nil, nil
+}
+
+func taskID(r *http.Request) (uint64, error) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: parse task id: %s", ErrBadRequest, err.Error())
+	}
+
+	return id, nil
+}
+
+// limitQuery returns page size, which is DefaultTasksLimit
+// if it is not set or is not positive.
+func limitQuery(query string) (int, error) {
+	if query == "" {
+		return DefaultTasksLimit, nil
+	}
+
+	val, err := strconv.Atoi(query)
+	if err != nil {
+		return 0, fmt.Errorf("%w: wrong number: %q", ErrBadRequest, query)
+	}
+
+	if val <= 0 {
+		return DefaultTasksLimit, nil
+	}
+
+	return val, nil
+}
+
+func intQuery(query string, def int) (int, error) {
+	if query == "" {
+		return def, nil
+	}
+
+	val, err := strconv.Atoi(query)
+	if err != nil || val < 0 {
+		return 0, fmt.Errorf("%w: wrong number: %q", ErrBadRequest, query)
+	}
+
+	return val, nil
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/manager/engine"
+)
+
+func (h Handler) TrashList(_ http.ResponseWriter, _ *http.Request) ([]manager.TrashEntry, error) {
+	return h.cl.Trash(), nil
+}
+
+// TrashRestore restores file with provided ID from trash to its original path.
+func (h Handler) TrashRestore(_ http.ResponseWriter, r *http.Request) (NoResponse, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid trash entry ID: %s", ErrBadRequest, err.Error())
+	}
+
+	if err := h.cl.RestoreFromTrash(r.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, engine.ErrTrashEntryNotFound):
+			return nil, ErrNotFound
+		case errors.Is(err, engine.ErrPathExists):
+			return nil, fmt.Errorf("%w: %s", ErrConflict, err.Error())
+		}
+
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (h Handler) TrashEmpty(_ http.ResponseWriter, r *http.Request) (NoResponse, error) {
+	return nil, h.cl.EmptyTrash(r.Context())
+}
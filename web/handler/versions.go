@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/manager/engine"
+)
+
+// FileVersions lists previous versions of the file, oldest first.
+func (h Handler) FileVersions(_ http.ResponseWriter, r *http.Request) ([]manager.Version, error) {
+	pathKey := strings.TrimSuffix(chi.URLParam(r, "*"), "/")
+
+	versions, ok := h.cl.FileVersions(pathKey)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return versions, nil
+}
+
+// FileRestore replaces the file with its previous version,
+// specified by version ID in the "version" query parameter.
+func (h Handler) FileRestore(_ http.ResponseWriter, r *http.Request) (NoResponse, error) {
+	pathKey := strings.TrimSuffix(chi.URLParam(r, "*"), "/")
+
+	versionID, err := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid version: %s", ErrBadRequest, err.Error())
+	}
+
+	if err := h.cl.RestoreVersion(r.Context(), pathKey, versionID); err != nil {
+		if errors.Is(err, engine.ErrVersionNotFound) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return nil, nil
+}
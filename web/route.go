@@ -2,19 +2,25 @@ package web
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"github.com/ffenix113/teleporter/auth"
 	"github.com/ffenix113/teleporter/config"
-	"github.com/ffenix113/teleporter/manager/arman92"
+	"github.com/ffenix113/teleporter/manager"
+	"github.com/ffenix113/teleporter/manager/engine"
 	"github.com/ffenix113/teleporter/web/handler"
 	"github.com/ffenix113/teleporter/web/template"
 )
 
 type Middleware func(http.Handler) http.Handler
 
-func NewRouter(conf config.Config, cl *arman92.Client, templatesPath string) http.Handler {
+// NewRouter returns router of the web server.
+//
+// Login routes are added if flow is not nil, other routes are served by client routes.
+func NewRouter(conf config.Config, templatesPath string, flow *auth.Flow, clientRoutes http.Handler) http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Recoverer,
@@ -27,7 +33,102 @@ func NewRouter(conf config.Config, cl *arman92.Client, templatesPath string) htt
 		IPWhitelist(conf.App.IPWhitelist),
 	)
 
-	h := handler.NewHandler(cl)
+	if flow != nil {
+		ah := handler.NewAuthHandler(flow)
+
+		r.Get("/auth/state", handler.Wrap(ah.State))
+		r.Post("/auth/phone", handler.Wrap(ah.Phone))
+		r.Post("/auth/code", handler.Wrap(ah.Code))
+		r.Post("/auth/password", handler.Wrap(ah.Password))
+		r.Get("/login", func(writer http.ResponseWriter, request *http.Request) {
+			renderTemplate(writer, request, templatesPath, "login.html", map[string]interface{}{
+				"request": request,
+				"auth":    flow.State(),
+			})
+		})
+	}
+
+	r.Mount("/", clientRoutes)
+
+	return r
+}
+
+// ClientRoutes serves routes that need the client,
+// which is created once the backend is authorized.
+type ClientRoutes struct {
+	templatesPath   string
+	maxDownloadSize int64
+	flow            *auth.Flow
+
+	mu     sync.RWMutex
+	router http.Handler
+}
+
+func NewClientRoutes(app config.App, flow *auth.Flow) *ClientRoutes {
+	return &ClientRoutes{
+		templatesPath:   app.TemplatePath,
+		maxDownloadSize: int64(app.MaxDownloadSizeMB) * 1024 * 1024,
+		flow:            flow,
+	}
+}
+
+// Folder is a sync folder served by the web server.
+type Folder struct {
+	Name   string
+	Client *engine.Client
+}
+
+// SetFolders makes routes available.
+//
+// Routes of each folder are served under /folders/{name},
+// and routes of the first folder are also served from the root.
+func (c *ClientRoutes) SetFolders(folders []Folder) {
+	names := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		names = append(names, folder.Name)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/folders", handler.Wrap(func(_ http.ResponseWriter, _ *http.Request) ([]string, error) {
+		return names, nil
+	}))
+
+	for _, folder := range folders {
+		base := "/folders/" + folder.Name
+		router.Mount(base, c.newClientRouter(folder.Client, base, names))
+	}
+
+	router.Mount("/", c.newClientRouter(folders[0].Client, "", names))
+
+	c.mu.Lock()
+	c.router = router
+	c.mu.Unlock()
+}
+
+func (c *ClientRoutes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	router := c.router
+	c.mu.RUnlock()
+
+	if router != nil {
+		router.ServeHTTP(w, r)
+		return
+	}
+
+	if c.flow != nil && c.flow.State().State != manager.AuthReady && r.Method == http.MethodGet && r.URL.Path == "/" {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	http.Error(w, "client is not started yet", http.StatusServiceUnavailable)
+}
+
+// newClientRouter returns routes of the folder client.
+// Base is the path under which routes are served.
+func (c *ClientRoutes) newClientRouter(cl *engine.Client, base string, folders []string) http.Handler {
+	r := chi.NewRouter()
+
+	h := handler.NewHandler(cl, c.maxDownloadSize)
 
 	r.Get("/files/list", handler.Wrap(h.FileList)) // Route to match '/files/list/'
 	r.Get("/files/list/*", handler.Wrap(h.FileList))
@@ -36,30 +137,45 @@ func NewRouter(conf config.Config, cl *arman92.Client, templatesPath string) htt
 	r.Delete("/files/delete/*", handler.Wrap(h.PathDelete))
 	r.Post("/files/upload", handler.Wrap(h.FileUpload))
 	r.Post("/files/upload/*", handler.Wrap(h.FileUpload))
+	r.Get("/files/selection", handler.Wrap(h.SelectionList))
+	r.Post("/files/sync", handler.Wrap(h.DirSync))
+	r.Post("/files/sync/*", handler.Wrap(h.DirSync))
+	r.Post("/files/cloud-only", handler.Wrap(h.DirCloudOnly))
+	r.Post("/files/cloud-only/*", handler.Wrap(h.DirCloudOnly))
+	r.Get("/files/versions/*", handler.Wrap(h.FileVersions))
+	r.Post("/files/restore/*", handler.Wrap(h.FileRestore))
+	r.Get("/trash", handler.Wrap(h.TrashList))
+	r.Post("/trash/{id}/restore", handler.Wrap(h.TrashRestore))
+	r.Delete("/trash", handler.Wrap(h.TrashEmpty))
+	r.Get("/tasks", handler.Wrap(h.TaskList))
+	r.Get("/tasks/{id}", handler.Wrap(h.TaskGet))
+	r.Post("/tasks/pause", handler.Wrap(h.TasksPause))
+	r.Post("/tasks/resume", handler.Wrap(h.TasksResume))
+	r.Post("/tasks/{id}/cancel", handler.Wrap(h.TaskCancel))
+	r.Get("/events", h.Events)
 	// This is route to show tasks.
 	// Better would be to use Vue instead.
 	r.Get("/", func(writer http.ResponseWriter, request *http.Request) {
-		tpls := template.ReadTemplates(templatesPath)
-		tplName := request.RequestURI[1:]
-
-		if tplName == "" {
-			tplName = "index.html"
-		}
-
-		tpl := tpls.Lookup(tplName)
-		if tpl == nil {
-			http.NotFound(writer, request)
-			return
-		}
-
-		writer.Header().Set("Content-Type", "text/html")
-		if err := tpl.Execute(writer, map[string]interface{}{
+		renderTemplate(writer, request, c.templatesPath, "index.html", map[string]interface{}{
 			"request": request,
 			"client":  cl,
-		}); err != nil {
-			panic(err)
-		}
+			"base":    base,
+			"folders": folders,
+		})
 	})
 
 	return r
 }
+
+func renderTemplate(writer http.ResponseWriter, request *http.Request, templatesPath, tplName string, data map[string]interface{}) {
+	tpl := template.ReadTemplates(templatesPath).Lookup(tplName)
+	if tpl == nil {
+		http.NotFound(writer, request)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html")
+	if err := tpl.Execute(writer, data); err != nil {
+		panic(err)
+	}
+}
@@ -11,7 +11,19 @@
     <nav class="navbar navbar-light bg-light">
         <div class="container-fluid">
             <a class="navbar-brand">Teleporter</a>
-            <span class="navbar-brand mb-0 h1">State: {{ .client.ConnectionState }}</span>
+            {{ if gt (len .folders) 1 }}
+            <ul class="nav nav-pills">
+                {{ range .folders }}
+                <li class="nav-item"><a class="nav-link{{ if eq (print "/folders/" .) $.base }} active{{ end }}" href="/folders/{{ . }}/">{{ . }}</a></li>
+                {{ end }}
+            </ul>
+            {{ end }}
+            <span class="navbar-brand mb-0 h1">State: <span id="connection-state">{{ .client.ConnectionState }}</span></span>
+            {{ if .client.TaskMonitor.Paused }}
+            <button class="btn btn-outline-success" onclick="post('{{ .base }}/tasks/resume')">Resume</button>
+            {{ else }}
+            <button class="btn btn-outline-secondary" onclick="post('{{ .base }}/tasks/pause')">Pause</button>
+            {{ end }}
         </div>
     </nav>
 
@@ -21,10 +33,14 @@
     <th>Name</th>
     <th>Progress</th>
     <th>Status</th>
+    <th>Attempts</th>
+    <th>Next retry</th>
     <th>Details</th>
+    <th></th>
     </thead>
+    <tbody id="tasks">
     {{ range $idx, $task := .client.TaskMonitor.List (Offset .request) (Limit .request) }}
-    <tr>
+    <tr id="task-{{$task.ID}}">
         <th scope="row">{{$task.Type}}</th>
         <td>{{$task.Name}}</td>
         <td>
@@ -32,12 +48,89 @@
                 <div class="progress-bar" role="progressbar" style="width: {{$task.Progress}}%" aria-valuenow="{{$task.Progress}}" aria-valuemin="0" aria-valuemax="100">{{$task.Progress}}%</div>
             </div>
         </td>
-        <td>{{$task.Status}}</td>
+        <td class="task-status">{{$task.Status}}</td>
+        <td>{{$task.Attempts}}</td>
+        <td>{{ if not $task.NextRetry.IsZero }}{{$task.NextRetry.Format "15:04:05"}}{{end}}</td>
         <td>{{$task.Details}}</td>
+        <td>{{ if $task.Cancellable }}<button class="btn btn-sm btn-outline-danger" onclick="post('{{ $.base }}/tasks/{{$task.ID}}/cancel')">Cancel</button>{{end}}</td>
     </tr>
     {{end}}
+    </tbody>
 </table>
 </div>
 
+<script>
+    function post(url) {
+        fetch(url, {method: 'POST'}).then(() => location.reload());
+    }
+
+    const events = new EventSource('{{ .base }}/events');
+
+    function updateTask(e) {
+        const task = JSON.parse(e.data);
+        const row = document.getElementById('task-' + task.ID);
+        if (!row) {
+            return;
+        }
+
+        const bar = row.querySelector('.progress-bar');
+        bar.style.width = task.Progress + '%';
+        bar.setAttribute('aria-valuenow', task.Progress);
+        bar.textContent = task.Progress + '%';
+        row.querySelector('.task-status').textContent = task.Status;
+    }
+
+    // New tasks are added to the end of the list,
+    // so they are shown only if current page is not full.
+    const tasksLimit = {{ Limit .request }};
+
+    function addTask(e) {
+        const task = JSON.parse(e.data);
+        const rows = document.getElementById('tasks');
+        if (document.getElementById('task-' + task.ID) || rows.rows.length >= tasksLimit) {
+            return;
+        }
+
+        const row = rows.insertRow();
+        row.id = 'task-' + task.ID;
+
+        const type = document.createElement('th');
+        type.scope = 'row';
+        type.textContent = task.Type;
+        row.appendChild(type);
+
+        row.insertCell().textContent = task.Name;
+
+        const progress = document.createElement('div');
+        progress.className = 'progress';
+        const bar = document.createElement('div');
+        bar.className = 'progress-bar';
+        bar.setAttribute('role', 'progressbar');
+        bar.setAttribute('aria-valuemin', 0);
+        bar.setAttribute('aria-valuemax', 100);
+        progress.appendChild(bar);
+        row.insertCell().appendChild(progress);
+
+        row.insertCell().className = 'task-status';
+        row.insertCell().textContent = task.Attempts || 0;
+        row.insertCell().textContent = task.NextRetry ? new Date(task.NextRetry).toTimeString().slice(0, 8) : '';
+        row.insertCell().textContent = task.Details || '';
+
+        const cancel = document.createElement('button');
+        cancel.className = 'btn btn-sm btn-outline-danger';
+        cancel.textContent = 'Cancel';
+        cancel.onclick = () => post('{{ .base }}/tasks/' + task.ID + '/cancel');
+        row.insertCell().appendChild(cancel);
+
+        updateTask(e);
+    }
+
+    events.addEventListener('task.created', addTask);
+    events.addEventListener('task.progress', updateTask);
+    events.addEventListener('task.status', updateTask);
+    events.addEventListener('connection.state', (e) => {
+        document.getElementById('connection-state').textContent = JSON.parse(e.data).State;
+    });
+</script>
 </body>
 </html>
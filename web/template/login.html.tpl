@@ -0,0 +1,76 @@
+<!doctype html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.1.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-1BmE4kWBq78iYhFldvKuhfTAU6auU8tT94WrHftjDbrCEXSU1oBoqyl2QvZ6jIW3" crossorigin="anonymous">
+</head>
+<body>
+
+<div class="container">
+    <nav class="navbar navbar-light bg-light">
+        <div class="container-fluid">
+            <a class="navbar-brand">Teleporter</a>
+            <span class="navbar-brand mb-0 h1">Login: <span id="auth-state">{{ .auth.State }}</span></span>
+        </div>
+    </nav>
+
+    <div id="auth-error" class="alert alert-danger" {{ if not .auth.Error }}hidden{{ end }}>{{ .auth.Error }}</div>
+    <p id="auth-pending" hidden>Waiting for Telegram...</p>
+
+    <form id="WaitPhone" class="auth-form" data-url="/auth/phone" hidden>
+        <label class="form-label" for="phone">Phone number</label>
+        <input class="form-control" id="phone" name="phone" type="tel" autocomplete="tel">
+        <button class="btn btn-primary mt-2" type="submit">Send</button>
+    </form>
+    <form id="WaitCode" class="auth-form" data-url="/auth/code" hidden>
+        <label class="form-label" for="code">Code sent to Telegram</label>
+        <input class="form-control" id="code" name="code" autocomplete="one-time-code">
+        <button class="btn btn-primary mt-2" type="submit">Send</button>
+    </form>
+    <form id="WaitPassword" class="auth-form" data-url="/auth/password" hidden>
+        <label class="form-label" for="password">Password</label>
+        <input class="form-control" id="password" name="password" type="password" autocomplete="current-password">
+        <button class="btn btn-primary mt-2" type="submit">Send</button>
+    </form>
+</div>
+
+<script>
+    function showState(auth) {
+        if (auth.State === 'Ready') {
+            location.href = '/';
+            return;
+        }
+
+        document.getElementById('auth-state').textContent = auth.State;
+        document.getElementById('auth-pending').hidden = auth.State !== 'Pending';
+        for (const form of document.querySelectorAll('.auth-form')) {
+            form.hidden = form.id !== auth.State;
+        }
+    }
+
+    function showError(text) {
+        const error = document.getElementById('auth-error');
+        error.textContent = text;
+        error.hidden = !text;
+    }
+
+    for (const form of document.querySelectorAll('.auth-form')) {
+        form.addEventListener('submit', (e) => {
+            e.preventDefault();
+
+            fetch(form.dataset.url, {method: 'POST', body: new URLSearchParams(new FormData(form))})
+                .then((resp) => resp.ok ? resp.json().then((auth) => { showError(''); showState(auth); }) : resp.text().then(showError));
+            form.reset();
+        });
+    }
+
+    function poll() {
+        fetch('/auth/state').then((resp) => resp.json()).then(showState);
+    }
+
+    showState({State: '{{ .auth.State }}'});
+    setInterval(poll, 2000);
+</script>
+</body>
+</html>
@@ -26,7 +26,11 @@ func intQuery(query string, def int) (val int) {
 
 var tplFuncs = map[string]interface{}{
 	"Limit": func(r *http.Request) int {
-		return intQuery(r.URL.Query().Get("limit"), 100)
+		if limit := intQuery(r.URL.Query().Get("limit"), 100); limit > 0 {
+			return limit
+		}
+
+		return 100
 	},
 	"Offset": func(r *http.Request) int {
 		return intQuery(r.URL.Query().Get("offset"), 0)